@@ -0,0 +1,209 @@
+package m3u8
+
+/*
+ This file defines variant selection for adaptive bitrate decisions.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var ErrNoVariant = errors.New("no variant matches the device capabilities")
+
+// DeviceCapabilities describes a client for variant selection.
+// Zero values mean that a capability is not limited.
+type DeviceCapabilities struct {
+	Throughput   uint32   // Throughput is the available bandwidth in bits per second
+	MaxWidth     int      // MaxWidth is the largest video width in pixels
+	MaxHeight    int      // MaxHeight is the largest video height in pixels
+	MaxFrameRate float64  // MaxFrameRate is the largest frame rate
+	Codecs       []string // Codecs are the supported codec families, e.g. CodecAVC and CodecAAC
+	// HDCPLevel is the supported HDCP-LEVEL: NONE, TYPE-0 or TYPE-1. Empty means NONE,
+	// so variants that require HDCP are rejected.
+	HDCPLevel   string
+	VideoRanges []string // VideoRanges are the supported VIDEO-RANGE values, e.g. SDR and PQ
+	// CPC maps a KEYFORMAT to the content protection configurations of the client, as used in ALLOWED-CPC.
+	CPC              map[string][]string
+	AudioLanguage    string // AudioLanguage is the preferred audio language
+	SubtitleLanguage string // SubtitleLanguage selects subtitles in this language. Empty means no subtitles
+}
+
+// VariantDecision is the result of SelectVariant with the reasons for the decision.
+type VariantDecision struct {
+	Variant   *Variant     // Variant is the selected variant
+	Audio     *Alternative // Audio is the selected rendition of the AUDIO group, if any
+	Subtitles *Alternative // Subtitles is the selected rendition of the SUBTITLES group, if any
+	Reasons   []string     // Reasons explain why variants were rejected and why the variant was selected
+}
+
+// SelectVariant selects a variant of the master playlist for a client with the given capabilities.
+// I-frame variants are not considered. Variants are rejected if their resolution, frame rate,
+// codecs, HDCP-LEVEL, VIDEO-RANGE or ALLOWED-CPC are not supported. Of the remaining variants
+// with a BANDWIDTH not above the throughput, the one with the highest SCORE is selected, and
+// the highest BANDWIDTH among equal scores. If no variant fits the throughput, the one with the
+// lowest BANDWIDTH is selected.
+//
+// The audio rendition is selected from the AUDIO group of the variant by language, then
+// DEFAULT=YES, then the first one. Subtitles are selected by language if requested.
+func (p *MasterPlaylist) SelectVariant(caps DeviceCapabilities) (*VariantDecision, error) {
+	d := &VariantDecision{}
+	var eligible []*Variant
+	for _, v := range p.Variants {
+		if v.Iframe {
+			continue
+		}
+		if reason := caps.reject(v); reason != "" {
+			d.Reasons = append(d.Reasons, fmt.Sprintf("%s rejected: %s", v.URI, reason))
+			continue
+		}
+		eligible = append(eligible, v)
+	}
+	if len(eligible) == 0 {
+		return d, ErrNoVariant
+	}
+
+	var fitting []*Variant
+	for _, v := range eligible {
+		if caps.Throughput == 0 || v.Bandwidth <= caps.Throughput {
+			fitting = append(fitting, v)
+		} else {
+			d.Reasons = append(d.Reasons, fmt.Sprintf("%s rejected: BANDWIDTH %d exceeds throughput %d",
+				v.URI, v.Bandwidth, caps.Throughput))
+		}
+	}
+	if len(fitting) == 0 {
+		sort.SliceStable(eligible, func(i, j int) bool { return eligible[i].Bandwidth < eligible[j].Bandwidth })
+		d.Variant = eligible[0]
+		d.Reasons = append(d.Reasons, fmt.Sprintf("%s selected: lowest BANDWIDTH %d, no variant fits throughput %d",
+			d.Variant.URI, d.Variant.Bandwidth, caps.Throughput))
+	} else {
+		sort.SliceStable(fitting, func(i, j int) bool {
+			if fitting[i].Score != fitting[j].Score {
+				return fitting[i].Score > fitting[j].Score
+			}
+			return fitting[i].Bandwidth > fitting[j].Bandwidth
+		})
+		d.Variant = fitting[0]
+		d.Reasons = append(d.Reasons, fmt.Sprintf("%s selected: SCORE %g and BANDWIDTH %d fit throughput %d",
+			d.Variant.URI, d.Variant.Score, d.Variant.Bandwidth, caps.Throughput))
+	}
+
+	d.Audio = selectRendition(d.Variant, "AUDIO", d.Variant.Audio, caps.AudioLanguage, true)
+	if caps.SubtitleLanguage != "" {
+		d.Subtitles = selectRendition(d.Variant, "SUBTITLES", d.Variant.Subtitles, caps.SubtitleLanguage, false)
+	}
+	return d, nil
+}
+
+// reject returns why the variant cannot be played, or "" if it can.
+func (caps DeviceCapabilities) reject(v *Variant) string {
+	if v.Resolution != "" && (caps.MaxWidth > 0 || caps.MaxHeight > 0) {
+		w, h, err := parseResolution(v.Resolution)
+		if err != nil {
+			return err.Error()
+		}
+		if (caps.MaxWidth > 0 && w > caps.MaxWidth) || (caps.MaxHeight > 0 && h > caps.MaxHeight) {
+			return fmt.Sprintf("RESOLUTION %s exceeds %dx%d", v.Resolution, caps.MaxWidth, caps.MaxHeight)
+		}
+	}
+	if caps.MaxFrameRate > 0 && v.FrameRate > caps.MaxFrameRate {
+		return fmt.Sprintf("FRAME-RATE %g exceeds %g", v.FrameRate, caps.MaxFrameRate)
+	}
+	if len(caps.Codecs) > 0 && v.Codecs != "" {
+		codecs, err := ParseCodecs(v.Codecs)
+		if err != nil {
+			return err.Error()
+		}
+		for _, c := range codecs {
+			if !slices.Contains(caps.Codecs, c.Family) {
+				return fmt.Sprintf("codec %s not supported", c.Raw)
+			}
+		}
+	}
+	if hdcpRank(v.HDCPLevel) > hdcpRank(caps.HDCPLevel) {
+		return fmt.Sprintf("HDCP-LEVEL %s not supported", v.HDCPLevel)
+	}
+	videoRange := v.VideoRange
+	if videoRange == "" {
+		videoRange = "SDR"
+	}
+	if len(caps.VideoRanges) > 0 && !slices.Contains(caps.VideoRanges, videoRange) {
+		return fmt.Sprintf("VIDEO-RANGE %s not supported", videoRange)
+	}
+	if caps.CPC != nil && v.AllowedCPC != "" {
+		for _, entry := range strings.Split(v.AllowedCPC, ",") {
+			keyFormat, labels, _ := strings.Cut(strings.TrimSpace(entry), ":")
+			supported, ok := caps.CPC[keyFormat]
+			if !ok {
+				continue
+			}
+			allowed := false
+			for _, label := range strings.Split(labels, "/") {
+				allowed = allowed || slices.Contains(supported, label)
+			}
+			if !allowed {
+				return fmt.Sprintf("ALLOWED-CPC %s not supported", entry)
+			}
+		}
+	}
+	return ""
+}
+
+// selectRendition selects a rendition of the group by language, then DEFAULT=YES, then the first
+// one if fallback is set.
+func selectRendition(v *Variant, typ, groupID, language string, fallback bool) *Alternative {
+	var group []*Alternative
+	for _, alt := range v.Alternatives {
+		if alt.Type == typ && alt.GroupId == groupID {
+			group = append(group, alt)
+		}
+	}
+	for _, alt := range group {
+		if language != "" && strings.EqualFold(alt.Language, language) {
+			return alt
+		}
+	}
+	if !fallback {
+		return nil
+	}
+	for _, alt := range group {
+		if alt.Default {
+			return alt
+		}
+	}
+	if len(group) > 0 {
+		return group[0]
+	}
+	return nil
+}
+
+// parseResolution parses a RESOLUTION value WxH.
+func parseResolution(resolution string) (width, height int, err error) {
+	w, h, ok := strings.Cut(resolution, "x")
+	if ok {
+		if width, err = strconv.Atoi(w); err == nil {
+			height, err = strconv.Atoi(h)
+		}
+	}
+	if !ok || err != nil {
+		return 0, 0, fmt.Errorf("invalid RESOLUTION %q", resolution)
+	}
+	return width, height, nil
+}
+
+// hdcpRank orders HDCP-LEVEL values, where NONE and empty are lowest.
+func hdcpRank(level string) int {
+	switch level {
+	case "TYPE-0":
+		return 1
+	case "TYPE-1":
+		return 2
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,84 @@
+package m3u8
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+const abrTestMaster = `#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="English",LANGUAGE="en",DEFAULT=YES,URI="audio/en.m3u8"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="Deutsch",LANGUAGE="de",URI="audio/de.m3u8"
+#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="Deutsch",LANGUAGE="de",URI="subs/de.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=800000,SCORE=1.0,RESOLUTION=640x360,FRAME-RATE=25.000,CODECS="avc1.64001e,mp4a.40.2",AUDIO="aac",SUBTITLES="subs"
+360p.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=2500000,SCORE=2.0,RESOLUTION=1280x720,FRAME-RATE=25.000,CODECS="avc1.64001f,mp4a.40.2",AUDIO="aac",SUBTITLES="subs"
+720p.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=2000000,SCORE=3.0,RESOLUTION=1280x720,FRAME-RATE=25.000,CODECS="hvc1.1.6.L93.B0,mp4a.40.2",AUDIO="aac",SUBTITLES="subs"
+720p_hevc.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=6000000,SCORE=4.0,RESOLUTION=1920x1080,FRAME-RATE=50.000,CODECS="avc1.640028,mp4a.40.2",HDCP-LEVEL=TYPE-0,AUDIO="aac",SUBTITLES="subs"
+1080p.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=12000000,SCORE=5.0,RESOLUTION=3840x2160,FRAME-RATE=25.000,CODECS="hvc1.2.4.L153.B0,mp4a.40.2",VIDEO-RANGE=PQ,HDCP-LEVEL=TYPE-1,ALLOWED-CPC="com.example.drm:HW",AUDIO="aac",SUBTITLES="subs"
+2160p.m3u8
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=100000,URI="iframes.m3u8"
+`
+
+func TestSelectVariant(t *testing.T) {
+	p, _, err := DecodeFrom(strings.NewReader(abrTestMaster), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := p.(*MasterPlaylist)
+	cases := []struct {
+		name     string
+		caps     DeviceCapabilities
+		expected string
+	}{
+		{"unlimited with HDCP", DeviceCapabilities{HDCPLevel: "TYPE-1"}, "2160p.m3u8"},
+		{"no HDCP", DeviceCapabilities{}, "720p_hevc.m3u8"},
+		{"score before bandwidth", DeviceCapabilities{Throughput: 3000000}, "720p_hevc.m3u8"},
+		{"AVC only", DeviceCapabilities{Throughput: 3000000, Codecs: []string{CodecAVC, CodecAAC}}, "720p.m3u8"},
+		{"throughput", DeviceCapabilities{Throughput: 1000000}, "360p.m3u8"},
+		{"below lowest bandwidth", DeviceCapabilities{Throughput: 100000}, "360p.m3u8"},
+		{"screen size", DeviceCapabilities{MaxWidth: 640, MaxHeight: 480, HDCPLevel: "TYPE-1"}, "360p.m3u8"},
+		{"frame rate", DeviceCapabilities{MaxFrameRate: 30, HDCPLevel: "TYPE-0", Codecs: []string{CodecAVC, CodecAAC}}, "720p.m3u8"},
+		{"SDR only", DeviceCapabilities{HDCPLevel: "TYPE-1", VideoRanges: []string{"SDR"}}, "1080p.m3u8"},
+		{"CPC not supported", DeviceCapabilities{HDCPLevel: "TYPE-1", CPC: map[string][]string{"com.example.drm": {"SW"}}}, "1080p.m3u8"},
+		{"CPC supported", DeviceCapabilities{HDCPLevel: "TYPE-1", CPC: map[string][]string{"com.example.drm": {"HW"}}}, "2160p.m3u8"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			is := is.New(t)
+			d, err := m.SelectVariant(c.caps)
+			is.NoErr(err)                              // select variant
+			is.Equal(d.Variant.URI, c.expected)        // selected variant
+			is.True(len(d.Reasons) > 0)                // decision must be explained
+			is.Equal(d.Audio.Language, "en")           // default audio
+			is.Equal(d.Subtitles, (*Alternative)(nil)) // no subtitles requested
+		})
+	}
+}
+
+func TestSelectVariantRenditions(t *testing.T) {
+	is := is.New(t)
+	p, _, err := DecodeFrom(strings.NewReader(abrTestMaster), true)
+	is.NoErr(err)
+	m := p.(*MasterPlaylist)
+
+	d, err := m.SelectVariant(DeviceCapabilities{Throughput: 1000000, AudioLanguage: "de", SubtitleLanguage: "de"})
+	is.NoErr(err)
+	is.Equal(d.Audio.URI, "audio/de.m3u8")    // audio by language
+	is.Equal(d.Subtitles.URI, "subs/de.m3u8") // subtitles by language
+	is.True(strings.Contains(strings.Join(d.Reasons, "\n"), "720p.m3u8 rejected: BANDWIDTH 2500000 exceeds throughput 1000000"))
+
+	d, err = m.SelectVariant(DeviceCapabilities{Throughput: 1000000, SubtitleLanguage: "fr"})
+	is.NoErr(err)
+	is.Equal(d.Subtitles, (*Alternative)(nil)) // no subtitles in language
+
+	d, err = m.SelectVariant(DeviceCapabilities{Codecs: []string{CodecAV1}})
+	is.True(errors.Is(err, ErrNoVariant)) // no variant with supported codecs
+	is.Equal(len(d.Reasons), 5)           // every variant rejected with a reason
+}
@@ -0,0 +1,181 @@
+package m3u8
+
+/*
+ This file defines functions for calculating BANDWIDTH and AVERAGE-BANDWIDTH from segment sizes.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"net/url"
+	"strings"
+)
+
+var ErrUnknownSize = errors.New("segment size unknown")
+
+// SizeProvider provides the size in bytes of the resource at a URI.
+// URIs are resolved against the BaseURL of their playlist before they are passed on.
+type SizeProvider interface {
+	Size(uri string) (int64, error)
+}
+
+// SizeProviderFunc is an adapter to use a function as a SizeProvider.
+type SizeProviderFunc func(uri string) (int64, error)
+
+// Size calls f(uri).
+func (f SizeProviderFunc) Size(uri string) (int64, error) {
+	return f(uri)
+}
+
+// ByteRangeSizes returns a SizeProvider for playlists where all segments and maps have
+// byte ranges. The sizes are taken from the byte ranges, and any other size is unknown.
+func ByteRangeSizes() SizeProvider {
+	return SizeProviderFunc(func(uri string) (int64, error) {
+		return 0, fmt.Errorf("%w: %s has no byte range", ErrUnknownSize, uri)
+	})
+}
+
+// NewFSSizeProvider returns a SizeProvider with the sizes of files in fsys.
+// URIs are used as paths in fsys, with any leading slash and query removed.
+func NewFSSizeProvider(fsys fs.FS) SizeProvider {
+	return SizeProviderFunc(func(uri string) (int64, error) {
+		u, err := url.Parse(uri)
+		if err != nil {
+			return 0, err
+		}
+		if u.Scheme != "" || u.Host != "" {
+			return 0, fmt.Errorf("%w: %s is not a local file", ErrUnknownSize, uri)
+		}
+		info, err := fs.Stat(fsys, strings.TrimPrefix(u.Path, "/"))
+		if err != nil {
+			return 0, err
+		}
+		return info.Size(), nil
+	})
+}
+
+// Bandwidth calculates the peak segment bit rate and the average segment bit rate of the
+// media playlist in bits per second, as used for BANDWIDTH and AVERAGE-BANDWIDTH.
+//
+// The size of a segment is its byte range length if it has one, and is otherwise provided
+// by sizes. The size of the media initialization section is added to the first segment and
+// to every segment where EXT-X-MAP changes. The peak segment bit rate is the largest bit rate
+// of any contiguous set of segments with a total duration between 0.5 and 1.5 times the target
+// duration. The average segment bit rate is the total size divided by the total duration.
+func (p *MediaPlaylist) Bandwidth(sizes SizeProvider) (peak, average uint32, err error) {
+	return p.bandwidth(sizes, p.BaseURL)
+}
+
+// bandwidth calculates the bandwidth with URIs resolved against base.
+func (p *MediaPlaylist) bandwidth(sizes SizeProvider, base *url.URL) (peak, average uint32, err error) {
+	type sized struct {
+		bits     float64
+		duration float64
+	}
+	var segs []sized
+	var curMap *Map
+	var totalBits, totalDuration float64
+	for _, st := range p.segmentStates() {
+		size, err := resourceSize(sizes, base, st.seg.URI, st.seg.Limit)
+		if err != nil {
+			return 0, 0, err
+		}
+		if st.mp != nil && !st.mp.Equal(curMap) {
+			mapSize, err := resourceSize(sizes, base, st.mp.URI, st.mp.Limit)
+			if err != nil {
+				return 0, 0, err
+			}
+			size += mapSize
+		}
+		curMap = st.mp
+		s := sized{bits: float64(size * 8), duration: st.seg.Duration}
+		segs = append(segs, s)
+		totalBits += s.bits
+		totalDuration += s.duration
+	}
+	if totalDuration <= 0 {
+		return 0, 0, ErrPlaylistEmpty
+	}
+
+	target := float64(p.TargetDuration)
+	if target == 0 {
+		target = float64(p.CalculateTargetDuration(p.ver))
+	}
+	var peakRate float64
+	for i := range segs {
+		var bits, duration float64
+		for j := i; j < len(segs) && duration+segs[j].duration <= 1.5*target; j++ {
+			bits += segs[j].bits
+			duration += segs[j].duration
+			if duration >= 0.5*target {
+				peakRate = math.Max(peakRate, bits/duration)
+			}
+		}
+	}
+	if peakRate == 0 {
+		// No set of segments in the duration range, so use the single segments
+		for _, s := range segs {
+			if s.duration > 0 {
+				peakRate = math.Max(peakRate, s.bits/s.duration)
+			}
+		}
+	}
+	return uint32(math.Ceil(peakRate)), uint32(math.Ceil(totalBits / totalDuration)), nil
+}
+
+// resourceSize returns limit if it is set, or the size of uri resolved against base provided by sizes.
+func resourceSize(sizes SizeProvider, base *url.URL, uri string, limit int64) (int64, error) {
+	if limit > 0 {
+		return limit, nil
+	}
+	return sizes.Size(resolveURI(base, uri))
+}
+
+// UpdateBandwidth sets BANDWIDTH and AVERAGE-BANDWIDTH of every variant with a Chunklist
+// to the values calculated by MediaPlaylist.Bandwidth. For EXT-X-STREAM-INF variants, the
+// largest values of the audio and subtitles renditions with a Chunklist in each group used by
+// the variant are added. Chunklists without BaseURL are resolved against the variant URI.
+func (p *MasterPlaylist) UpdateBandwidth(sizes SizeProvider) error {
+	for _, v := range p.Variants {
+		if v.Chunklist == nil {
+			continue
+		}
+		peak, average, err := p.chunklistBandwidth(v.URI, v.Chunklist, sizes)
+		if err != nil {
+			return fmt.Errorf("%s: %w", v.URI, err)
+		}
+		if !v.Iframe {
+			renditionPeak := make(map[string]uint32)
+			renditionAverage := make(map[string]uint32)
+			for _, alt := range v.Alternatives {
+				if alt.Chunklist == nil || (alt.Type != "AUDIO" && alt.Type != "SUBTITLES") {
+					continue
+				}
+				altPeak, altAverage, err := p.chunklistBandwidth(alt.URI, alt.Chunklist, sizes)
+				if err != nil {
+					return fmt.Errorf("%s: %w", alt.URI, err)
+				}
+				renditionPeak[alt.Type] = max(renditionPeak[alt.Type], altPeak)
+				renditionAverage[alt.Type] = max(renditionAverage[alt.Type], altAverage)
+			}
+			for typ := range renditionPeak {
+				peak += renditionPeak[typ]
+				average += renditionAverage[typ]
+			}
+		}
+		v.Bandwidth, v.AverageBandwidth = peak, average
+	}
+	p.ResetCache()
+	return nil
+}
+
+// chunklistBandwidth returns the bandwidth of a chunklist referenced by uri in the master playlist.
+func (p *MasterPlaylist) chunklistBandwidth(uri string, chunklist *MediaPlaylist, sizes SizeProvider) (uint32, uint32, error) {
+	base := chunklist.BaseURL
+	if base == nil {
+		base, _ = url.Parse(resolveURI(p.BaseURL, uri))
+	}
+	return chunklist.bandwidth(sizes, base)
+}
@@ -0,0 +1,86 @@
+package m3u8
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/matryer/is"
+)
+
+func TestMediaPlaylistBandwidth(t *testing.T) {
+	is := is.New(t)
+	p, err := NewMediaPlaylist(0, 4)
+	is.NoErr(err)
+	p.SetDefaultMap("init.mp4", 0, 0)
+	for _, seg := range []*MediaSegment{
+		{URI: "seg0.m4s", Duration: 4},
+		{URI: "seg1.m4s", Duration: 4},
+		{URI: "seg2.m4s", Duration: 2},
+		{URI: "seg3.m4s", Duration: 2},
+	} {
+		is.NoErr(p.AppendSegment(seg))
+	}
+	p.SetTargetDuration(4)
+	fsys := fstest.MapFS{
+		"init.mp4": &fstest.MapFile{Data: make([]byte, 1000)},
+		"seg0.m4s": &fstest.MapFile{Data: make([]byte, 400000)},
+		"seg1.m4s": &fstest.MapFile{Data: make([]byte, 500000)},
+		"seg2.m4s": &fstest.MapFile{Data: make([]byte, 400000)},
+		"seg3.m4s": &fstest.MapFile{Data: make([]byte, 100000)},
+	}
+	peak, average, err := p.Bandwidth(NewFSSizeProvider(fsys))
+	is.NoErr(err) // calculate bandwidth
+	// seg2 alone has 1.6 Mbit/s, which is more than any set of 2 to 6 seconds
+	is.Equal(peak, uint32(1600000))
+	// 1401000 bytes including the init section in 12 seconds
+	is.Equal(average, uint32(934000))
+
+	_, _, err = p.Bandwidth(ByteRangeSizes())
+	is.True(errors.Is(err, ErrUnknownSize)) // sizes without byte ranges are unknown
+}
+
+func TestMediaPlaylistBandwidthSets(t *testing.T) {
+	is := is.New(t)
+	p, err := NewMediaPlaylist(0, 3)
+	is.NoErr(err)
+	for _, limit := range []int64{100000, 300000, 300000} {
+		is.NoErr(p.AppendSegment(&MediaSegment{URI: "video.ts", Duration: 1, Limit: limit}))
+	}
+	p.SetTargetDuration(4)
+	peak, average, err := p.Bandwidth(ByteRangeSizes())
+	is.NoErr(err)
+	// Sets must last at least 2 seconds, so the peak is seg1 and seg2 together
+	is.Equal(peak, uint32(2400000))
+	is.Equal(average, uint32(1866667))
+}
+
+func TestMasterPlaylistUpdateBandwidth(t *testing.T) {
+	is := is.New(t)
+	fsys := fstest.MapFS{
+		"vod/video/seg0.ts": &fstest.MapFile{Data: make([]byte, 500000)},
+		"vod/audio/en0.aac": &fstest.MapFile{Data: make([]byte, 50000)},
+		"vod/audio/fr0.aac": &fstest.MapFile{Data: make([]byte, 60000)},
+	}
+	newPlaylist := func(uri string) *MediaPlaylist {
+		p, err := NewMediaPlaylist(0, 1)
+		is.NoErr(err)
+		is.NoErr(p.Append(uri, 4, ""))
+		p.Close()
+		return p
+	}
+	master := NewMasterPlaylist()
+	en := &Alternative{Type: "AUDIO", GroupId: "aac", URI: "audio/en.m3u8", Chunklist: newPlaylist("en0.aac")}
+	fr := &Alternative{Type: "AUDIO", GroupId: "aac", URI: "audio/fr.m3u8", Chunklist: newPlaylist("fr0.aac")}
+	master.Append("video/720p.m3u8", newPlaylist("seg0.ts"),
+		VariantParams{Bandwidth: 1, Audio: "aac", Alternatives: []*Alternative{en, fr}})
+	master.Append("video/unknown.m3u8", nil, VariantParams{Bandwidth: 1})
+	master.Alternatives = []*Alternative{en, fr}
+
+	is.NoErr(master.UpdateBandwidth(SizeProviderFunc(func(uri string) (int64, error) {
+		return NewFSSizeProvider(fsys).Size("vod/" + uri)
+	})))
+	is.Equal(master.Variants[0].Bandwidth, uint32(1000000+120000)) // video and largest audio rendition
+	is.Equal(master.Variants[0].AverageBandwidth, uint32(1000000+120000))
+	is.Equal(master.Variants[1].Bandwidth, uint32(1)) // variant without chunklist unchanged
+}
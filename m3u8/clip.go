@@ -0,0 +1,190 @@
+package m3u8
+
+/*
+ This file defines functions for clipping a media playlist to a time range.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrClipOutOfRange = errors.New("clip range is outside the playlist")
+
+// segmentState is a segment with its start time, program date time and effective state.
+type segmentState struct {
+	seg    *MediaSegment
+	start  float64   // start in seconds from the first segment of the playlist
+	pdt    time.Time // interpolated program date time, zero if unknown
+	offset int64     // resolved byte range offset
+	disc   uint64    // discontinuity sequence number
+	mp     *Map      // effective media initialization section
+	keys   []Key     // effective keys
+}
+
+// initialState returns the state before the first segment, given by the playlist header.
+func (p *MediaPlaylist) initialState() segmentState {
+	return segmentState{disc: p.DiscontinuitySeq, mp: p.Map, keys: p.Keys}
+}
+
+// advance returns the state of seg, which follows the segment of st, or is the first
+// segment if st is the initial state. This is the only place where the state signalled
+// by segments for the following segments is carried over.
+func (st segmentState) advance(seg *MediaSegment) segmentState {
+	next := segmentState{seg: seg, offset: seg.Offset, disc: st.disc, mp: st.mp, keys: st.keys}
+	if prev := st.seg; prev != nil {
+		next.start = st.start + prev.Duration
+		if !st.pdt.IsZero() {
+			next.pdt = st.pdt.Add(seconds(prev.Duration))
+		}
+		// An EXT-X-BYTERANGE without offset continues after the previous sub-range
+		if seg.Limit > 0 && seg.Offset == 0 && prev.URI == seg.URI && prev.Limit > 0 {
+			next.offset = st.offset + prev.Limit
+		}
+	}
+	if seg.Discontinuity {
+		next.disc++
+		next.pdt = time.Time{}
+	}
+	if !seg.ProgramDateTime.IsZero() {
+		next.pdt = seg.ProgramDateTime
+	}
+	if seg.Map != nil {
+		next.mp = seg.Map
+	}
+	if len(seg.Keys) != 0 {
+		next.keys = seg.Keys
+	}
+	return next
+}
+
+// segmentStates returns all segments with start times, interpolated program date times,
+// discontinuity sequence numbers, effective maps and keys, and resolved byte-range offsets.
+// An EXT-X-DISCONTINUITY of the first segment counts, as it stays in effect when
+// removeHead removes the segments before it.
+func (p *MediaPlaylist) segmentStates() []segmentState {
+	segs := p.GetAllSegments()
+	out := make([]segmentState, 0, len(segs))
+	st := p.initialState()
+	for _, seg := range segs {
+		if seg == nil {
+			continue
+		}
+		st = st.advance(seg)
+		out = append(out, st)
+	}
+	return out
+}
+
+// Clip returns a new VOD media playlist with the segments covering the media time range
+// from start to end seconds, measured from the start of the first segment.
+// The first segment gets the effective EXT-X-KEY and EXT-X-MAP tags, and EXT-X-START
+// points precisely at start. Date ranges are kept if they overlap the clipped range.
+func (p *MediaPlaylist) Clip(start, end float64) (*MediaPlaylist, error) {
+	if end <= start {
+		return nil, fmt.Errorf("end %f must be after start %f", end, start)
+	}
+	segs := p.segmentStates()
+	first, last := -1, -1
+	for i, cs := range segs {
+		if cs.start < end && cs.start+cs.seg.Duration > start {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if first < 0 {
+		return nil, ErrClipOutOfRange
+	}
+
+	clip, err := NewMediaPlaylist(0, uint(last-first+1))
+	if err != nil {
+		return nil, err
+	}
+	clip.ver = p.ver
+	clip.Args = p.Args
+	clip.Defines = p.Defines
+	clip.Iframe = p.Iframe
+	clip.Custom = p.Custom
+	clip.independentSegments = p.independentSegments
+	clip.writePrecision = p.writePrecision
+	clip.MediaType = VOD
+	clip.Map = segs[first].mp
+	clip.Keys = segs[first].keys
+	if inPoint := start - segs[first].start; inPoint > 0 {
+		clip.StartTime = inPoint
+		clip.StartTimePrecise = true
+	}
+	for i := first; i <= last; i++ {
+		seg := *segs[i].seg
+		seg.Offset = segs[i].offset
+		if i == first {
+			seg.Discontinuity = false
+			seg.Map = nil
+			seg.Keys = nil
+			seg.ProgramDateTime = segs[i].pdt
+		}
+		if err := clip.AppendSegment(&seg); err != nil {
+			return nil, err
+		}
+	}
+
+	// Keep date ranges overlapping the clipped time range
+	if firstPDT := segs[first].pdt; !firstPDT.IsZero() {
+		lastPDT := segs[last].pdt
+		endPDT := firstPDT.Add(time.Duration((segs[last].start + segs[last].seg.Duration - segs[first].start) *
+			float64(time.Second)))
+		if !lastPDT.IsZero() {
+			endPDT = lastPDT.Add(time.Duration(segs[last].seg.Duration * float64(time.Second)))
+		}
+		for _, dr := range p.DateRanges {
+			drEnd := dr.StartDate
+			switch {
+			case dr.EndDate != nil:
+				drEnd = *dr.EndDate
+			case dr.Duration != nil:
+				drEnd = dr.StartDate.Add(time.Duration(*dr.Duration * float64(time.Second)))
+			}
+			if dr.StartDate.Before(endPDT) && !drEnd.Before(firstPDT) {
+				clip.DateRanges = append(clip.DateRanges, dr)
+			}
+		}
+	}
+
+	clip.SetTargetDuration(clip.CalculateTargetDuration(clip.ver))
+	ver, _ := clip.CalcMinVersion()
+	updateVersion(&clip.ver, ver)
+	clip.Close()
+	return clip, nil
+}
+
+// ClipByTime returns a new VOD media playlist with the segments covering the
+// time range from start to end, given as EXT-X-PROGRAM-DATE-TIME values.
+// Program date times are interpolated from the previous EXT-X-PROGRAM-DATE-TIME tag.
+// See Clip for details.
+func (p *MediaPlaylist) ClipByTime(start, end time.Time) (*MediaPlaylist, error) {
+	segs := p.segmentStates()
+	startOffset, okStart := clipOffset(segs, start)
+	endOffset, okEnd := clipOffset(segs, end)
+	if !okStart || !okEnd {
+		return nil, ErrClipOutOfRange
+	}
+	return p.Clip(startOffset, endOffset)
+}
+
+// clipOffset converts a program date time to a media time offset.
+// A time at the end of the last segment is also accepted.
+func clipOffset(segs []segmentState, t time.Time) (float64, bool) {
+	for _, cs := range segs {
+		if cs.pdt.IsZero() {
+			continue
+		}
+		segEnd := cs.pdt.Add(time.Duration(cs.seg.Duration * float64(time.Second)))
+		if !t.Before(cs.pdt) && !t.After(segEnd) {
+			return cs.start + t.Sub(cs.pdt).Seconds(), true
+		}
+	}
+	return 0, false
+}
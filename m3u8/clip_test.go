@@ -0,0 +1,127 @@
+package m3u8
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func newClipTestPlaylist(t *testing.T) *MediaPlaylist {
+	t.Helper()
+	is := is.New(t)
+	src := `#EXTM3U
+#EXT-X-VERSION:6
+#EXT-X-TARGETDURATION:10
+#EXT-X-PLAYLIST-TYPE:VOD
+#EXT-X-KEY:METHOD=AES-128,URI="key1"
+#EXT-X-MAP:URI="init.mp4"
+#EXT-X-PROGRAM-DATE-TIME:2025-01-01T00:00:00Z
+#EXT-X-BYTERANGE:1000@800
+#EXTINF:10.000,
+main.mp4
+#EXT-X-BYTERANGE:1000
+#EXTINF:10.000,
+main.mp4
+#EXT-X-KEY:METHOD=AES-128,URI="key2"
+#EXT-X-BYTERANGE:1000
+#EXTINF:10.000,
+main.mp4
+#EXT-X-BYTERANGE:500
+#EXTINF:4.000,
+main.mp4
+#EXT-X-ENDLIST
+#EXT-X-DATERANGE:ID="early",START-DATE="2025-01-01T00:00:01Z",DURATION=2.0
+#EXT-X-DATERANGE:ID="inside",START-DATE="2025-01-01T00:00:15Z",DURATION=5.0
+`
+	p, listType, err := DecodeFrom(strings.NewReader(src), true)
+	is.NoErr(err)             // decode test playlist
+	is.Equal(listType, MEDIA) // must be media playlist
+	return p.(*MediaPlaylist)
+}
+
+func TestClip(t *testing.T) {
+	is := is.New(t)
+	p := newClipTestPlaylist(t)
+
+	clip, err := p.Clip(12.5, 25)
+	is.NoErr(err)                           // clip playlist
+	is.Equal(clip.Count(), uint(2))         // clip must have 2 segments
+	is.Equal(clip.StartTime, 2.5)           // start time not at in-point
+	is.True(clip.StartTimePrecise)          // start must be precise
+	is.Equal(clip.TargetDuration, uint(10)) // target duration not recalculated
+	is.Equal(clip.Keys[0].URI, "key1")      // effective key not set
+	is.Equal(clip.Map.URI, "init.mp4")      // effective map not set
+	segs := clip.GetAllSegments()
+	is.Equal(segs[0].Offset, int64(1800))                                           // offset of first segment not resolved
+	is.Equal(segs[1].Offset, int64(2800))                                           // offset of second segment not resolved
+	is.Equal(segs[0].ProgramDateTime, time.Date(2025, 1, 1, 0, 0, 10, 0, time.UTC)) // program date time not interpolated
+	is.Equal(len(clip.DateRanges), 1)                                               // only overlapping date range kept
+	is.Equal(clip.DateRanges[0].ID, "inside")                                       // wrong date range kept
+	out := clip.String()
+	is.True(strings.Contains(out, `#EXT-X-KEY:METHOD=AES-128,URI="key2"`)) // key change missing
+	is.True(strings.Contains(out, "#EXT-X-ENDLIST\n"))                     // clip not closed
+	is.True(strings.Contains(out, "#EXT-X-PLAYLIST-TYPE:VOD\n"))           // clip not VOD
+
+	// The clip must survive a decode
+	_, _, err = DecodeFrom(strings.NewReader(out), true)
+	is.NoErr(err) // decode clip
+
+	_, err = p.Clip(50, 60)
+	is.Equal(err, ErrClipOutOfRange) // clip outside playlist must fail
+	_, err = p.Clip(10, 5)
+	is.True(err != nil) // end before start must fail
+}
+
+func TestClipByTime(t *testing.T) {
+	is := is.New(t)
+	p := newClipTestPlaylist(t)
+	start := time.Date(2025, 1, 1, 0, 0, 21, 0, time.UTC)
+	clip, err := p.ClipByTime(start, start.Add(13*time.Second))
+	is.NoErr(err)                           // clip playlist by time
+	is.Equal(clip.Count(), uint(2))         // clip must have 2 segments
+	is.Equal(clip.StartTime, 1.0)           // start time not at in-point
+	is.Equal(clip.TargetDuration, uint(10)) // target duration not recalculated
+	is.Equal(clip.Keys[0].URI, "key2")      // effective key not set
+
+	_, err = p.ClipByTime(start.Add(-time.Hour), start)
+	is.Equal(err, ErrClipOutOfRange) // clip before playlist must fail
+}
+
+func TestRemoveHeadKeepsSegmentStates(t *testing.T) {
+	is := is.New(t)
+	p, err := NewMediaPlaylist(0, 6)
+	is.NoErr(err)
+	pdt := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	is.NoErr(p.Append("a0.ts", 4, ""))
+	is.NoErr(p.SetProgramDateTime(pdt))
+	is.NoErr(p.SetKey("AES-128", "k1", "", "", ""))
+	is.NoErr(p.Append("a1.ts", 4, ""))
+	is.NoErr(p.Append("b0.mp4", 4, ""))
+	is.NoErr(p.SetDiscontinuity())
+	is.NoErr(p.SetMap("init.mp4", 0, 0))
+	is.NoErr(p.Append("b1.mp4", 4, ""))
+	is.NoErr(p.SetKey("AES-128", "k2", "", "", ""))
+	is.NoErr(p.Append("b2.mp4", 4, ""))
+
+	want := make(map[string]segmentState)
+	for _, st := range p.segmentStates() {
+		want[st.seg.URI] = st
+	}
+	for p.Count() > 0 {
+		// Segments keep their state when the segments before them are removed
+		for _, st := range p.segmentStates() {
+			w := want[st.seg.URI]
+			is.Equal(st.disc, w.disc)    // discontinuity sequence number
+			is.True(st.pdt.Equal(w.pdt)) // program date time
+			is.Equal(st.keys, w.keys)    // effective keys
+			is.Equal(st.mp, w.mp)        // effective map
+		}
+		is.Equal(p.Timeline().Segments[0].Discontinuity, want[p.segmentStates()[0].seg.URI].disc) // timeline agrees
+		is.NoErr(p.removeHead())
+	}
+	is.Equal(p.DiscontinuitySeq, uint64(1)) // one discontinuity removed
+	is.Equal(p.Keys[0].URI, "k2")           // last key kept
+	is.Equal(p.Map.URI, "init.mp4")         // last map kept
+}
@@ -0,0 +1,315 @@
+package m3u8
+
+/*
+ This file defines parsing and validation of RFC 6381 codec strings in CODECS and SUPPLEMENTAL-CODECS.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidCodec = errors.New("invalid codec")
+var ErrUnknownCodec = errors.New("unknown codec")
+var ErrCodecMismatch = errors.New("codecs are inconsistent")
+
+// Codec families identified by ParseCodec.
+const (
+	CodecAVC         = "AVC"
+	CodecHEVC        = "HEVC"
+	CodecDolbyVision = "DolbyVision"
+	CodecAV1         = "AV1"
+	CodecAAC         = "AAC"
+	CodecMP3         = "MP3"
+	CodecAC3         = "AC-3"
+	CodecEAC3        = "E-AC-3"
+	CodecOpus        = "Opus"
+	CodecFLAC        = "FLAC"
+	CodecWebVTT      = "WebVTT"
+	CodecTTML        = "TTML"
+)
+
+// Codec is a parsed RFC 6381 codec string.
+// Fields that do not apply to the codec family are zero.
+type Codec struct {
+	Raw         string   // Raw is the codec string without compatibility brands
+	FourCC      string   // FourCC is the sample entry type, e.g. avc1, hvc1, mp4a
+	Family      string   // Family is one of the Codec constants, or empty for unknown codecs
+	Type        string   // Type is VIDEO, AUDIO or SUBTITLES, or empty for unknown codecs
+	Profile     int      // Profile is profile_idc for AVC and HEVC, seq_profile for AV1, or the Dolby Vision profile
+	Constraints int      // Constraints is the AVC constraint set flags byte
+	Level       int      // Level is level_idc for AVC and HEVC, seq_level_idx for AV1, or the Dolby Vision level
+	Tier        string   // Tier is L or H for HEVC, and M or H for AV1
+	BitDepth    int      // BitDepth is the video bit depth if known from the codec string
+	ObjectType  int      // ObjectType is the MPEG-4 object type indication of mp4a, e.g. 0x40
+	AudioObject int      // AudioObject is the MPEG-4 audio object type of mp4a, e.g. 2 for AAC-LC
+	Brands      []string // Brands are the compatibility brands of a SUPPLEMENTAL-CODECS entry, e.g. db1p
+}
+
+// ParseCodecs parses a comma-separated list of codecs as in the CODECS attribute.
+// All codecs are returned, and the error joins the errors of all invalid or unknown codecs.
+func ParseCodecs(s string) ([]Codec, error) {
+	var codecs []Codec
+	var errs []error
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		c, err := ParseCodec(part)
+		codecs = append(codecs, c)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return codecs, errors.Join(errs...)
+}
+
+// ParseCodec parses a single codec string. Compatibility brands as used in
+// SUPPLEMENTAL-CODECS, such as "dvh1.08.07/db4h", are stored in Brands.
+// For an unknown codec, a Codec with Raw and FourCC is returned with an error wrapping ErrUnknownCodec.
+func ParseCodec(s string) (Codec, error) {
+	parts := strings.Split(s, "/")
+	c := Codec{Raw: parts[0], Brands: parts[1:]}
+	fields := strings.Split(c.Raw, ".")
+	c.FourCC = fields[0]
+	args := fields[1:]
+	var err error
+	switch c.FourCC {
+	case "avc1", "avc3":
+		c.Family, c.Type = CodecAVC, "VIDEO"
+		err = c.parseAVC(args)
+	case "hvc1", "hev1":
+		c.Family, c.Type = CodecHEVC, "VIDEO"
+		err = c.parseHEVC(args)
+	case "dvh1", "dvhe", "dva1", "dvav", "dav1":
+		c.Family, c.Type = CodecDolbyVision, "VIDEO"
+		err = c.parseDolbyVision(args)
+	case "av01":
+		c.Family, c.Type = CodecAV1, "VIDEO"
+		err = c.parseAV1(args)
+	case "mp4a":
+		c.Type = "AUDIO"
+		err = c.parseMP4A(args)
+	case "ac-3":
+		c.Family, c.Type = CodecAC3, "AUDIO"
+		err = noCodecArgs(args)
+	case "ec-3":
+		c.Family, c.Type = CodecEAC3, "AUDIO"
+		err = noCodecArgs(args)
+	case "opus", "Opus":
+		c.Family, c.Type = CodecOpus, "AUDIO"
+		err = noCodecArgs(args)
+	case "flac", "fLaC":
+		c.Family, c.Type = CodecFLAC, "AUDIO"
+		err = noCodecArgs(args)
+	case "wvtt":
+		c.Family, c.Type = CodecWebVTT, "SUBTITLES"
+		err = noCodecArgs(args)
+	case "stpp":
+		c.Family, c.Type = CodecTTML, "SUBTITLES"
+	default:
+		return c, fmt.Errorf("%w: %q", ErrUnknownCodec, s)
+	}
+	if err != nil {
+		return c, fmt.Errorf("%w %q: %s", ErrInvalidCodec, s, err.Error())
+	}
+	return c, nil
+}
+
+func noCodecArgs(args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected parameters")
+	}
+	return nil
+}
+
+// parseAVC parses avc1.PPCCLL, or the legacy form avc1.PP.LL with decimal values.
+func (c *Codec) parseAVC(args []string) error {
+	switch {
+	case len(args) == 1 && len(args[0]) == 6:
+		v, err := strconv.ParseUint(args[0], 16, 32)
+		if err != nil {
+			return err
+		}
+		c.Profile, c.Constraints, c.Level = int(v>>16), int(v>>8&0xff), int(v&0xff)
+	case len(args) == 2:
+		var err error
+		if c.Profile, err = strconv.Atoi(args[0]); err != nil {
+			return err
+		}
+		if c.Level, err = strconv.Atoi(args[1]); err != nil {
+			return err
+		}
+	default:
+		return errors.New("expected profile, constraints and level")
+	}
+	c.BitDepth = 8
+	switch c.Profile {
+	case 110, 122, 244: // High 10, High 4:2:2 and High 4:4:4 Predictive; MVC profiles 118 and 128 are 8-bit
+		c.BitDepth = 10
+	}
+	return nil
+}
+
+// parseHEVC parses hvc1.[A-C]P.C.TL[.B...] as in ISO/IEC 14496-15 Annex E.
+func (c *Codec) parseHEVC(args []string) error {
+	if len(args) < 3 {
+		return errors.New("expected profile, compatibility flags, tier and level")
+	}
+	profile := strings.TrimLeft(args[0], "ABC")
+	var err error
+	if c.Profile, err = strconv.Atoi(profile); err != nil {
+		return err
+	}
+	if _, err := strconv.ParseUint(args[1], 16, 32); err != nil {
+		return err
+	}
+	if len(args[2]) < 2 || (args[2][0] != 'L' && args[2][0] != 'H') {
+		return errors.New("expected tier L or H")
+	}
+	c.Tier = args[2][:1]
+	if c.Level, err = strconv.Atoi(args[2][1:]); err != nil {
+		return err
+	}
+	for _, b := range args[3:] {
+		if _, err := strconv.ParseUint(b, 16, 8); err != nil {
+			return err
+		}
+	}
+	switch c.Profile {
+	case 1:
+		c.BitDepth = 8
+	case 2:
+		c.BitDepth = 10
+	}
+	return nil
+}
+
+// parseDolbyVision parses dvh1.PP.LL.
+func (c *Codec) parseDolbyVision(args []string) error {
+	if len(args) != 2 {
+		return errors.New("expected profile and level")
+	}
+	var err error
+	if c.Profile, err = strconv.Atoi(args[0]); err != nil {
+		return err
+	}
+	if c.Level, err = strconv.Atoi(args[1]); err != nil {
+		return err
+	}
+	c.BitDepth = 10
+	return nil
+}
+
+// parseAV1 parses av01.P.LLT.DD with optional color parameters.
+func (c *Codec) parseAV1(args []string) error {
+	if len(args) < 3 {
+		return errors.New("expected profile, level, tier and bit depth")
+	}
+	var err error
+	if c.Profile, err = strconv.Atoi(args[0]); err != nil {
+		return err
+	}
+	lt := args[1]
+	if len(lt) != 3 || (lt[2] != 'M' && lt[2] != 'H') {
+		return errors.New("expected level and tier M or H")
+	}
+	c.Tier = lt[2:]
+	if c.Level, err = strconv.Atoi(lt[:2]); err != nil {
+		return err
+	}
+	if c.BitDepth, err = strconv.Atoi(args[2]); err != nil {
+		return err
+	}
+	return nil
+}
+
+// parseMP4A parses mp4a.OO[.A] with hexadecimal object type and decimal audio object type.
+func (c *Codec) parseMP4A(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("expected object type")
+	}
+	oti, err := strconv.ParseUint(args[0], 16, 8)
+	if err != nil {
+		return err
+	}
+	c.ObjectType = int(oti)
+	if len(args) == 2 {
+		if c.AudioObject, err = strconv.Atoi(args[1]); err != nil {
+			return err
+		}
+	}
+	switch {
+	case c.ObjectType == 0x40 && c.AudioObject == 34, c.ObjectType == 0x69, c.ObjectType == 0x6b:
+		c.Family = CodecMP3
+	case c.ObjectType == 0x40, c.ObjectType == 0x66, c.ObjectType == 0x67, c.ObjectType == 0x68:
+		c.Family = CodecAAC
+	case c.ObjectType == 0xa5:
+		c.Family = CodecAC3
+	case c.ObjectType == 0xa6:
+		c.Family = CodecEAC3
+	default:
+		return fmt.Errorf("unknown object type %#x", c.ObjectType)
+	}
+	return nil
+}
+
+// VideoRange returns the VIDEO-RANGE implied by the Dolby Vision compatibility brand,
+// or "" if there is none.
+func (c Codec) VideoRange() string {
+	for _, b := range c.Brands {
+		switch b {
+		case "db1p":
+			return "PQ"
+		case "db2g":
+			return "SDR"
+		case "db4h":
+			return "HLG"
+		}
+	}
+	return ""
+}
+
+// ValidateCodecs parses CODECS and SUPPLEMENTAL-CODECS and checks that they are
+// consistent with each other and with VIDEO-RANGE. It returns the errors of all invalid or
+// unknown codecs and inconsistencies joined together.
+//
+// SUPPLEMENTAL-CODECS requires a video codec in CODECS, Dolby Vision compatibility brands
+// must match VIDEO-RANGE, Dolby Vision profile 5 requires VIDEO-RANGE=PQ, and VIDEO-RANGE
+// PQ or HLG requires a video codec with more than 8 bits.
+func (vp *VariantParams) ValidateCodecs() error {
+	codecs, err := ParseCodecs(vp.Codecs)
+	errs := []error{err}
+	supplemental, err := ParseCodecs(vp.SupplementalCodecs)
+	errs = append(errs, err)
+	mismatch := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%w: %s", ErrCodecMismatch, fmt.Sprintf(format, args...)))
+	}
+
+	var video *Codec
+	for i := range codecs {
+		if codecs[i].Type == "VIDEO" && video == nil {
+			video = &codecs[i]
+		}
+	}
+	if len(supplemental) > 0 && video == nil {
+		mismatch("SUPPLEMENTAL-CODECS %q without video codec in CODECS", vp.SupplementalCodecs)
+	}
+	highBitDepth := video != nil && video.BitDepth > 8
+	for _, c := range supplemental {
+		if r := c.VideoRange(); r != "" && vp.VideoRange != "" && r != vp.VideoRange {
+			mismatch("brand of %q requires VIDEO-RANGE=%s, not %s", c.Raw, r, vp.VideoRange)
+		}
+		highBitDepth = highBitDepth || c.BitDepth > 8
+	}
+	if video != nil && video.Family == CodecDolbyVision && video.Profile == 5 && vp.VideoRange != "PQ" {
+		mismatch("Dolby Vision profile 5 requires VIDEO-RANGE=PQ")
+	}
+	if (vp.VideoRange == "PQ" || vp.VideoRange == "HLG") && video != nil && video.BitDepth != 0 && !highBitDepth {
+		mismatch("VIDEO-RANGE=%s requires more than 8 bits, but %q has %d", vp.VideoRange, video.Raw, video.BitDepth)
+	}
+	return errors.Join(errs...)
+}
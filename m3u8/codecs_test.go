@@ -0,0 +1,91 @@
+package m3u8
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestParseCodec(t *testing.T) {
+	cases := []struct {
+		codec    string
+		expected Codec
+	}{
+		{"avc1.64001f", Codec{Raw: "avc1.64001f", FourCC: "avc1", Family: CodecAVC, Type: "VIDEO", Profile: 100, Level: 31, BitDepth: 8}},
+		{"avc3.42E01E", Codec{Raw: "avc3.42E01E", FourCC: "avc3", Family: CodecAVC, Type: "VIDEO", Profile: 66, Constraints: 0xe0, Level: 30, BitDepth: 8}},
+		{"avc1.66.30", Codec{Raw: "avc1.66.30", FourCC: "avc1", Family: CodecAVC, Type: "VIDEO", Profile: 66, Level: 30, BitDepth: 8}},
+		{"avc1.6e0028", Codec{Raw: "avc1.6e0028", FourCC: "avc1", Family: CodecAVC, Type: "VIDEO", Profile: 110, Level: 40, BitDepth: 10}},
+		{"avc1.760028", Codec{Raw: "avc1.760028", FourCC: "avc1", Family: CodecAVC, Type: "VIDEO", Profile: 118, Level: 40, BitDepth: 8}},
+		{"avc1.800028", Codec{Raw: "avc1.800028", FourCC: "avc1", Family: CodecAVC, Type: "VIDEO", Profile: 128, Level: 40, BitDepth: 8}},
+		{"hvc1.2.4.L153.B0", Codec{Raw: "hvc1.2.4.L153.B0", FourCC: "hvc1", Family: CodecHEVC, Type: "VIDEO", Profile: 2, Tier: "L", Level: 153, BitDepth: 10}},
+		{"hev1.1.6.H120.90", Codec{Raw: "hev1.1.6.H120.90", FourCC: "hev1", Family: CodecHEVC, Type: "VIDEO", Profile: 1, Tier: "H", Level: 120, BitDepth: 8}},
+		{"dvh1.05.06", Codec{Raw: "dvh1.05.06", FourCC: "dvh1", Family: CodecDolbyVision, Type: "VIDEO", Profile: 5, Level: 6, BitDepth: 10}},
+		{"av01.0.08M.10.0.110.09.16.09.0", Codec{Raw: "av01.0.08M.10.0.110.09.16.09.0", FourCC: "av01", Family: CodecAV1, Type: "VIDEO", Level: 8, Tier: "M", BitDepth: 10}},
+		{"mp4a.40.2", Codec{Raw: "mp4a.40.2", FourCC: "mp4a", Family: CodecAAC, Type: "AUDIO", ObjectType: 0x40, AudioObject: 2}},
+		{"mp4a.40.34", Codec{Raw: "mp4a.40.34", FourCC: "mp4a", Family: CodecMP3, Type: "AUDIO", ObjectType: 0x40, AudioObject: 34}},
+		{"mp4a.6B", Codec{Raw: "mp4a.6B", FourCC: "mp4a", Family: CodecMP3, Type: "AUDIO", ObjectType: 0x6b}},
+		{"mp4a.a6", Codec{Raw: "mp4a.a6", FourCC: "mp4a", Family: CodecEAC3, Type: "AUDIO", ObjectType: 0xa6}},
+		{"ec-3", Codec{Raw: "ec-3", FourCC: "ec-3", Family: CodecEAC3, Type: "AUDIO"}},
+		{"ac-3", Codec{Raw: "ac-3", FourCC: "ac-3", Family: CodecAC3, Type: "AUDIO"}},
+		{"Opus", Codec{Raw: "Opus", FourCC: "Opus", Family: CodecOpus, Type: "AUDIO"}},
+		{"fLaC", Codec{Raw: "fLaC", FourCC: "fLaC", Family: CodecFLAC, Type: "AUDIO"}},
+		{"wvtt", Codec{Raw: "wvtt", FourCC: "wvtt", Family: CodecWebVTT, Type: "SUBTITLES"}},
+		{"stpp.ttml.im1t", Codec{Raw: "stpp.ttml.im1t", FourCC: "stpp", Family: CodecTTML, Type: "SUBTITLES"}},
+		{"dvh1.08.07/db4h", Codec{Raw: "dvh1.08.07", FourCC: "dvh1", Family: CodecDolbyVision, Type: "VIDEO", Profile: 8, Level: 7, BitDepth: 10, Brands: []string{"db4h"}}},
+	}
+	for _, c := range cases {
+		t.Run(c.codec, func(t *testing.T) {
+			is := is.New(t)
+			codec, err := ParseCodec(c.codec)
+			is.NoErr(err) // parse codec
+			if c.expected.Brands == nil {
+				c.expected.Brands = []string{}
+			}
+			is.Equal(codec, c.expected)
+		})
+	}
+}
+
+func TestParseCodecErrors(t *testing.T) {
+	is := is.New(t)
+	for _, s := range []string{"avc1.64001", "avc1.ZZ001f", "hvc1.2.4", "hvc1.2.4.X153", "dvh1.05", "av01.0.08X.10", "mp4a.ZZ", "mp4a.20", "ec-3.1"} {
+		_, err := ParseCodec(s)
+		is.True(errors.Is(err, ErrInvalidCodec)) // malformed codec must fail
+	}
+	c, err := ParseCodec("vp09.00.10.08")
+	is.True(errors.Is(err, ErrUnknownCodec)) // unknown codec must fail
+	is.Equal(c.FourCC, "vp09")               // unknown codec keeps its sample entry type
+
+	codecs, err := ParseCodecs("avc1.64001f, mp4a.40.2,xyz1")
+	is.True(errors.Is(err, ErrUnknownCodec)) // unknown codec in list must fail
+	is.Equal(len(codecs), 3)                 // all codecs returned
+	is.Equal(codecs[1].Family, CodecAAC)
+}
+
+func TestValidateCodecs(t *testing.T) {
+	cases := []struct {
+		name     string
+		params   VariantParams
+		mismatch bool
+	}{
+		{"AVC SDR", VariantParams{Codecs: "avc1.64001f,mp4a.40.2", VideoRange: "SDR"}, false},
+		{"HEVC Main10 PQ", VariantParams{Codecs: "hvc1.2.4.L153.B0", VideoRange: "PQ"}, false},
+		{"AVC PQ", VariantParams{Codecs: "avc1.64001f", VideoRange: "PQ"}, true},
+		{"Dolby Vision with HDR10 fallback", VariantParams{Codecs: "hvc1.2.4.L153.B0", SupplementalCodecs: "dvh1.08.07/db1p", VideoRange: "PQ"}, false},
+		{"Dolby Vision with HLG fallback", VariantParams{Codecs: "hvc1.2.4.L153.B0", SupplementalCodecs: "dvh1.08.07/db4h", VideoRange: "PQ"}, true},
+		{"Dolby Vision with SDR fallback", VariantParams{Codecs: "avc1.64001f", SupplementalCodecs: "dvav.09.05/db2g", VideoRange: "SDR"}, false},
+		{"Dolby Vision profile 5", VariantParams{Codecs: "dvh1.05.06", VideoRange: "SDR"}, true},
+		{"supplemental without video", VariantParams{Codecs: "mp4a.40.2", SupplementalCodecs: "dvh1.08.07/db1p"}, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			is := is.New(t)
+			err := c.params.ValidateCodecs()
+			is.Equal(errors.Is(err, ErrCodecMismatch), c.mismatch) // mismatch detection
+			if !c.mismatch {
+				is.NoErr(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package m3u8
+
+/*
+ This file defines functions for concatenating media playlists.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+var ErrConcatMismatch = errors.New("playlists cannot be concatenated")
+
+// Concat concatenates media playlists into a new media playlist.
+// The result is a VOD playlist if all playlists are closed, and an EVENT playlist otherwise.
+//
+// An EXT-X-DISCONTINUITY is inserted at each boundary between playlists, and EXT-X-MAP
+// and EXT-X-KEY tags are written where the effective values change.
+// Relative URIs are resolved against the BaseURL of their playlist, if set.
+// If any playlist has EXT-X-PROGRAM-DATE-TIME, the first segment after each boundary
+// gets a program date time, continuing from the previous playlist if it has none of its own.
+// The target duration and the version are calculated for the result.
+//
+// All playlists must have the same Args and be either all I-frame playlists or none,
+// otherwise an error wrapping ErrConcatMismatch is returned. The result keeps Args and
+// EXT-X-I-FRAMES-ONLY, and has EXT-X-INDEPENDENT-SEGMENTS only if all playlists have it.
+// A nil playlist gives an error wrapping ErrPlaylistEmpty.
+func Concat(playlists ...*MediaPlaylist) (*MediaPlaylist, error) {
+	var capacity uint
+	closed := true
+	hasPDT := false
+	independent := true
+	states := make([][]segmentState, len(playlists))
+	for i, pl := range playlists {
+		if pl == nil {
+			return nil, fmt.Errorf("%w: playlist %d is nil", ErrPlaylistEmpty, i)
+		}
+	}
+	for i, pl := range playlists {
+		if pl.Iframe != playlists[0].Iframe {
+			return nil, fmt.Errorf("%w: playlist %d has EXT-X-I-FRAMES-ONLY %t, but playlist 0 has %t",
+				ErrConcatMismatch, i, pl.Iframe, playlists[0].Iframe)
+		}
+		if pl.Args != playlists[0].Args {
+			return nil, fmt.Errorf("%w: playlist %d has Args %q, but playlist 0 has %q",
+				ErrConcatMismatch, i, pl.Args, playlists[0].Args)
+		}
+		independent = independent && pl.independentSegments
+		states[i] = pl.segmentStates()
+		capacity += uint(len(states[i]))
+		closed = closed && pl.Closed
+		for _, st := range states[i] {
+			hasPDT = hasPDT || !st.pdt.IsZero()
+		}
+	}
+	if capacity == 0 {
+		return nil, ErrPlaylistEmpty
+	}
+	out, err := NewMediaPlaylist(0, capacity)
+	if err != nil {
+		return nil, err
+	}
+	out.Args = playlists[0].Args
+	out.Iframe = playlists[0].Iframe
+	out.independentSegments = independent
+
+	var (
+		curMap  *Map
+		curKeys []Key
+		nextPDT time.Time // end of the previous segment as program date time
+	)
+	for i, pl := range playlists {
+		resolve := func(uri string) string {
+			return resolveURI(pl.BaseURL, uri)
+		}
+		for j, st := range states[i] {
+			seg := *st.seg
+			seg.Offset = st.offset
+			seg.URI = resolve(seg.URI)
+			seg.Map = nil
+			seg.Keys = nil
+			if i > 0 && j == 0 {
+				seg.Discontinuity = true
+			}
+			if mp := rewriteMapURI(st.mp, resolve); !mp.Equal(curMap) {
+				seg.Map = mp
+				curMap = mp
+			}
+			if keys := rewriteKeyURIs(st.keys, resolve); !slices.Equal(keys, curKeys) {
+				seg.Keys = keys
+				if len(keys) == 0 {
+					seg.Keys = []Key{{Method: "NONE"}}
+				}
+				curKeys = keys
+			}
+			if hasPDT && j == 0 {
+				seg.ProgramDateTime = st.pdt
+				if seg.ProgramDateTime.IsZero() {
+					seg.ProgramDateTime = nextPDT
+				}
+			}
+			if !seg.ProgramDateTime.IsZero() {
+				nextPDT = seg.ProgramDateTime
+			}
+			if !nextPDT.IsZero() {
+				nextPDT = nextPDT.Add(time.Duration(seg.Duration * float64(time.Second)))
+			}
+			if err := out.AppendSegment(&seg); err != nil {
+				return nil, err
+			}
+		}
+		out.DateRanges = append(out.DateRanges, pl.DateRanges...)
+	}
+
+	ver, _ := out.CalcMinVersion()
+	updateVersion(&out.ver, ver)
+	out.SetTargetDuration(out.CalculateTargetDuration(out.ver))
+	if closed {
+		out.MediaType = VOD
+		out.Close()
+	} else {
+		out.MediaType = EVENT
+	}
+	return out, nil
+}
@@ -0,0 +1,106 @@
+package m3u8
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestConcat(t *testing.T) {
+	is := is.New(t)
+
+	bumper, err := NewMediaPlaylist(0, 2)
+	is.NoErr(err) // create bumper playlist
+	bumper.BaseURL, _ = url.Parse("https://cdn.example.com/bumper/index.m3u8")
+	bumper.SetDefaultMap("init.mp4", 0, 0)
+	is.NoErr(bumper.Append("b0.m4s", 2, ""))                                         // append bumper segment
+	is.NoErr(bumper.SetProgramDateTime(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))) // set program date time
+	is.NoErr(bumper.Append("b1.m4s", 2, ""))                                         // append bumper segment
+	bumper.Close()
+
+	program, err := NewMediaPlaylist(0, 2)
+	is.NoErr(err) // create program playlist
+	program.BaseURL, _ = url.Parse("https://origin.example.com/vod/program/index.m3u8")
+	program.SetDefaultMap("../init.mp4", 0, 0)
+	is.NoErr(program.SetDefaultKey("AES-128", "key.bin", "", "", "")) // set program key
+	is.NoErr(program.Append("p0.m4s", 6, ""))                         // append program segment
+	is.NoErr(program.Append("p1.m4s", 6, ""))                         // append program segment
+	program.Close()
+
+	slate, err := NewMediaPlaylist(0, 1)
+	is.NoErr(err) // create slate playlist
+	slate.SetDefaultMap("https://cdn.example.com/bumper/init.mp4", 0, 0)
+	is.NoErr(slate.Append("https://cdn.example.com/slate.m4s", 4, "")) // append slate segment
+	slate.Close()
+
+	p, err := Concat(bumper, program, slate)
+	is.NoErr(err)                       // concatenate playlists
+	is.Equal(p.Count(), uint(5))        // concatenated playlist must have 5 segments
+	is.Equal(p.TargetDuration, uint(6)) // target duration not recalculated
+	is.Equal(p.MediaType, VOD)          // closed playlists must give VOD
+	is.Equal(p.Version(), uint8(6))     // version not calculated
+	segs := p.GetAllSegments()
+	is.Equal(segs[0].URI, "https://cdn.example.com/bumper/b0.m4s")                  // bumper URI not resolved
+	is.Equal(segs[0].Map.URI, "https://cdn.example.com/bumper/init.mp4")            // bumper map not resolved
+	is.True(segs[2].Discontinuity)                                                  // program must start with discontinuity
+	is.Equal(segs[2].URI, "https://origin.example.com/vod/program/p0.m4s")          // program URI not resolved
+	is.Equal(segs[2].Map.URI, "https://origin.example.com/vod/init.mp4")            // program map not resolved
+	is.Equal(segs[2].Keys[0].URI, "https://origin.example.com/vod/program/key.bin") // program key not resolved
+	is.Equal(segs[2].ProgramDateTime, time.Date(2025, 1, 1, 0, 0, 4, 0, time.UTC))  // program date time not continued
+	is.True(segs[3].Map == nil && segs[3].Keys == nil)                              // unchanged map and key must not be repeated
+	is.True(segs[4].Discontinuity)                                                  // slate must start with discontinuity
+	is.Equal(segs[4].Map.URI, "https://cdn.example.com/bumper/init.mp4")            // changed map not written
+	is.Equal(segs[4].Keys[0].Method, "NONE")                                        // encryption not turned off
+	out := p.String()
+	is.Equal(strings.Count(out, "#EXT-X-DISCONTINUITY\n"), 2) // two discontinuities expected
+	is.True(strings.HasSuffix(out, "#EXT-X-ENDLIST\n"))       // concatenated playlist not closed
+
+	live, err := NewMediaPlaylist(3, 3)
+	is.NoErr(err)                         // create live playlist
+	is.NoErr(live.Append("l0.ts", 4, "")) // append live segment
+	p, err = Concat(bumper, live)
+	is.NoErr(err)                // concatenate with live playlist
+	is.Equal(p.MediaType, EVENT) // open playlist must give EVENT
+	is.True(!p.Closed)           // EVENT playlist must not be closed
+
+	_, err = Concat()
+	is.Equal(err, ErrPlaylistEmpty) // concatenating nothing must fail
+	_, err = Concat(nil, bumper)
+	is.True(errors.Is(err, ErrPlaylistEmpty)) // nil playlist must fail
+}
+
+func TestConcatArgsAndIframe(t *testing.T) {
+	is := is.New(t)
+	media := func(uri string) *MediaPlaylist {
+		p, err := NewMediaPlaylist(0, 1)
+		is.NoErr(err) // create playlist
+		is.NoErr(p.Append(uri, 4, ""))
+		p.Args = "token=1"
+		p.Close()
+		return p
+	}
+	a, b := media("a.ts"), media("b.ts")
+	a.SetIndependentSegments(true)
+	p, err := Concat(a, b)
+	is.NoErr(err)                                             // same Args
+	is.Equal(p.Args, "token=1")                               // Args must be kept
+	is.True(strings.Contains(p.String(), "\nb.ts?token=1\n")) // Args written after segment URIs
+	is.True(!p.IndependentSegments())                         // not all playlists have independent segments
+
+	b.Args = "token=2"
+	_, err = Concat(a, b)
+	is.True(errors.Is(err, ErrConcatMismatch)) // different Args must fail
+
+	b.Args = a.Args
+	b.SetIframeOnly()
+	_, err = Concat(a, b)
+	is.True(errors.Is(err, ErrConcatMismatch)) // I-frame and normal playlist must fail
+	a.SetIframeOnly()
+	p, err = Concat(a, b)
+	is.NoErr(err)     // both I-frame playlists
+	is.True(p.Iframe) // EXT-X-I-FRAMES-ONLY must be kept
+}
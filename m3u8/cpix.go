@@ -0,0 +1,431 @@
+package m3u8
+
+/*
+ This file defines a reader of DASH-IF CPIX documents and their mapping to EXT-X-KEY tags.
+*/
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCPIX = errors.New("invalid CPIX document")
+
+// CPIX is a DASH-IF Content Protection Information Exchange document. Elements are matched by
+// local name, so any namespace prefix is accepted. Binary values are kept base64 encoded as in
+// the document.
+type CPIX struct {
+	XMLName     xml.Name         `xml:"CPIX"`
+	ContentId   string           `xml:"contentId,attr"`
+	ContentKeys []CPIXContentKey `xml:"ContentKeyList>ContentKey"`
+	DRMSystems  []CPIXDRMSystem  `xml:"DRMSystemList>DRMSystem"`
+	Periods     []CPIXKeyPeriod  `xml:"ContentKeyPeriodList>ContentKeyPeriod"`
+	UsageRules  []CPIXUsageRule  `xml:"ContentKeyUsageRuleList>ContentKeyUsageRule"`
+}
+
+// CPIXContentKey is a ContentKey element.
+type CPIXContentKey struct {
+	KID                    string `xml:"kid,attr"`                    // KID is the key ID in UUID form
+	ExplicitIV             string `xml:"explicitIV,attr"`             // ExplicitIV is the base64 encoded IV, if any
+	CommonEncryptionScheme string `xml:"commonEncryptionScheme,attr"` // CommonEncryptionScheme is cenc, cens, cbc1 or cbcs
+	Value                  string `xml:"Data>Secret>PlainValue"`      // Value is the base64 encoded key
+}
+
+// CPIXDRMSystem is a DRMSystem element with the signalling of a content key for a DRM system.
+type CPIXDRMSystem struct {
+	KID              string                 `xml:"kid,attr"`         // KID is the key ID of the content key
+	SystemId         string                 `xml:"systemId,attr"`    // SystemId is the DRM system ID in UUID form
+	PSSH             string                 `xml:"PSSH"`             // PSSH is the base64 encoded pssh box
+	URIExtXKey       string                 `xml:"URIExtXKey"`       // URIExtXKey is the base64 encoded URI of EXT-X-KEY
+	HLSSignalingData []CPIXHLSSignalingData `xml:"HLSSignalingData"` // HLSSignalingData are the complete tags
+}
+
+// CPIXHLSSignalingData is an HLSSignalingData element with base64 encoded EXT-X-KEY or
+// EXT-X-SESSION-KEY tags for the playlist type "media" or "master".
+type CPIXHLSSignalingData struct {
+	Playlist string `xml:"playlist,attr"`
+	Value    string `xml:",chardata"`
+}
+
+// CPIXKeyPeriod is a ContentKeyPeriod element. Only periods with a start time can be
+// applied to media playlists.
+type CPIXKeyPeriod struct {
+	Id    string    `xml:"id,attr"`
+	Index *uint     `xml:"index,attr"`
+	Start time.Time `xml:"start,attr"`
+	End   time.Time `xml:"end,attr"` // End is zero for a period without end
+}
+
+// CPIXUsageRule is a ContentKeyUsageRule element.
+type CPIXUsageRule struct {
+	KID               string                `xml:"kid,attr"`
+	IntendedTrackType string                `xml:"intendedTrackType,attr"`
+	PeriodFilters     []CPIXKeyPeriodFilter `xml:"KeyPeriodFilter"`
+}
+
+// CPIXKeyPeriodFilter is a KeyPeriodFilter element of a usage rule.
+type CPIXKeyPeriodFilter struct {
+	PeriodId string `xml:"periodId,attr"`
+}
+
+// DecodeCPIX reads a CPIX document. An error wrapping ErrInvalidCPIX is returned if the
+// document cannot be parsed or refers to unknown key IDs or periods.
+func DecodeCPIX(r io.Reader) (*CPIX, error) {
+	var c CPIX
+	if err := xml.NewDecoder(r).Decode(&c); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCPIX, err.Error())
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Validate checks that key IDs, system IDs and base64 values are valid, and that DRM systems,
+// usage rules and period filters refer to existing content keys and periods.
+func (c *CPIX) Validate() error {
+	invalid := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w: %s", ErrInvalidCPIX, fmt.Sprintf(format, args...))
+	}
+	kids := make(map[string]bool)
+	for _, ck := range c.ContentKeys {
+		if _, err := parseKID(ck.KID); err != nil {
+			return invalid("content key: %s", err.Error())
+		}
+		if _, err := base64.StdEncoding.DecodeString(ck.Value); err != nil {
+			return invalid("value of content key %s", ck.KID)
+		}
+		if _, err := cpixIV(ck); err != nil {
+			return err
+		}
+		kids[strings.ToLower(ck.KID)] = true
+	}
+	for _, sys := range c.DRMSystems {
+		if !kids[strings.ToLower(sys.KID)] {
+			return invalid("DRM system %s refers to unknown key %q", sys.SystemId, sys.KID)
+		}
+		if _, err := ParseSystemID(sys.SystemId); err != nil {
+			return invalid("DRM system ID %q", sys.SystemId)
+		}
+	}
+	periods := make(map[string]bool)
+	for _, kp := range c.Periods {
+		if !kp.End.IsZero() && !kp.End.After(kp.Start) {
+			return invalid("key period %q ends before it starts", kp.Id)
+		}
+		periods[kp.Id] = true
+	}
+	for _, rule := range c.UsageRules {
+		if !kids[strings.ToLower(rule.KID)] {
+			return invalid("usage rule refers to unknown key %q", rule.KID)
+		}
+		for _, f := range rule.PeriodFilters {
+			if !periods[f.PeriodId] {
+				return invalid("usage rule refers to unknown key period %q", f.PeriodId)
+			}
+		}
+	}
+	return nil
+}
+
+// parseKID parses a key ID in the UUID form of CPIX, e.g. 01234567-89ab-cdef-0123-456789abcdef.
+func parseKID(s string) ([16]byte, error) {
+	var kid [16]byte
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return kid, fmt.Errorf("key ID %q is not a UUID", s)
+	}
+	b, err := hex.DecodeString(s[:8] + s[9:13] + s[14:18] + s[19:23] + s[24:])
+	if err != nil {
+		return kid, fmt.Errorf("key ID %q is not a UUID", s)
+	}
+	copy(kid[:], b)
+	return kid, nil
+}
+
+// Secret returns the decoded value of the content key with the given key ID.
+func (c *CPIX) Secret(kid string) ([]byte, error) {
+	for _, ck := range c.ContentKeys {
+		if strings.EqualFold(ck.KID, kid) {
+			return base64.StdEncoding.DecodeString(ck.Value)
+		}
+	}
+	return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidCPIX, kid)
+}
+
+// Keys returns the EXT-X-KEY tags for the content keys used in the key period periodId and for
+// tracks of trackType, one per DRM system signalling the key in document order.
+//
+// An empty periodId selects the keys of usage rules without period filter, and an empty trackType
+// selects keys for all tracks. Content keys without usage rule are used in all periods and tracks.
+//
+// The HLSSignalingData for media playlists is used as is if present. Otherwise the METHOD is derived
+// from the common encryption scheme, SAMPLE-AES for cbcs and cbc1, SAMPLE-AES-CTR for cenc and cens,
+// and AES-128 if there is none. The URI is URIExtXKey if present, or else a data URI with the
+// PSSH for Widevine, PlayReady and other DRM systems. FairPlay requires URIExtXKey.
+func (c *CPIX) Keys(periodId, trackType string) ([]Key, error) {
+	return c.keys(periodId, trackType, "media")
+}
+
+func (c *CPIX) keys(periodId, trackType, playlist string) ([]Key, error) {
+	var keys []Key
+	for _, ck := range c.ContentKeys {
+		if !c.used(ck.KID, periodId, trackType) {
+			continue
+		}
+		for _, sys := range c.DRMSystems {
+			if !strings.EqualFold(sys.KID, ck.KID) {
+				continue
+			}
+			k, err := cpixKeys(ck, sys, playlist)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, k...)
+		}
+	}
+	return keys, nil
+}
+
+// used reports whether the content key is used in the period for the track type.
+func (c *CPIX) used(kid, periodId, trackType string) bool {
+	hasRule := false
+	for _, rule := range c.UsageRules {
+		if !strings.EqualFold(rule.KID, kid) {
+			continue
+		}
+		hasRule = true
+		if trackType != "" && rule.IntendedTrackType != "" && rule.IntendedTrackType != trackType {
+			continue
+		}
+		if periodId == "" && len(rule.PeriodFilters) == 0 {
+			return true
+		}
+		for _, f := range rule.PeriodFilters {
+			if f.PeriodId == periodId {
+				return true
+			}
+		}
+	}
+	return !hasRule
+}
+
+// cpixKeys maps the signalling of a content key for a DRM system to keys for a
+// "media" or "master" playlist.
+func cpixKeys(ck CPIXContentKey, sys CPIXDRMSystem, playlist string) ([]Key, error) {
+	invalid := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w: key %s, DRM system %s: %s", ErrInvalidCPIX, ck.KID, sys.SystemId, fmt.Sprintf(format, args...))
+	}
+	for _, sd := range sys.HLSSignalingData {
+		if sd.Playlist != playlist && !(sd.Playlist == "" && playlist == "media") {
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(sd.Value))
+		if err != nil {
+			return nil, invalid("HLSSignalingData is not base64")
+		}
+		var keys []Key
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if tag, params, ok := strings.Cut(line, ":"); ok && (tag == "#EXT-X-KEY" || tag == "#EXT-X-SESSION-KEY") {
+				keys = append(keys, *parseKeyParams(params))
+			}
+		}
+		if len(keys) == 0 {
+			return nil, invalid("HLSSignalingData has no key tag")
+		}
+		return keys, nil
+	}
+
+	key := Key{Keyformatversions: "1"}
+	switch ck.CommonEncryptionScheme {
+	case "cbcs", "cbc1":
+		key.Method = "SAMPLE-AES"
+	case "cenc", "cens":
+		key.Method = "SAMPLE-AES-CTR"
+	case "":
+		key.Method = "AES-128"
+	default:
+		return nil, invalid("unknown common encryption scheme %q", ck.CommonEncryptionScheme)
+	}
+	id, _ := ParseSystemID(sys.SystemId)
+	var pssh *PSSH
+	if sys.PSSH != "" {
+		b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(sys.PSSH))
+		if err != nil {
+			return nil, invalid("PSSH is not base64")
+		}
+		if pssh, err = ParsePSSH(b); err != nil {
+			return nil, invalid("%s", err.Error())
+		}
+	}
+	switch {
+	case id == FairPlaySystemID:
+		key = NewFairPlayKey("")
+	case id == PlayReadySystemID && pssh != nil:
+		key = NewPlayReadyKey(key.Method, pssh.Data)
+	case id == PlayReadySystemID:
+		key.Keyformat = KeyformatPlayReady
+	case id == WidevineSystemID && pssh != nil:
+		key = NewWidevineKey(key.Method, pssh)
+	case pssh != nil:
+		key.Keyformat = "urn:uuid:" + id.String()
+		key.URI = "data:text/plain;base64," + base64.StdEncoding.EncodeToString(pssh.Bytes())
+	default:
+		key.Keyformat = "urn:uuid:" + id.String()
+	}
+	if sys.URIExtXKey != "" {
+		uri, err := base64.StdEncoding.DecodeString(strings.TrimSpace(sys.URIExtXKey))
+		if err != nil {
+			return nil, invalid("URIExtXKey is not base64")
+		}
+		key.URI = string(uri)
+	}
+	if key.URI == "" {
+		return nil, invalid("no URIExtXKey or PSSH")
+	}
+	iv, _ := cpixIV(ck)
+	key.IV = iv
+	return []Key{key}, nil
+}
+
+// cpixIV returns the explicit IV of the content key as a hexadecimal IV attribute.
+func cpixIV(ck CPIXContentKey) (string, error) {
+	if ck.ExplicitIV == "" {
+		return "", nil
+	}
+	iv, err := base64.StdEncoding.DecodeString(ck.ExplicitIV)
+	if err != nil || len(iv) != 16 {
+		return "", fmt.Errorf("%w: explicit IV of content key %s", ErrInvalidCPIX, ck.KID)
+	}
+	return "0x" + strings.ToUpper(hex.EncodeToString(iv)), nil
+}
+
+// ApplyToMediaPlaylist sets the keys of the media playlist for tracks of trackType, see Keys.
+// Without key periods, the keys become the Keys of the playlist and existing segment keys are
+// removed. Otherwise the key period of
+// every segment is found by its program date time as computed by Timeline, and the keys of the
+// period are set on the first segment of each period, replacing the Keys of the playlist and
+// all existing segment keys. If a period drops a KEYFORMAT of the previous period, its keys
+// start with METHOD=NONE so that the keys of the previous period no longer apply.
+// An error wrapping ErrNotInTimeline is returned if a segment has no program date time or is
+// outside all key periods, in which case the playlist is not changed.
+func (c *CPIX) ApplyToMediaPlaylist(p *MediaPlaylist, trackType string) error {
+	if len(c.Periods) == 0 {
+		keys, err := c.Keys("", trackType)
+		if err != nil {
+			return err
+		}
+		p.Keys = keys
+		for _, seg := range p.GetAllSegments() {
+			seg.Keys = nil
+		}
+		updateKeyVersion(p, keys)
+		p.ResetCache()
+		return nil
+	}
+	for _, kp := range c.Periods {
+		if kp.Start.IsZero() {
+			return fmt.Errorf("%w: key period %q has no start time", ErrInvalidCPIX, kp.Id)
+		}
+	}
+	segs := p.Timeline().Segments
+	assigned := make([][]Key, len(segs))
+	var last *CPIXKeyPeriod
+	var prev []Key
+	for i, s := range segs {
+		if s.Segment == nil {
+			continue
+		}
+		if s.ProgramDateTime.IsZero() {
+			return fmt.Errorf("%w: segment %d has no program date time", ErrNotInTimeline, s.SeqId)
+		}
+		kp := c.periodAt(s.ProgramDateTime)
+		if kp == nil {
+			return fmt.Errorf("%w: segment %d is outside all key periods", ErrNotInTimeline, s.SeqId)
+		}
+		if kp == last {
+			continue
+		}
+		last = kp
+		keys, err := c.Keys(kp.Id, trackType)
+		if err != nil {
+			return err
+		}
+		if droppedKeyformat(prev, keys) {
+			keys = append([]Key{{Method: "NONE"}}, keys...)
+		}
+		assigned[i], prev = keys, keys
+	}
+	p.Keys = nil
+	for i, s := range segs {
+		if s.Segment == nil {
+			continue
+		}
+		s.Segment.Keys = assigned[i]
+		updateKeyVersion(p, assigned[i])
+	}
+	p.ResetCache()
+	return nil
+}
+
+// droppedKeyformat reports whether a key of prev other than METHOD=NONE has a KEYFORMAT
+// without a key in next.
+func droppedKeyformat(prev, next []Key) bool {
+	formats := make(map[string]bool)
+	for _, k := range next {
+		formats[k.Keyformat] = true
+	}
+	for _, k := range prev {
+		if k.Method != "NONE" && !formats[k.Keyformat] {
+			return true
+		}
+	}
+	return false
+}
+
+// periodAt returns the key period containing t, or nil.
+func (c *CPIX) periodAt(t time.Time) *CPIXKeyPeriod {
+	for i := range c.Periods {
+		kp := &c.Periods[i]
+		if !t.Before(kp.Start) && (kp.End.IsZero() || t.Before(kp.End)) {
+			return kp
+		}
+	}
+	return nil
+}
+
+// updateKeyVersion raises the version of the playlist if the keys use KEYFORMAT.
+func updateKeyVersion(p *MediaPlaylist, keys []Key) {
+	for _, key := range keys {
+		if key.Keyformat != "" || key.Keyformatversions != "" {
+			updateVersion(&p.ver, 5) // [Protocol Version Compatibility]
+		}
+	}
+}
+
+// ApplyToMasterPlaylist replaces the SessionKeys of the master playlist with the keys of all key
+// periods and tracks, using the HLSSignalingData for master playlists if present. Keys are listed
+// once as by DeriveSessionKeys.
+func (c *CPIX) ApplyToMasterPlaylist(m *MasterPlaylist) error {
+	var set keySet
+	periodIds := []string{""}
+	for _, kp := range c.Periods {
+		periodIds = append(periodIds, kp.Id)
+	}
+	for _, id := range periodIds {
+		keys, err := c.keys(id, "", "master")
+		if err != nil {
+			return err
+		}
+		set.add(keys...)
+	}
+	m.SessionKeys = set.keys
+	m.ResetCache()
+	return nil
+}
@@ -0,0 +1,225 @@
+package m3u8
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+const (
+	cpixKID1 = "11111111-1111-1111-1111-111111111111"
+	cpixKID2 = "22222222-2222-2222-2222-222222222222"
+)
+
+func b64(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func cpixTestDocument() string {
+	wvPSSH := base64.StdEncoding.EncodeToString((&PSSH{SystemID: WidevineSystemID, Data: []byte("wv")}).Bytes())
+	prPSSH := base64.StdEncoding.EncodeToString((&PSSH{SystemID: PlayReadySystemID, Data: []byte("pro")}).Bytes())
+	iv := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<cpix:CPIX xmlns:cpix="urn:dashif:org:cpix" xmlns:pskc="urn:ietf:params:xml:ns:keyprov:pskc" contentId="movie">
+  <cpix:ContentKeyList>
+    <cpix:ContentKey kid="%[1]s" commonEncryptionScheme="cbcs" explicitIV="%[3]s">
+      <cpix:Data><pskc:Secret><pskc:PlainValue>%[4]s</pskc:PlainValue></pskc:Secret></cpix:Data>
+    </cpix:ContentKey>
+    <cpix:ContentKey kid="%[2]s" commonEncryptionScheme="cbcs">
+      <cpix:Data><pskc:Secret><pskc:PlainValue>%[4]s</pskc:PlainValue></pskc:Secret></cpix:Data>
+    </cpix:ContentKey>
+  </cpix:ContentKeyList>
+  <cpix:DRMSystemList>
+    <cpix:DRMSystem kid="%[1]s" systemId="edef8ba9-79d6-4ace-a3c8-27dcd51d21ed">
+      <cpix:PSSH>%[5]s</cpix:PSSH>
+    </cpix:DRMSystem>
+    <cpix:DRMSystem kid="%[1]s" systemId="94ce86fb-07ff-4f43-adb8-93d2fa968ca2">
+      <cpix:URIExtXKey>%[7]s</cpix:URIExtXKey>
+      <cpix:HLSSignalingData playlist="master">%[8]s</cpix:HLSSignalingData>
+    </cpix:DRMSystem>
+    <cpix:DRMSystem kid="%[2]s" systemId="9a04f079-9840-4286-ab92-e65be0885f95">
+      <cpix:PSSH>%[6]s</cpix:PSSH>
+    </cpix:DRMSystem>
+  </cpix:DRMSystemList>
+  <cpix:ContentKeyPeriodList>
+    <cpix:ContentKeyPeriod id="p1" index="1" start="2024-01-01T00:00:00Z" end="2024-01-01T00:00:20Z"/>
+    <cpix:ContentKeyPeriod id="p2" index="2" start="2024-01-01T00:00:20Z"/>
+  </cpix:ContentKeyPeriodList>
+  <cpix:ContentKeyUsageRuleList>
+    <cpix:ContentKeyUsageRule kid="%[1]s" intendedTrackType="VIDEO"><cpix:KeyPeriodFilter periodId="p1"/></cpix:ContentKeyUsageRule>
+    <cpix:ContentKeyUsageRule kid="%[2]s" intendedTrackType="VIDEO"><cpix:KeyPeriodFilter periodId="p2"/></cpix:ContentKeyUsageRule>
+  </cpix:ContentKeyUsageRuleList>
+</cpix:CPIX>`, cpixKID1, cpixKID2, iv, b64("0123456789ABCDEF"), wvPSSH, prPSSH,
+		b64("skd://key1"), b64(`#EXT-X-SESSION-KEY:METHOD=SAMPLE-AES,URI="skd://session1",KEYFORMAT="com.apple.streamingkeydelivery",KEYFORMATVERSIONS="1"`))
+}
+
+func TestDecodeCPIX(t *testing.T) {
+	is := is.New(t)
+	c, err := DecodeCPIX(strings.NewReader(cpixTestDocument()))
+	is.NoErr(err)
+	is.Equal(c.ContentId, "movie")
+	is.Equal(len(c.ContentKeys), 2)
+	is.Equal(len(c.DRMSystems), 3)
+	is.Equal(len(c.Periods), 2)
+	is.Equal(*c.Periods[1].Index, uint(2))                                           // period index
+	is.Equal(c.Periods[0].End, time.Date(2024, 1, 1, 0, 0, 20, 0, time.UTC))         // period end
+	is.True(c.Periods[1].End.IsZero())                                               // open period
+	is.Equal(c.UsageRules[1].PeriodFilters, []CPIXKeyPeriodFilter{{PeriodId: "p2"}}) // period filter
+	secret, err := c.Secret(strings.ToUpper(cpixKID1))
+	is.NoErr(err)
+	is.Equal(string(secret), "0123456789ABCDEF") // decoded key value
+}
+
+func TestDecodeCPIXInvalid(t *testing.T) {
+	is := is.New(t)
+	for _, doc := range []string{
+		`<CPIX><ContentKeyList>`,
+		`<CPIX><ContentKeyList><ContentKey kid="x"/></ContentKeyList></CPIX>`,
+		`<CPIX><DRMSystemList><DRMSystem kid="` + cpixKID1 + `" systemId="` + WidevineSystemID.String() + `"/></DRMSystemList></CPIX>`,
+		`<CPIX><ContentKeyList><ContentKey kid="` + cpixKID1 + `" explicitIV="AAAA"/></ContentKeyList></CPIX>`,
+		`<CPIX><ContentKeyList><ContentKey kid="` + cpixKID1 + `"/></ContentKeyList>` +
+			`<ContentKeyUsageRuleList><ContentKeyUsageRule kid="` + cpixKID1 + `"><KeyPeriodFilter periodId="p9"/></ContentKeyUsageRule></ContentKeyUsageRuleList></CPIX>`,
+	} {
+		_, err := DecodeCPIX(strings.NewReader(doc))
+		is.True(errors.Is(err, ErrInvalidCPIX)) // invalid document
+	}
+
+	for _, kid := range []string{"urn:uuid:" + cpixKID1, strings.ReplaceAll(cpixKID1, "-", ""), "0123456789-ab-cdef-0123-456789abcdef"} {
+		_, err := DecodeCPIX(strings.NewReader(`<CPIX><ContentKeyList><ContentKey kid="` + kid + `"/></ContentKeyList></CPIX>`))
+		is.True(errors.Is(err, ErrInvalidCPIX))                 // key ID not in UUID form
+		is.True(strings.Contains(err.Error(), "is not a UUID")) // key ID error, not system ID
+	}
+	kid, err := parseKID(strings.ToUpper(cpixKID1))
+	is.NoErr(err) // upper case UUID
+	is.Equal(hex.EncodeToString(kid[:]), strings.ReplaceAll(cpixKID1, "-", ""))
+}
+
+func TestCPIXKeys(t *testing.T) {
+	is := is.New(t)
+	c, err := DecodeCPIX(strings.NewReader(cpixTestDocument()))
+	is.NoErr(err)
+
+	keys, err := c.Keys("p1", "VIDEO")
+	is.NoErr(err)
+	is.Equal(len(keys), 2) // Widevine and FairPlay for key 1
+	pssh, err := keys[0].PSSH()
+	is.NoErr(err)
+	is.Equal(string(pssh.Data), "wv")                          // Widevine PSSH
+	is.Equal(keys[0].Method, "SAMPLE-AES")                     // cbcs
+	is.Equal(keys[0].IV, "0x30313233343536373839616263646566") // explicit IV
+	is.Equal(keys[1], Key{Method: "SAMPLE-AES", URI: "skd://key1", IV: keys[0].IV, Keyformat: KeyformatFairPlay, Keyformatversions: "1"})
+
+	keys, err = c.Keys("p2", "")
+	is.NoErr(err)
+	is.Equal(len(keys), 1) // PlayReady for key 2
+	is.Equal(keys[0].Keyformat, KeyformatPlayReady)
+	pssh, err = keys[0].PSSH()
+	is.NoErr(err)
+	is.Equal(string(pssh.Data), "pro") // PlayReady Object
+
+	keys, err = c.Keys("p2", "AUDIO")
+	is.NoErr(err)
+	is.Equal(len(keys), 0) // no keys for audio tracks
+}
+
+func TestCPIXApplyToMediaPlaylist(t *testing.T) {
+	is := is.New(t)
+	c, err := DecodeCPIX(strings.NewReader(cpixTestDocument()))
+	is.NoErr(err)
+	p, err := NewMediaPlaylist(0, 4)
+	is.NoErr(err)
+	for i := 0; i < 4; i++ {
+		is.NoErr(p.Append(fmt.Sprintf("s%d.mp4", i), 10, ""))
+	}
+	is.NoErr(p.SetProgramDateTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+	before := p.String()
+	p.Segments[3].ProgramDateTime = time.Time{}
+	p.Segments[0].ProgramDateTime = time.Time{}
+	err = c.ApplyToMediaPlaylist(p, "VIDEO")
+	is.True(errors.Is(err, ErrNotInTimeline)) // no program date time
+	is.Equal(p.Keys, nil)                     // playlist not changed
+
+	p.Segments[0].ProgramDateTime = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	is.NoErr(c.ApplyToMediaPlaylist(p, "VIDEO"))
+	segs := p.GetAllSegments()
+	is.Equal(len(segs[0].Keys), 2)                          // key 1 in period p1
+	is.Equal(segs[1].Keys, nil)                             // same period
+	is.Equal(segs[2].Keys[0], Key{Method: "NONE"})          // Widevine and FairPlay turned off
+	is.Equal(segs[2].Keys[1].Keyformat, KeyformatPlayReady) // key 2 in period p2
+	is.Equal(p.Keys, nil)                                   // keys only on segments
+	out := p.String()
+	is.True(out != before)
+	is.Equal(strings.Count(out, "#EXT-X-KEY:"), 4)     // two keys, then NONE and one key
+	is.True(strings.Contains(out, "#EXT-X-VERSION:5")) // KEYFORMAT
+}
+
+func TestCPIXApplyToMediaPlaylistReusedKey(t *testing.T) {
+	is := is.New(t)
+	doc := strings.Replace(cpixTestDocument(), `start="2024-01-01T00:00:20Z"/>`,
+		`start="2024-01-01T00:00:20Z" end="2024-01-01T00:00:30Z"/>
+    <cpix:ContentKeyPeriod id="p3" start="2024-01-01T00:00:30Z"/>`, 1)
+	doc = strings.Replace(doc, `<cpix:KeyPeriodFilter periodId="p1"/>`,
+		`<cpix:KeyPeriodFilter periodId="p1"/><cpix:KeyPeriodFilter periodId="p3"/>`, 1)
+	c, err := DecodeCPIX(strings.NewReader(doc))
+	is.NoErr(err)
+	p, err := NewMediaPlaylist(0, 4)
+	is.NoErr(err)
+	for i := 0; i < 4; i++ {
+		is.NoErr(p.Append(fmt.Sprintf("s%d.mp4", i), 10, ""))
+		if i == 0 {
+			is.NoErr(p.SetProgramDateTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+		}
+	}
+	is.NoErr(c.ApplyToMediaPlaylist(p, "VIDEO"))
+
+	dec, _, err := DecodeFrom(strings.NewReader(p.String()), true)
+	is.NoErr(err)
+	pl := dec.(*MediaPlaylist)
+	segs := pl.GetAllSegments()
+	p1 := pl.EffectiveKeys(segs[0])
+	is.Equal(len(p1), 2)                                                 // Widevine and FairPlay in p1
+	is.Equal(pl.EffectiveKeys(segs[2])[1].Keyformat, KeyformatPlayReady) // PlayReady in p2
+	p3 := pl.EffectiveKeys(segs[3])
+	is.Equal(p3[0], Key{Method: "NONE"}) // PlayReady turned off
+	is.Equal(p3[1:], p1)                 // key 1 written again in p3
+}
+
+func TestCPIXApplyToMediaPlaylistWithoutPeriods(t *testing.T) {
+	is := is.New(t)
+	doc := `<CPIX><ContentKeyList><ContentKey kid="` + cpixKID1 + `"/></ContentKeyList>` +
+		`<DRMSystemList><DRMSystem kid="` + cpixKID1 + `" systemId="e2719d58-a985-b3c9-781a-b030af78d30e">` +
+		`<URIExtXKey>` + b64("https://keys.example.com/1") + `</URIExtXKey></DRMSystem></DRMSystemList></CPIX>`
+	c, err := DecodeCPIX(strings.NewReader(doc))
+	is.NoErr(err)
+	p, err := NewMediaPlaylist(0, 2)
+	is.NoErr(err)
+	is.NoErr(p.Append("s0.ts", 10, ""))
+	is.NoErr(p.Append("s1.ts", 10, ""))
+	is.NoErr(p.SetKey("AES-128", "https://keys.example.com/old", "", "", "")) // existing segment key
+	is.NoErr(c.ApplyToMediaPlaylist(p, ""))
+	is.Equal(p.Keys, []Key{{Method: "AES-128", URI: "https://keys.example.com/1",
+		Keyformat: "urn:uuid:e2719d58-a985-b3c9-781a-b030af78d30e", Keyformatversions: "1"}}) // AES-128 key
+	is.Equal(p.Segments[1].Keys, nil)                                      // segment key removed
+	is.Equal(strings.Count(p.String(), "#EXT-X-KEY:"), 1)                  // only the playlist key
+	is.True(!strings.Contains(p.String(), "https://keys.example.com/old")) // old key not written
+}
+
+func TestCPIXApplyToMasterPlaylist(t *testing.T) {
+	is := is.New(t)
+	c, err := DecodeCPIX(strings.NewReader(cpixTestDocument()))
+	is.NoErr(err)
+	m := NewMasterPlaylist()
+	m.SessionKeys = []*Key{{Method: "AES-128", URI: "old"}}
+	is.NoErr(c.ApplyToMasterPlaylist(m))
+	is.Equal(len(m.SessionKeys), 3) // Widevine, FairPlay and PlayReady
+	is.Equal(m.SessionKeys[0].Keyformat, KeyformatWidevine)
+	is.Equal(m.SessionKeys[1].URI, "skd://session1") // master HLSSignalingData
+	is.Equal(m.SessionKeys[2].Keyformat, KeyformatPlayReady)
+	is.True(strings.Contains(m.String(), `#EXT-X-SESSION-KEY:METHOD=SAMPLE-AES,URI="skd://session1"`))
+}
@@ -0,0 +1,224 @@
+package m3u8
+
+/*
+ This file defines merging, validation and time queries of EXT-X-DATERANGE tags.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+var ErrDateRangeConflict = errors.New("conflicting EXT-X-DATERANGE attributes")
+var ErrInvalidDateRange = errors.New("invalid EXT-X-DATERANGE")
+var ErrSegmentNotFound = errors.New("segment not found")
+
+// dateRangeTolerance is the allowed difference between END-DATE and START-DATE plus DURATION.
+const dateRangeTolerance = time.Millisecond
+
+// ResolvedDateRange is a date range merged from all tags with the same ID, with its effective end.
+type ResolvedDateRange struct {
+	*DateRange // DateRange is a merged copy of the tags with the same ID
+	// End is the effective end given by END-DATE, DURATION, or for END-ON-NEXT the START-DATE
+	// of the next date range with the same CLASS. It is zero if the end is not known yet.
+	End time.Time
+}
+
+// Active reports whether the date range is active at t. A date range with a zero duration
+// is only active at its START-DATE.
+func (r *ResolvedDateRange) Active(t time.Time) bool {
+	if t.Before(r.StartDate) {
+		return false
+	}
+	return r.End.IsZero() || t.Before(r.End) || (r.End.Equal(r.StartDate) && t.Equal(r.StartDate))
+}
+
+// overlaps reports whether the date range overlaps the interval from start to end.
+func (r *ResolvedDateRange) overlaps(start, end time.Time) bool {
+	if !r.StartDate.Before(end) {
+		return false
+	}
+	return r.End.IsZero() || r.End.After(start) || (r.End.Equal(r.StartDate) && !r.StartDate.Before(start))
+}
+
+// ResolveDateRanges merges the date ranges of DateRanges and of the SCTE35DateRanges of all
+// segments by ID, as tags with the same ID describe the same date range, and returns them sorted
+// by START-DATE with their effective end. The tags are not changed.
+//
+// Attributes present in several tags with the same ID must have the same value, otherwise an
+// error wrapping ErrDateRangeConflict is returned. An error wrapping ErrInvalidDateRange is
+// returned if END-DATE is before START-DATE or does not match START-DATE plus DURATION, or if
+// END-ON-NEXT is used without CLASS or together with END-DATE or DURATION. All errors are joined,
+// and the date ranges are returned even if there are errors.
+func (p *MediaPlaylist) ResolveDateRanges() ([]*ResolvedDateRange, error) {
+	var errs []error
+	var ranges []*ResolvedDateRange
+	byID := make(map[string]*ResolvedDateRange)
+	add := func(dr *DateRange) {
+		if dr == nil {
+			return
+		}
+		if r, ok := byID[dr.ID]; ok {
+			if err := mergeDateRange(r.DateRange, dr); err != nil {
+				errs = append(errs, err)
+			}
+			return
+		}
+		c := *dr
+		c.XAttrs = append([]Attribute(nil), dr.XAttrs...)
+		r := &ResolvedDateRange{DateRange: &c}
+		byID[dr.ID] = r
+		ranges = append(ranges, r)
+	}
+	for _, seg := range p.GetAllSegments() {
+		for _, dr := range seg.SCTE35DateRanges {
+			add(dr)
+		}
+	}
+	for _, dr := range p.DateRanges {
+		add(dr)
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].StartDate.Before(ranges[j].StartDate) })
+
+	for i, r := range ranges {
+		if err := validateDateRange(r.DateRange); err != nil {
+			errs = append(errs, err)
+		}
+		switch {
+		case r.EndDate != nil:
+			r.End = *r.EndDate
+		case r.Duration != nil:
+			r.End = r.StartDate.Add(seconds(*r.Duration))
+		case r.EndOnNext:
+			for _, next := range ranges[i+1:] {
+				if next.Class == r.Class && next.StartDate.After(r.StartDate) {
+					r.End = next.StartDate
+					break
+				}
+			}
+		}
+	}
+	return ranges, errors.Join(errs...)
+}
+
+// DateRangesAt returns the date ranges that are active at t as given by ResolveDateRanges.
+func (p *MediaPlaylist) DateRangesAt(t time.Time) ([]*ResolvedDateRange, error) {
+	ranges, err := p.ResolveDateRanges()
+	var active []*ResolvedDateRange
+	for _, r := range ranges {
+		if r.Active(t) {
+			active = append(active, r)
+		}
+	}
+	return active, err
+}
+
+// DateRangesForSegment returns the date ranges overlapping the segment with media sequence number
+// seqId, using the program date time of the segment as computed by Timeline.
+// An error wrapping ErrSegmentNotFound is returned if there is no such segment, and one wrapping
+// ErrNotInTimeline if the segment has no program date time.
+func (p *MediaPlaylist) DateRangesForSegment(seqId uint64) ([]*ResolvedDateRange, error) {
+	var seg *TimelineSegment
+	for _, s := range p.Timeline().Segments {
+		if s.SeqId == seqId && s.Segment != nil {
+			seg = s
+		}
+	}
+	if seg == nil {
+		return nil, fmt.Errorf("%w: %d", ErrSegmentNotFound, seqId)
+	}
+	if seg.ProgramDateTime.IsZero() {
+		return nil, fmt.Errorf("%w: segment %d has no program date time", ErrNotInTimeline, seqId)
+	}
+	ranges, err := p.ResolveDateRanges()
+	var overlapping []*ResolvedDateRange
+	for _, r := range ranges {
+		if r.overlaps(seg.ProgramDateTime, seg.ProgramDateTime.Add(seg.Duration)) {
+			overlapping = append(overlapping, r)
+		}
+	}
+	return overlapping, err
+}
+
+// mergeDateRange adds the attributes of dr to the merged date range m.
+func mergeDateRange(m, dr *DateRange) error {
+	var errs []error
+	conflict := func(name string, a, b interface{}) {
+		errs = append(errs, fmt.Errorf("%w: ID %q has %s %v and %v", ErrDateRangeConflict, m.ID, name, a, b))
+	}
+	if !m.StartDate.Equal(dr.StartDate) {
+		conflict("START-DATE", m.StartDate.Format(DATETIME), dr.StartDate.Format(DATETIME))
+	}
+	mergeString := func(name string, a *string, b string) {
+		switch {
+		case *a == "":
+			*a = b
+		case b != "" && *a != b:
+			conflict(name, *a, b)
+		}
+	}
+	mergeString("CLASS", &m.Class, dr.Class)
+	mergeString("CUE", &m.Cue, dr.Cue)
+	mergeString("SCTE35-CMD", &m.SCTE35Cmd, dr.SCTE35Cmd)
+	mergeString("SCTE35-OUT", &m.SCTE35Out, dr.SCTE35Out)
+	mergeString("SCTE35-IN", &m.SCTE35In, dr.SCTE35In)
+	mergeFloat := func(name string, a **float64, b *float64) {
+		switch {
+		case *a == nil:
+			*a = b
+		case b != nil && **a != *b:
+			conflict(name, **a, *b)
+		}
+	}
+	mergeFloat("DURATION", &m.Duration, dr.Duration)
+	mergeFloat("PLANNED-DURATION", &m.PlannedDuration, dr.PlannedDuration)
+	switch {
+	case m.EndDate == nil:
+		m.EndDate = dr.EndDate
+	case dr.EndDate != nil && !m.EndDate.Equal(*dr.EndDate):
+		conflict("END-DATE", m.EndDate.Format(DATETIME), dr.EndDate.Format(DATETIME))
+	}
+	m.EndOnNext = m.EndOnNext || dr.EndOnNext
+	for _, attr := range dr.XAttrs {
+		found := false
+		for _, a := range m.XAttrs {
+			if a.Key == attr.Key {
+				found = true
+				if a.Val != attr.Val {
+					conflict(attr.Key, a.Val, attr.Val)
+				}
+			}
+		}
+		if !found {
+			m.XAttrs = append(m.XAttrs, attr)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateDateRange checks the consistency of END-DATE, DURATION and END-ON-NEXT.
+func validateDateRange(dr *DateRange) error {
+	invalid := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w: ID %q: %s", ErrInvalidDateRange, dr.ID, fmt.Sprintf(format, args...))
+	}
+	if dr.Duration != nil && *dr.Duration < 0 {
+		return invalid("negative DURATION %g", *dr.Duration)
+	}
+	if dr.EndDate != nil {
+		if dr.EndDate.Before(dr.StartDate) {
+			return invalid("END-DATE before START-DATE")
+		}
+		if dr.Duration != nil {
+			diff := dr.StartDate.Add(seconds(*dr.Duration)).Sub(*dr.EndDate)
+			if diff > dateRangeTolerance || diff < -dateRangeTolerance {
+				return invalid("END-DATE does not match START-DATE plus DURATION %g", *dr.Duration)
+			}
+		}
+	}
+	if dr.EndOnNext && (dr.Class == "" || dr.EndDate != nil || dr.Duration != nil) {
+		return invalid("END-ON-NEXT requires CLASS and no END-DATE or DURATION")
+	}
+	return nil
+}
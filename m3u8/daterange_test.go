@@ -0,0 +1,97 @@
+package m3u8
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+const dateRangeTestPlaylist = `#EXTM3U
+#EXT-X-VERSION:6
+#EXT-X-TARGETDURATION:10
+#EXT-X-MEDIA-SEQUENCE:100
+#EXT-X-PROGRAM-DATE-TIME:2025-01-01T00:00:00.000Z
+#EXT-X-DATERANGE:ID="ad1",CLASS="com.example.ad",START-DATE="2025-01-01T00:00:05.000Z",PLANNED-DURATION=20.0,X-AD-ID="a"
+#EXTINF:10.000,
+seg100.ts
+#EXT-X-DATERANGE:ID="chapter1",CLASS="com.example.chapter",START-DATE="2025-01-01T00:00:00.000Z",END-ON-NEXT=YES
+#EXTINF:10.000,
+seg101.ts
+#EXT-X-DATERANGE:ID="ad1",START-DATE="2025-01-01T00:00:05.000Z",DURATION=15.0,X-AD-ID="a",X-TRACKING="t"
+#EXTINF:10.000,
+seg102.ts
+#EXT-X-DATERANGE:ID="chapter2",CLASS="com.example.chapter",START-DATE="2025-01-01T00:00:25.000Z",END-ON-NEXT=YES
+#EXT-X-DATERANGE:ID="mark",START-DATE="2025-01-01T00:00:30.000Z",DURATION=0
+#EXTINF:10.000,
+seg103.ts
+#EXT-X-ENDLIST
+`
+
+func TestResolveDateRanges(t *testing.T) {
+	is := is.New(t)
+	p, _, err := DecodeFrom(strings.NewReader(dateRangeTestPlaylist), true)
+	is.NoErr(err)
+	pl := p.(*MediaPlaylist)
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	ranges, err := pl.ResolveDateRanges()
+	is.NoErr(err) // resolve date ranges
+	var ids []string
+	for _, r := range ranges {
+		ids = append(ids, r.ID)
+	}
+	is.Equal(ids, []string{"chapter1", "ad1", "chapter2", "mark"}) // merged by ID and sorted by START-DATE
+	ad := ranges[1]
+	is.Equal(ad.Class, "com.example.ad")
+	is.Equal(*ad.Duration, 15.0) // attribute added by later tag
+	is.Equal(len(ad.XAttrs), 2)  // client attributes merged
+	is.True(ad.End.Equal(start.Add(20 * time.Second)))
+	is.True(ranges[0].End.Equal(start.Add(25 * time.Second))) // END-ON-NEXT resolved by CLASS
+	is.True(ranges[2].End.IsZero())                           // no next range of the same CLASS
+	is.Equal(pl.DateRanges[0].Duration, (*float64)(nil))      // tags not changed
+
+	active, err := pl.DateRangesAt(start.Add(22 * time.Second))
+	is.NoErr(err)
+	is.Equal(len(active), 1) // only chapter1 active
+	is.Equal(active[0].ID, "chapter1")
+	active, err = pl.DateRangesAt(start.Add(30 * time.Second))
+	is.NoErr(err)
+	is.Equal(len(active), 2) // chapter2 and zero-duration mark
+	is.Equal(active[1].ID, "mark")
+
+	overlapping, err := pl.DateRangesForSegment(101)
+	is.NoErr(err)
+	is.Equal(len(overlapping), 2) // chapter1 and ad1 overlap 10s-20s
+	overlapping, err = pl.DateRangesForSegment(103)
+	is.NoErr(err)
+	is.Equal(len(overlapping), 2) // chapter2 and mark overlap 30s-40s
+	_, err = pl.DateRangesForSegment(104)
+	is.True(errors.Is(err, ErrSegmentNotFound))
+}
+
+func TestResolveDateRangesErrors(t *testing.T) {
+	is := is.New(t)
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(10 * time.Second)
+	d5, d10 := 5.0, 10.0
+	p, err := NewMediaPlaylist(0, 1)
+	is.NoErr(err)
+	p.DateRanges = []*DateRange{
+		{ID: "a", StartDate: start, Duration: &d5},
+		{ID: "a", StartDate: start, Duration: &d10, XAttrs: []Attribute{{Key: "X-A", Val: `"1"`}}},
+		{ID: "a", StartDate: start, XAttrs: []Attribute{{Key: "X-A", Val: `"2"`}}},
+		{ID: "b", StartDate: start, EndDate: &end, Duration: &d5},
+		{ID: "c", StartDate: start, EndOnNext: true},
+		{ID: "d", StartDate: end, EndDate: &start},
+		{ID: "e", StartDate: start, EndDate: &end, Duration: &d10},
+	}
+	ranges, err := p.ResolveDateRanges()
+	is.True(errors.Is(err, ErrDateRangeConflict)) // DURATION and X-A conflict
+	is.True(errors.Is(err, ErrInvalidDateRange))  // END-DATE mismatch, END-ON-NEXT without CLASS, END-DATE before START-DATE
+	is.Equal(len(ranges), 5)                      // ranges returned despite errors
+	is.Equal(strings.Count(err.Error(), "\n"), 4) // five errors
+	is.Equal(*ranges[0].Duration, 5.0)            // first value kept on conflict
+}
@@ -32,6 +32,9 @@ One can then Set or Append extra data such as Variants or Segments.
 For live media playlists with a fixed sliding window, one can set a window size (winsize) that will
 be used to Encode a maximum number of latest segments.
 
+Alternatively, the sliding window can be defined by duration with SetWinDuration,
+so that renditions with different segment durations expose the same time window.
+
 For VOD or EVENT media playlists, the winsize should be 0.
 
 For writing, there are Encode methods that return a [*bytes.Buffer]. This buffer serves as a cache.
@@ -0,0 +1,288 @@
+package m3u8
+
+/*
+ This file defines DRM signalling helpers for PSSH boxes, data URIs and DRM system keys.
+*/
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+var ErrInvalidPSSH = errors.New("invalid PSSH box")
+var ErrInvalidDataURI = errors.New("invalid data URI")
+var ErrUnknownKeyformat = errors.New("unknown KEYFORMAT")
+
+// SystemID is a DRM system ID as used in PSSH boxes.
+type SystemID [16]byte
+
+// DRM system IDs.
+var (
+	WidevineSystemID  = mustSystemID("edef8ba9-79d6-4ace-a3c8-27dcd51d21ed")
+	PlayReadySystemID = mustSystemID("9a04f079-9840-4286-ab92-e65be0885f95")
+	FairPlaySystemID  = mustSystemID("94ce86fb-07ff-4f43-adb8-93d2fa968ca2")
+)
+
+// KEYFORMAT values of DRM systems.
+const (
+	KeyformatWidevine  = "urn:uuid:edef8ba9-79d6-4ace-a3c8-27dcd51d21ed"
+	KeyformatPlayReady = "com.microsoft.playready"
+	KeyformatFairPlay  = "com.apple.streamingkeydelivery"
+)
+
+// ParseSystemID parses a system ID in UUID form, optionally prefixed by "urn:uuid:".
+func ParseSystemID(s string) (SystemID, error) {
+	var id SystemID
+	h := strings.ReplaceAll(strings.TrimPrefix(strings.ToLower(s), "urn:uuid:"), "-", "")
+	b, err := hex.DecodeString(h)
+	if err != nil || len(b) != len(id) {
+		return id, fmt.Errorf("invalid system ID %q", s)
+	}
+	copy(id[:], b)
+	return id, nil
+}
+
+func mustSystemID(s string) SystemID {
+	id, err := ParseSystemID(s)
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+// String returns the system ID in UUID form.
+func (id SystemID) String() string {
+	h := hex.EncodeToString(id[:])
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+// PSSH is a Protection System Specific Header box as defined in ISO/IEC 23001-7.
+type PSSH struct {
+	Version  uint8      // Version is 0, or 1 if KIDs are listed
+	SystemID SystemID   // SystemID identifies the DRM system
+	KIDs     [][16]byte // KIDs are the key IDs of a version 1 box
+	Data     []byte     // Data is the DRM system specific data
+}
+
+// ParsePSSH parses a complete pssh box.
+func ParsePSSH(b []byte) (*PSSH, error) {
+	invalid := func(reason string) error {
+		return fmt.Errorf("%w: %s", ErrInvalidPSSH, reason)
+	}
+	if len(b) < 32 || string(b[4:8]) != "pssh" {
+		return nil, invalid("no pssh box")
+	}
+	if size := binary.BigEndian.Uint32(b); int(size) != len(b) {
+		return nil, invalid(fmt.Sprintf("box size %d, but %d bytes", size, len(b)))
+	}
+	p := &PSSH{Version: b[8]}
+	if p.Version > 1 {
+		return nil, invalid(fmt.Sprintf("unsupported version %d", p.Version))
+	}
+	copy(p.SystemID[:], b[12:28])
+	pos := 28
+	if p.Version == 1 {
+		count := int(binary.BigEndian.Uint32(b[pos:]))
+		pos += 4
+		if count > (len(b)-pos)/16 {
+			return nil, invalid("truncated KIDs")
+		}
+		for i := 0; i < count; i++ {
+			var kid [16]byte
+			copy(kid[:], b[pos:])
+			p.KIDs = append(p.KIDs, kid)
+			pos += 16
+		}
+	}
+	if len(b) < pos+4 {
+		return nil, invalid("missing data size")
+	}
+	size := int(binary.BigEndian.Uint32(b[pos:]))
+	pos += 4
+	if size != len(b)-pos {
+		return nil, invalid(fmt.Sprintf("data size %d, but %d bytes", size, len(b)-pos))
+	}
+	p.Data = append([]byte(nil), b[pos:]...)
+	return p, nil
+}
+
+// Bytes returns the encoded pssh box. The version is 1 if there are KIDs.
+func (p *PSSH) Bytes() []byte {
+	version := p.Version
+	if len(p.KIDs) > 0 {
+		version = 1
+	}
+	size := 32 + len(p.Data)
+	if version == 1 {
+		size += 4 + 16*len(p.KIDs)
+	}
+	b := make([]byte, 0, size)
+	b = binary.BigEndian.AppendUint32(b, uint32(size))
+	b = append(b, "pssh"...)
+	b = append(b, version, 0, 0, 0)
+	b = append(b, p.SystemID[:]...)
+	if version == 1 {
+		b = binary.BigEndian.AppendUint32(b, uint32(len(p.KIDs)))
+		for _, kid := range p.KIDs {
+			b = append(b, kid[:]...)
+		}
+	}
+	b = binary.BigEndian.AppendUint32(b, uint32(len(p.Data)))
+	return append(b, p.Data...)
+}
+
+// ParseDataURI parses an RFC 2397 data URI and returns its media type and data.
+func ParseDataURI(uri string) (mediaType string, data []byte, err error) {
+	rest, ok := strings.CutPrefix(uri, "data:")
+	header, payload, found := strings.Cut(rest, ",")
+	if !ok || !found {
+		return "", nil, fmt.Errorf("%w: %.40q", ErrInvalidDataURI, uri)
+	}
+	mediaType, isBase64 := strings.CutSuffix(header, ";base64")
+	if isBase64 {
+		data, err = base64.StdEncoding.DecodeString(payload)
+	} else {
+		var s string
+		s, err = url.PathUnescape(payload)
+		data = []byte(s)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %s", ErrInvalidDataURI, err.Error())
+	}
+	return mediaType, data, nil
+}
+
+// PSSH decodes the DRM data of a key with a data URI. For Widevine and other KEYFORMATs of the
+// form urn:uuid:<system ID>, the URI contains a pssh box. For PlayReady, the URI contains a
+// PlayReady Object, which is returned as the Data of a PSSH with PlayReadySystemID.
+// An error wrapping ErrUnknownKeyformat is returned for other KEYFORMATs such as FairPlay,
+// which uses skd:// URIs.
+func (k Key) PSSH() (*PSSH, error) {
+	switch {
+	case k.Keyformat == KeyformatPlayReady:
+		_, data, err := ParseDataURI(k.URI)
+		if err != nil {
+			return nil, err
+		}
+		return &PSSH{SystemID: PlayReadySystemID, Data: data}, nil
+	case strings.HasPrefix(strings.ToLower(k.Keyformat), "urn:uuid:"):
+		id, err := ParseSystemID(k.Keyformat)
+		if err != nil {
+			return nil, err
+		}
+		_, data, err := ParseDataURI(k.URI)
+		if err != nil {
+			return nil, err
+		}
+		p, err := ParsePSSH(data)
+		if err != nil {
+			return nil, err
+		}
+		if p.SystemID != id {
+			return nil, fmt.Errorf("%w: system ID %s does not match KEYFORMAT %s", ErrInvalidPSSH, p.SystemID, k.Keyformat)
+		}
+		return p, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownKeyformat, k.Keyformat)
+}
+
+// NewWidevineKey returns a Widevine key with the pssh box in a data URI.
+// The method is SAMPLE-AES for cbcs and SAMPLE-AES-CTR for cenc encryption.
+func NewWidevineKey(method string, pssh *PSSH) Key {
+	p := *pssh
+	p.SystemID = WidevineSystemID
+	return Key{
+		Method:            method,
+		URI:               "data:text/plain;base64," + base64.StdEncoding.EncodeToString(p.Bytes()),
+		Keyformat:         KeyformatWidevine,
+		Keyformatversions: "1",
+	}
+}
+
+// NewPlayReadyKey returns a PlayReady key with the PlayReady Object in a data URI.
+// The method is SAMPLE-AES for cbcs and SAMPLE-AES-CTR for cenc encryption.
+func NewPlayReadyKey(method string, pro []byte) Key {
+	return Key{
+		Method:            method,
+		URI:               "data:text/plain;charset=UTF-16;base64," + base64.StdEncoding.EncodeToString(pro),
+		Keyformat:         KeyformatPlayReady,
+		Keyformatversions: "1",
+	}
+}
+
+// NewFairPlayKey returns a FairPlay Streaming key with METHOD=SAMPLE-AES for an skd:// URI.
+func NewFairPlayKey(uri string) Key {
+	return Key{
+		Method:            "SAMPLE-AES",
+		URI:               uri,
+		Keyformat:         KeyformatFairPlay,
+		Keyformatversions: "1",
+	}
+}
+
+// DeriveSessionKeys sets SessionKeys to the keys used in the chunklists of all variants and
+// renditions, so that clients can prepare them before loading a media playlist. Keys with
+// METHOD=NONE are skipped, and keys are listed once per METHOD, URI, KEYFORMAT and
+// KEYFORMATVERSIONS in order of appearance. The IV is kept if it is the same for all uses of
+// the key. Variants and renditions without Chunklist, see LoadPresentation, are not considered.
+func (p *MasterPlaylist) DeriveSessionKeys() []*Key {
+	var keys keySet
+	seen := make(map[*MediaPlaylist]bool)
+	addPlaylist := func(pl *MediaPlaylist) {
+		if pl == nil || seen[pl] {
+			return
+		}
+		seen[pl] = true
+		keys.add(pl.Keys...)
+		for _, seg := range pl.GetAllSegments() {
+			keys.add(seg.Keys...)
+		}
+	}
+	for _, v := range p.Variants {
+		addPlaylist(v.Chunklist)
+	}
+	for _, alt := range p.RenditionGroups() {
+		for _, r := range alt.Renditions {
+			addPlaylist(r.Chunklist)
+		}
+	}
+	p.SessionKeys = keys.keys
+	p.ResetCache()
+	return keys.keys
+}
+
+// keySet collects session keys once per METHOD, URI, KEYFORMAT and KEYFORMATVERSIONS,
+// keeping the IV only if it is the same for all uses of the key.
+type keySet struct {
+	keys  []*Key
+	index map[Key]int
+}
+
+// add adds keys with a METHOD other than NONE.
+func (s *keySet) add(keys ...Key) {
+	if s.index == nil {
+		s.index = make(map[Key]int)
+	}
+	for _, k := range keys {
+		if k.Method == "" || k.Method == "NONE" {
+			continue
+		}
+		iv := k.IV
+		k.IV = ""
+		if i, ok := s.index[k]; ok {
+			if s.keys[i].IV != iv {
+				s.keys[i].IV = ""
+			}
+			continue
+		}
+		s.index[k] = len(s.keys)
+		key := k
+		key.IV = iv
+		s.keys = append(s.keys, &key)
+	}
+}
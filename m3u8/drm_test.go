@@ -0,0 +1,154 @@
+package m3u8
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestPSSHRoundTrip(t *testing.T) {
+	is := is.New(t)
+	kid := [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	for _, p := range []*PSSH{
+		{SystemID: WidevineSystemID, Data: []byte{0x12, 0x10, 0xaa}},
+		{Version: 1, SystemID: PlayReadySystemID, KIDs: [][16]byte{kid}, Data: []byte("pro")},
+		{SystemID: WidevineSystemID, Data: []byte{}},
+	} {
+		b := p.Bytes()
+		is.Equal(string(b[4:8]), "pssh") // box type
+		got, err := ParsePSSH(b)
+		is.NoErr(err)
+		is.Equal(got.Version, p.Version)   // version
+		is.Equal(got.SystemID, p.SystemID) // system ID
+		is.Equal(got.KIDs, p.KIDs)         // KIDs
+		is.True(bytes.Equal(got.Data, p.Data))
+	}
+	is.Equal(WidevineSystemID.String(), "edef8ba9-79d6-4ace-a3c8-27dcd51d21ed") // UUID form
+}
+
+func TestParsePSSHInvalid(t *testing.T) {
+	is := is.New(t)
+	b := (&PSSH{SystemID: WidevineSystemID, Data: []byte("data")}).Bytes()
+	for _, bad := range [][]byte{
+		nil,
+		b[:len(b)-1],
+		append(append([]byte{}, b...), 0),
+		append(append([]byte{}, b[:4]...), append([]byte("moov"), b[8:]...)...),
+	} {
+		_, err := ParsePSSH(bad)
+		is.True(errors.Is(err, ErrInvalidPSSH)) // invalid box
+	}
+	v1 := (&PSSH{SystemID: WidevineSystemID, KIDs: make([][16]byte, 2)}).Bytes()
+	v1[31] = 5 // KID count beyond the box
+	_, err := ParsePSSH(v1)
+	is.True(errors.Is(err, ErrInvalidPSSH)) // truncated KIDs
+}
+
+func TestParseDataURI(t *testing.T) {
+	is := is.New(t)
+	typ, data, err := ParseDataURI("data:text/plain;base64,aGVsbG8=")
+	is.NoErr(err)
+	is.Equal(typ, "text/plain")
+	is.Equal(string(data), "hello")
+	typ, data, err = ParseDataURI("data:,a%20b")
+	is.NoErr(err)
+	is.Equal(typ, "")
+	is.Equal(string(data), "a b") // percent-decoded
+	_, _, err = ParseDataURI("skd://asset")
+	is.True(errors.Is(err, ErrInvalidDataURI)) // no data URI
+	_, _, err = ParseDataURI("data:;base64,!!")
+	is.True(errors.Is(err, ErrInvalidDataURI)) // invalid base64
+}
+
+func TestDRMKeys(t *testing.T) {
+	is := is.New(t)
+	kid := [16]byte{0xab}
+	wv := NewWidevineKey("SAMPLE-AES", &PSSH{KIDs: [][16]byte{kid}, Data: []byte{0x22, 0x04}})
+	is.Equal(wv.Keyformat, KeyformatWidevine)
+	is.True(strings.HasPrefix(wv.URI, "data:text/plain;base64,"))
+	p, err := wv.PSSH()
+	is.NoErr(err)
+	is.Equal(p.SystemID, WidevineSystemID) // system ID set by builder
+	is.Equal(p.Version, uint8(1))          // version 1 for KIDs
+	is.Equal(p.KIDs, [][16]byte{kid})
+	is.Equal(p.Data, []byte{0x22, 0x04})
+
+	pr := NewPlayReadyKey("SAMPLE-AES-CTR", []byte("<WRMHEADER/>"))
+	is.Equal(pr.Keyformat, KeyformatPlayReady)
+	p, err = pr.PSSH()
+	is.NoErr(err)
+	is.Equal(p.SystemID, PlayReadySystemID)  // PlayReady system ID
+	is.Equal(string(p.Data), "<WRMHEADER/>") // PlayReady Object
+
+	fp := NewFairPlayKey("skd://asset-1")
+	is.Equal(fp, Key{Method: "SAMPLE-AES", URI: "skd://asset-1", Keyformat: KeyformatFairPlay, Keyformatversions: "1"})
+	_, err = fp.PSSH()
+	is.True(errors.Is(err, ErrUnknownKeyformat)) // no PSSH for FairPlay
+
+	// System ID of the box must match the KEYFORMAT
+	prBox := (&PSSH{SystemID: PlayReadySystemID}).Bytes()
+	wv.URI = "data:text/plain;base64," + base64.StdEncoding.EncodeToString(prBox)
+	_, err = wv.PSSH()
+	is.True(errors.Is(err, ErrInvalidPSSH)) // mismatched system ID
+}
+
+func TestDRMKeysDecodeEncode(t *testing.T) {
+	is := is.New(t)
+	wv := NewWidevineKey("SAMPLE-AES", &PSSH{Data: []byte("wv")})
+	p, err := NewMediaPlaylist(0, 2)
+	is.NoErr(err)
+	p.Keys = []Key{wv, NewFairPlayKey("skd://a")}
+	is.NoErr(p.Append("s0.mp4", 6, ""))
+	p.Close()
+	out := p.String()
+	is.True(strings.Contains(out, `KEYFORMAT="`+KeyformatWidevine+`"`)) // Widevine key written
+
+	dec, _, err := DecodeFrom(strings.NewReader(out), true)
+	is.NoErr(err)
+	keys := dec.(*MediaPlaylist).Keys
+	is.Equal(len(keys), 2)
+	pssh, err := keys[0].PSSH()
+	is.NoErr(err)
+	is.Equal(string(pssh.Data), "wv") // PSSH data after round trip
+}
+
+func TestDeriveSessionKeys(t *testing.T) {
+	is := is.New(t)
+	fp := NewFairPlayKey("skd://a")
+	media := func(keys ...[]Key) *MediaPlaylist {
+		p, err := NewMediaPlaylist(0, 4)
+		is.NoErr(err)
+		for i, k := range keys {
+			is.NoErr(p.Append("s.ts", 6, ""))
+			if i == 0 {
+				p.Keys = k
+				continue
+			}
+			is.NoErr(p.SetKey(k[0].Method, k[0].URI, k[0].IV, k[0].Keyformat, k[0].Keyformatversions))
+		}
+		return p
+	}
+	aes1 := Key{Method: "AES-128", URI: "k1", IV: "0x01"}
+	aes1b := Key{Method: "AES-128", URI: "k1", IV: "0x02"}
+	aes2 := Key{Method: "AES-128", URI: "k2", IV: "0x03"}
+	low := media([]Key{fp}, []Key{aes1}, []Key{{Method: "NONE"}})
+	high := media([]Key{fp}, []Key{aes1b}, []Key{aes2})
+	audio := media([]Key{aes2})
+
+	m := NewMasterPlaylist()
+	m.Append("low.m3u8", low, VariantParams{Bandwidth: 1000000, Audio: "aud"})
+	m.Append("high.m3u8", high, VariantParams{Bandwidth: 2000000, Audio: "aud"})
+	is.NoErr(m.AddRendition(&Alternative{Type: "AUDIO", GroupId: "aud", Name: "en", URI: "en.m3u8", Chunklist: audio}))
+	m.Variants[0].Chunklist, m.Variants[1].Chunklist = low, high
+
+	keys := m.DeriveSessionKeys()
+	is.Equal(len(keys), 3)                                       // FairPlay, k1 and k2
+	is.Equal(*keys[0], fp)                                       // playlist key first
+	is.Equal(*keys[1], Key{Method: "AES-128", URI: "k1"})        // IV dropped as it differs
+	is.Equal(*keys[2], aes2)                                     // IV kept as it is the same
+	is.True(strings.Contains(m.String(), "#EXT-X-SESSION-KEY:")) // written to master
+}
@@ -0,0 +1,127 @@
+package m3u8
+
+/*
+ This file defines a DVR that produces a live and a start-over playlist from one segment stream.
+*/
+
+import (
+	"errors"
+)
+
+var ErrDVRClosed = errors.New("dvr is closed")
+
+// DVR feeds every appended media segment into two media playlists:
+// a live playlist with a sliding window and an archive (start-over) playlist
+// that keeps segments up to a maximum age.
+//
+// Segments leaving either playlist carry over their state, so that each playlist
+// has correct EXT-X-MEDIA-SEQUENCE, EXT-X-DISCONTINUITY-SEQUENCE, EXT-X-KEY,
+// EXT-X-MAP and EXT-X-PROGRAM-DATE-TIME values for its first segment.
+// The live window can be redefined by duration using Live.SetWinDuration.
+type DVR struct {
+	Live    *MediaPlaylist // Live is the sliding window playlist
+	Archive *MediaPlaylist // Archive is the start-over playlist
+	maxAge  float64        // maximum duration in seconds of the archive, 0 means no limit
+}
+
+// NewDVR creates a DVR with a live window of winsize segments and an archive
+// limited to maxAge seconds. Set maxAge to 0 to keep all segments,
+// in which case the archive is an EVENT playlist.
+// Capacity is the maximum number of segments in each playlist.
+func NewDVR(winsize uint, maxAge float64, capacity uint) (*DVR, error) {
+	if winsize == 0 {
+		return nil, ErrWinSizeTooSmall
+	}
+	live, err := NewMediaPlaylist(winsize, capacity)
+	if err != nil {
+		return nil, err
+	}
+	archive, err := NewMediaPlaylist(0, capacity)
+	if err != nil {
+		return nil, err
+	}
+	if maxAge > 0 {
+		if err := archive.SetWinDuration(maxAge, 0); err != nil {
+			return nil, err
+		}
+	} else {
+		// An EVENT playlist can only grow, so it is only signalled without an age limit.
+		archive.MediaType = EVENT
+	}
+	return &DVR{Live: live, Archive: archive, maxAge: maxAge}, nil
+}
+
+// MaxAge returns the maximum duration in seconds of the archive. 0 means no limit.
+func (d *DVR) MaxAge() float64 {
+	return d.maxAge
+}
+
+// Append creates and appends a media segment to both playlists.
+func (d *DVR) Append(uri string, duration float64, title string) error {
+	seg := new(MediaSegment)
+	seg.URI = uri
+	seg.Duration = duration
+	seg.Title = title
+	return d.AppendSegment(seg)
+}
+
+// AppendSegment appends a copy of the media segment to both the live and the archive
+// playlist and removes the segments that are outside the live window and the archive age.
+// If a playlist is at capacity and its first segment is still inside its window or age,
+// ErrPlaylistFull is returned and neither playlist is changed.
+func (d *DVR) AppendSegment(seg *MediaSegment) error {
+	if d.Archive.Closed {
+		return ErrDVRClosed
+	}
+	playlists := []*MediaPlaylist{d.Live, d.Archive}
+	for _, p := range playlists {
+		if p.count == p.capacity && !headLeavesWindow(p, seg) {
+			return ErrPlaylistFull
+		}
+	}
+	for _, p := range playlists {
+		if p.count == p.capacity {
+			if err := p.removeHead(); err != nil {
+				return err
+			}
+		}
+		s := *seg
+		if err := p.AppendSegment(&s); err != nil {
+			return err
+		}
+		if p.winDuration == 0 {
+			// AppendSegment only trims windows defined by duration
+			p.trimWindow()
+		}
+		ver, _ := p.CalcMinVersion()
+		updateVersion(&p.ver, ver)
+	}
+	return nil
+}
+
+// headLeavesWindow reports whether the first segment of the playlist is outside
+// its window once seg is appended.
+func headLeavesWindow(p *MediaPlaylist, seg *MediaSegment) bool {
+	if p.count == 0 {
+		return false
+	}
+	if p.winDuration <= 0 {
+		return p.winsize > 0 && p.count >= p.winsize
+	}
+	total := seg.Duration
+	for n := uint(1); n < p.count; n++ {
+		if s := p.Segments[(p.head+n)%p.capacity]; s != nil {
+			total += s.Duration
+		}
+	}
+	return total >= max(p.winDuration, float64(p.winMinTargetDurs*p.TargetDuration))
+}
+
+// Close ends the event. Both playlists get EXT-X-ENDLIST, and the archive
+// is finalized as a VOD playlist.
+func (d *DVR) Close() {
+	d.Live.Close()
+	d.Archive.MediaType = VOD
+	d.Archive.ResetCache()
+	d.Archive.Close()
+}
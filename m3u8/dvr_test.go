@@ -0,0 +1,101 @@
+package m3u8
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestDVR(t *testing.T) {
+	is := is.New(t)
+	_, err := NewDVR(0, 0, 10)
+	is.True(err != nil) // winsize 0 must fail
+
+	d, err := NewDVR(3, 20, 100)
+	is.NoErr(err)                               // create DVR
+	is.Equal(d.MaxAge(), 20.0)                  // max age not 20
+	is.Equal(d.Archive.MediaType, MediaType(0)) // archive with max age must not be EVENT
+
+	pdt := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		seg := &MediaSegment{URI: fmt.Sprintf("seg%d.m4s", i), Duration: 4}
+		switch i {
+		case 0:
+			seg.ProgramDateTime = pdt
+			seg.Map = &Map{URI: "init0.mp4"}
+			seg.Keys = []Key{{Method: "AES-128", URI: "key0"}}
+		case 2:
+			seg.Discontinuity = true
+			seg.Map = &Map{URI: "init1.mp4"}
+			seg.ProgramDateTime = pdt.Add(time.Hour)
+		case 4:
+			seg.Keys = []Key{{Method: "AES-128", URI: "key1"}}
+		}
+		is.NoErr(d.AppendSegment(seg)) // append segment
+	}
+
+	// Live window has segments 7, 8, 9
+	is.Equal(d.Live.Count(), uint(3))            // live count not 3
+	is.Equal(d.Live.SeqNo, uint64(7))            // live SeqNo not 7
+	is.Equal(d.Live.DiscontinuitySeq, uint64(1)) // live discontinuity sequence not 1
+	is.Equal(d.Live.Map.URI, "init1.mp4")        // live map not carried over
+	is.Equal(d.Live.Keys[0].URI, "key1")         // live key not carried over
+	live := d.Live.String()
+	is.True(strings.Contains(live, "#EXT-X-PROGRAM-DATE-TIME:2025-01-01T01:00:20Z\n")) // live PDT not interpolated
+	is.True(strings.Contains(live, "#EXT-X-VERSION:6\n"))                              // live version not 6
+
+	// Archive keeps 20s, i.e. segments 5 to 9
+	is.Equal(d.Archive.Count(), uint(5))            // archive count not 5
+	is.Equal(d.Archive.SeqNo, uint64(5))            // archive SeqNo not 5
+	is.Equal(d.Archive.DiscontinuitySeq, uint64(1)) // archive discontinuity sequence not 1
+	archive := d.Archive.String()
+	is.True(strings.Contains(archive, "#EXT-X-PROGRAM-DATE-TIME:2025-01-01T01:00:12Z\n")) // archive PDT not interpolated
+	is.True(strings.Contains(archive, "seg5.m4s"))                                        // archive first segment missing
+	is.True(!strings.Contains(archive, "seg4.m4s"))                                       // archive has too old segment
+
+	d.Close()
+	is.True(d.Archive.MediaType == VOD)                                // archive not VOD after close
+	is.True(strings.HasSuffix(d.Archive.String(), "#EXT-X-ENDLIST\n")) // archive not ended
+	is.True(strings.Contains(d.Live.String(), "#EXT-X-ENDLIST\n"))     // live not ended
+	is.Equal(d.Append("seg10.m4s", 4, ""), ErrDVRClosed)               // append after close must fail
+}
+
+func TestDVREventArchive(t *testing.T) {
+	is := is.New(t)
+	d, err := NewDVR(2, 0, 3)
+	is.NoErr(err)                         // create DVR
+	is.True(d.Archive.MediaType == EVENT) // archive without max age must be EVENT
+	for i := 0; i < 3; i++ {
+		is.NoErr(d.Append(fmt.Sprintf("seg%d.ts", i), 6, "")) // append segment
+	}
+	is.Equal(d.Live.Count(), uint(2))                                             // live count not 2
+	is.Equal(d.Archive.Count(), uint(3))                                          // archive count not 3
+	is.Equal(d.Append("seg3.ts", 6, ""), ErrPlaylistFull)                         // full EVENT archive must fail
+	is.True(strings.Contains(d.Archive.String(), "#EXT-X-PLAYLIST-TYPE:EVENT\n")) // archive not EVENT
+}
+
+func TestDVRArchiveFull(t *testing.T) {
+	is := is.New(t)
+	d, err := NewDVR(2, 20, 4)
+	is.NoErr(err) // create DVR
+	for i := 0; i < 4; i++ {
+		is.NoErr(d.Append(fmt.Sprintf("seg%d.ts", i), 4, "")) // append segment
+	}
+	err = d.Append("seg4.ts", 4, "")
+	is.Equal(err, ErrPlaylistFull)       // archive head still inside max age
+	is.Equal(d.Archive.Count(), uint(4)) // archive unchanged
+	is.Equal(d.Archive.SeqNo, uint64(0))
+	is.Equal(d.Live.Count(), uint(2)) // live unchanged
+	is.Equal(d.Live.SeqNo, uint64(2))
+
+	d, err = NewDVR(2, 12, 3)
+	is.NoErr(err) // create DVR
+	for i := 0; i < 6; i++ {
+		is.NoErr(d.Append(fmt.Sprintf("seg%d.ts", i), 4, "")) // full archive drops the head outside max age
+	}
+	is.Equal(d.Archive.Count(), uint(3)) // 12s in archive
+	is.Equal(d.Archive.SeqNo, uint64(3))
+}
@@ -0,0 +1,193 @@
+package m3u8
+
+/*
+ This file defines the effective encryption state of segments and AES-128 segment encryption.
+*/
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var ErrNotEncrypted = errors.New("segment is not encrypted")
+var ErrInvalidIV = errors.New("invalid IV")
+var ErrInvalidPadding = errors.New("invalid AES-128 padding")
+
+// EffectiveKeys returns the EXT-X-KEY tags that apply to the segment, which are the keys of the
+// last segment before or at seg that has keys, or the Keys of the playlist. The result may contain
+// a key with METHOD=NONE. If seg is not in the playlist, nil is returned.
+func (p *MediaPlaylist) EffectiveKeys(seg *MediaSegment) []Key {
+	for _, st := range p.segmentStates() {
+		if st.seg == seg {
+			return st.keys
+		}
+	}
+	return nil
+}
+
+// IVFor returns the 16-byte initialization vector of the segment for its first effective key
+// with a METHOD other than NONE. This is the IV attribute of the key, or the media sequence number
+// of the segment as a big-endian 128-bit integer if the attribute is absent.
+// An error wrapping ErrSegmentNotFound is returned if seg is not in the playlist, and
+// ErrNotEncrypted if the segment has no key.
+func (p *MediaPlaylist) IVFor(seg *MediaSegment) ([]byte, error) {
+	keys := p.EffectiveKeys(seg)
+	if keys == nil && !p.hasSegment(seg) {
+		return nil, fmt.Errorf("%w: %s", ErrSegmentNotFound, seg.URI)
+	}
+	for _, key := range keys {
+		if key.Method != "" && key.Method != "NONE" {
+			return keyIV(key, seg.SeqId)
+		}
+	}
+	return nil, ErrNotEncrypted
+}
+
+// hasSegment reports whether seg is in the playlist.
+func (p *MediaPlaylist) hasSegment(seg *MediaSegment) bool {
+	for _, s := range p.GetAllSegments() {
+		if s == seg {
+			return true
+		}
+	}
+	return false
+}
+
+// keyIV returns the IV attribute of the key, or seqId as a big-endian 128-bit integer.
+func keyIV(key Key, seqId uint64) ([]byte, error) {
+	if key.IV == "" {
+		iv := make([]byte, aes.BlockSize)
+		binary.BigEndian.PutUint64(iv[8:], seqId)
+		return iv, nil
+	}
+	s := strings.TrimPrefix(strings.TrimPrefix(key.IV, "0x"), "0X")
+	iv, err := hex.DecodeString(s)
+	if err != nil || len(iv) != aes.BlockSize {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidIV, key.IV)
+	}
+	return iv, nil
+}
+
+// NewAES128DecryptReader returns a reader decrypting a segment encrypted with METHOD=AES-128,
+// which is AES-128 in CBC mode with PKCS7 padding. Reading the end of the segment returns an
+// error wrapping ErrInvalidPadding if the ciphertext is truncated or the padding is wrong.
+func NewAES128DecryptReader(r io.Reader, key, iv []byte) (io.Reader, error) {
+	block, err := newAES128Cipher(key, iv)
+	if err != nil {
+		return nil, err
+	}
+	return &cbcReader{r: r, mode: cipher.NewCBCDecrypter(block, iv), decrypt: true}, nil
+}
+
+// NewAES128EncryptReader returns a reader encrypting a segment with METHOD=AES-128,
+// which is AES-128 in CBC mode with PKCS7 padding.
+func NewAES128EncryptReader(r io.Reader, key, iv []byte) (io.Reader, error) {
+	block, err := newAES128Cipher(key, iv)
+	if err != nil {
+		return nil, err
+	}
+	return &cbcReader{r: r, mode: cipher.NewCBCEncrypter(block, iv)}, nil
+}
+
+func newAES128Cipher(key, iv []byte) (cipher.Block, error) {
+	if len(key) != 16 {
+		return nil, fmt.Errorf("AES-128 key must be 16 bytes, not %d", len(key))
+	}
+	if len(iv) != aes.BlockSize {
+		return nil, fmt.Errorf("%w: must be 16 bytes, not %d", ErrInvalidIV, len(iv))
+	}
+	return aes.NewCipher(key)
+}
+
+// cbcReader encrypts or decrypts the data of r block by block. When decrypting,
+// the last block is held back until the end of r to remove the padding.
+type cbcReader struct {
+	r       io.Reader
+	mode    cipher.BlockMode
+	decrypt bool
+	chunk   []byte // chunk is the read buffer
+	in      []byte // in is the input not processed yet
+	out     []byte // out is the output not returned yet
+	err     error  // err is returned once out is empty
+}
+
+func (c *cbcReader) Read(p []byte) (int, error) {
+	for len(c.out) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		c.fill()
+	}
+	n := copy(p, c.out)
+	c.out = c.out[n:]
+	return n, nil
+}
+
+// fill reads from r and processes all complete blocks.
+func (c *cbcReader) fill() {
+	if c.chunk == nil {
+		c.chunk = make([]byte, 32*1024)
+	}
+	n, err := c.r.Read(c.chunk)
+	c.in = append(c.in, c.chunk[:n]...)
+	switch {
+	case err == io.EOF:
+		c.finish()
+		return
+	case err != nil:
+		c.err = err
+		return
+	}
+	k := len(c.in) / aes.BlockSize * aes.BlockSize
+	if c.decrypt && k == len(c.in) {
+		k -= aes.BlockSize
+	}
+	if k > 0 {
+		c.process(c.in[:k])
+		c.in = append(c.in[:0], c.in[k:]...)
+	}
+}
+
+// finish processes the remaining input at the end of r.
+func (c *cbcReader) finish() {
+	c.err = io.EOF
+	if !c.decrypt {
+		pad := aes.BlockSize - len(c.in)%aes.BlockSize
+		for i := 0; i < pad; i++ {
+			c.in = append(c.in, byte(pad))
+		}
+		c.process(c.in)
+		return
+	}
+	if len(c.in) == 0 || len(c.in)%aes.BlockSize != 0 {
+		c.err = fmt.Errorf("%w: ciphertext is not a multiple of the block size", ErrInvalidPadding)
+		return
+	}
+	start := len(c.out)
+	c.process(c.in)
+	last := c.out[start:]
+	pad := int(last[len(last)-1])
+	valid := pad > 0 && pad <= aes.BlockSize
+	for i := 0; valid && i < pad; i++ {
+		valid = int(last[len(last)-1-i]) == pad
+	}
+	if !valid {
+		c.out = c.out[:start]
+		c.err = ErrInvalidPadding
+		return
+	}
+	c.out = c.out[:len(c.out)-pad]
+}
+
+// process encrypts or decrypts complete blocks and appends them to the output.
+func (c *cbcReader) process(blocks []byte) {
+	out := make([]byte, len(blocks))
+	c.mode.CryptBlocks(out, blocks)
+	c.out = append(c.out, out...)
+}
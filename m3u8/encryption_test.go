@@ -0,0 +1,96 @@
+package m3u8
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+const encryptionTestPlaylist = `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:10
+#EXT-X-MEDIA-SEQUENCE:7
+#EXT-X-KEY:METHOD=NONE
+#EXTINF:10.000,
+clear.ts
+#EXT-X-KEY:METHOD=AES-128,URI="key1.bin"
+#EXTINF:10.000,
+enc1.ts
+#EXTINF:10.000,
+enc2.ts
+#EXT-X-KEY:METHOD=AES-128,URI="key2.bin",IV=0x000102030405060708090A0B0C0D0E0F
+#EXTINF:10.000,
+enc3.ts
+#EXT-X-KEY:METHOD=NONE
+#EXTINF:10.000,
+clear2.ts
+#EXT-X-ENDLIST
+`
+
+func TestEffectiveKeysAndIV(t *testing.T) {
+	is := is.New(t)
+	p, _, err := DecodeFrom(strings.NewReader(encryptionTestPlaylist), true)
+	is.NoErr(err)
+	pl := p.(*MediaPlaylist)
+	segs := pl.GetAllSegments()
+
+	is.Equal(pl.EffectiveKeys(segs[0])[0].Method, "NONE")   // not encrypted
+	is.Equal(pl.EffectiveKeys(segs[2])[0].URI, "key1.bin")  // key of previous segment
+	is.Equal(pl.EffectiveKeys(segs[3])[0].URI, "key2.bin")  // new key
+	is.Equal(pl.EffectiveKeys(segs[4])[0].Method, "NONE")   // encryption stopped
+	is.Equal(pl.EffectiveKeys(&MediaSegment{}), []Key(nil)) // unknown segment
+
+	iv, err := pl.IVFor(segs[2])
+	is.NoErr(err)
+	is.Equal(iv, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 9}) // media sequence number
+	iv, err = pl.IVFor(segs[3])
+	is.NoErr(err)
+	is.Equal(iv, []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}) // explicit IV
+
+	_, err = pl.IVFor(segs[0])
+	is.True(errors.Is(err, ErrNotEncrypted))
+	_, err = pl.IVFor(segs[4])
+	is.True(errors.Is(err, ErrNotEncrypted))
+	_, err = pl.IVFor(&MediaSegment{URI: "other.ts"})
+	is.True(errors.Is(err, ErrSegmentNotFound))
+	_, err = keyIV(Key{Method: "AES-128", IV: "0x0102"}, 0)
+	is.True(errors.Is(err, ErrInvalidIV))
+}
+
+func TestAES128Readers(t *testing.T) {
+	is := is.New(t)
+	key := []byte("0123456789abcdef")
+	iv := make([]byte, 16)
+	for _, size := range []int{0, 1, 15, 16, 17, 100000} {
+		plain := bytes.Repeat([]byte{'x'}, size)
+		enc, err := NewAES128EncryptReader(bytes.NewReader(plain), key, iv)
+		is.NoErr(err)
+		cipherText, err := io.ReadAll(enc)
+		is.NoErr(err)                             // encrypt
+		is.Equal(len(cipherText), (size/16+1)*16) // PKCS7 padding added
+
+		dec, err := NewAES128DecryptReader(bytes.NewReader(cipherText), key, iv)
+		is.NoErr(err)
+		decrypted, err := io.ReadAll(dec)
+		is.NoErr(err)                          // decrypt
+		is.True(bytes.Equal(decrypted, plain)) // round trip
+	}
+
+	enc, _ := NewAES128EncryptReader(strings.NewReader("segment data"), key, iv)
+	cipherText, _ := io.ReadAll(enc)
+	dec, _ := NewAES128DecryptReader(bytes.NewReader(cipherText), []byte("fedcba9876543210"), iv)
+	_, err := io.ReadAll(dec)
+	is.True(errors.Is(err, ErrInvalidPadding)) // wrong key
+	dec, _ = NewAES128DecryptReader(bytes.NewReader(cipherText[:10]), key, iv)
+	_, err = io.ReadAll(dec)
+	is.True(errors.Is(err, ErrInvalidPadding)) // truncated
+
+	_, err = NewAES128DecryptReader(bytes.NewReader(cipherText), key[:8], iv)
+	is.True(err != nil) // key too short
+	_, err = NewAES128EncryptReader(bytes.NewReader(cipherText), key, iv[:8])
+	is.True(errors.Is(err, ErrInvalidIV))
+}
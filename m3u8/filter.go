@@ -0,0 +1,162 @@
+package m3u8
+
+/*
+ This file defines filtering of master playlists by device profile.
+*/
+
+import (
+	"strings"
+)
+
+// DeviceProfile describes the variants and renditions of a device class for MasterPlaylist.Filter.
+// A zero field does not remove anything.
+type DeviceProfile struct {
+	MaxBandwidth uint32   // MaxBandwidth removes variants with a higher BANDWIDTH
+	MaxWidth     int      // MaxWidth removes variants with a wider RESOLUTION
+	MaxHeight    int      // MaxHeight removes variants with a higher RESOLUTION
+	MaxFrameRate float64  // MaxFrameRate removes variants with a higher FRAME-RATE
+	Codecs       []string // Codecs removes variants with a codec family not listed, e.g. CodecAVC
+	// HDCPLevel is the highest HDCP-LEVEL kept. Empty keeps only variants without HDCP-LEVEL
+	// or with HDCP-LEVEL=NONE.
+	HDCPLevel   string
+	VideoRanges []string // VideoRanges removes variants with a VIDEO-RANGE not listed
+	// Languages are the languages of the renditions to keep. A language matches its subtags,
+	// so "en" keeps "en-US". Renditions without LANGUAGE are always kept.
+	Languages []string
+}
+
+// Filter returns a new master playlist with the variants and renditions that match the profile.
+// Variants are checked as in SelectVariant and must not exceed MaxBandwidth. I-frame variants
+// are removed if they do not match or if no other variant is left. Renditions are removed if
+// their group is no longer referenced or their language is not in Languages, but the DEFAULT=YES
+// or first rendition of a referenced group is kept so that the group is never empty.
+// The version is set to the result of CalcMinVersion.
+//
+// Variants and renditions are copied, while chunklists and other fields are shared with p.
+func (p *MasterPlaylist) Filter(profile DeviceProfile) (*MasterPlaylist, error) {
+	caps := DeviceCapabilities{
+		MaxWidth:     profile.MaxWidth,
+		MaxHeight:    profile.MaxHeight,
+		MaxFrameRate: profile.MaxFrameRate,
+		Codecs:       profile.Codecs,
+		HDCPLevel:    profile.HDCPLevel,
+		VideoRanges:  profile.VideoRanges,
+	}
+	matches := func(v *Variant) bool {
+		return caps.reject(v) == "" && (profile.MaxBandwidth == 0 || v.Bandwidth <= profile.MaxBandwidth)
+	}
+	var variants, iframes []*Variant
+	groups := make(map[string]bool) // referenced groups by type and group ID
+	for _, v := range p.Variants {
+		switch {
+		case !matches(v):
+		case v.Iframe:
+			iframes = append(iframes, v)
+		default:
+			variants = append(variants, v)
+			groups["AUDIO/"+v.Audio] = v.Audio != ""
+			groups["VIDEO/"+v.Video] = v.Video != ""
+			groups["SUBTITLES/"+v.Subtitles] = v.Subtitles != ""
+			groups["CLOSED-CAPTIONS/"+v.Captions] = v.Captions != "" && v.Captions != "NONE"
+		}
+	}
+	if len(variants) == 0 {
+		return nil, ErrNoVariant
+	}
+
+	// Select the renditions of referenced groups in the allowed languages
+	var alts []*Alternative
+	seen := make(map[*Alternative]bool)
+	addAlt := func(alt *Alternative) {
+		if alt != nil && !seen[alt] && groups[alt.Type+"/"+alt.GroupId] {
+			seen[alt] = true
+			alts = append(alts, alt)
+		}
+	}
+	for _, alt := range p.Alternatives {
+		addAlt(alt)
+	}
+	for _, v := range variants {
+		for _, alt := range v.Alternatives {
+			addAlt(alt)
+		}
+	}
+	copies := make(map[*Alternative]*Alternative)
+	kept := make(map[string]bool)
+	for _, alt := range alts {
+		if profile.languageAllowed(alt.Language) {
+			c := *alt
+			copies[alt] = &c
+			kept[alt.Type+"/"+alt.GroupId] = true
+		}
+	}
+	for _, alt := range alts {
+		if key := alt.Type + "/" + alt.GroupId; !kept[key] && (alt.Default || !hasDefaultRendition(alts, alt)) {
+			c := *alt
+			copies[alt] = &c
+			kept[key] = true
+		}
+	}
+
+	out := NewMasterPlaylist()
+	out.Args = p.Args
+	out.BaseURL = p.BaseURL
+	out.StartTime = p.StartTime
+	out.StartTimePrecise = p.StartTimePrecise
+	out.Defines = p.Defines
+	out.SessionDatas = p.SessionDatas
+	out.SessionKeys = p.SessionKeys
+	out.ContentSteering = p.ContentSteering
+	out.Custom = p.Custom
+	out.customDecoders = p.customDecoders
+	out.independentSegments = p.independentSegments
+	out.writePrecision = p.writePrecision
+	for _, alt := range alts {
+		if c, ok := copies[alt]; ok {
+			out.Alternatives = append(out.Alternatives, c)
+		}
+	}
+	for _, v := range append(variants, iframes...) {
+		c := *v
+		c.Alternatives = nil
+		for _, alt := range v.Alternatives {
+			if altCopy, ok := copies[alt]; ok {
+				c.Alternatives = append(c.Alternatives, altCopy)
+			}
+		}
+		out.Variants = append(out.Variants, &c)
+	}
+	out.ver, _ = out.CalcMinVersion()
+	return out, nil
+}
+
+// languageAllowed reports whether a rendition in language is kept.
+func (profile DeviceProfile) languageAllowed(language string) bool {
+	if language == "" || len(profile.Languages) == 0 {
+		return true
+	}
+	for _, l := range profile.Languages {
+		if strings.EqualFold(language, l) || strings.HasPrefix(strings.ToLower(language), strings.ToLower(l)+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+// hasDefaultRendition reports whether the group of alt has a rendition with DEFAULT=YES.
+// If not, the first rendition of the group is used, and the result is true for all others.
+func hasDefaultRendition(alts []*Alternative, alt *Alternative) bool {
+	for _, a := range alts {
+		if a.Type == alt.Type && a.GroupId == alt.GroupId {
+			if a.Default {
+				return true
+			}
+		}
+	}
+	for _, a := range alts {
+		if a.Type == alt.Type && a.GroupId == alt.GroupId {
+			return a != alt
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package m3u8
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+const filterTestMaster = `#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="English",LANGUAGE="en",DEFAULT=YES,URI="audio/en.m3u8"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="Deutsch",LANGUAGE="de",URI="audio/de.m3u8"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="ec3",NAME="English",LANGUAGE="en-US",DEFAULT=YES,URI="audio/en_ec3.m3u8"
+#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="Deutsch",LANGUAGE="de",URI="subs/de.m3u8"
+#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="Francais",LANGUAGE="fr",URI="subs/fr.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360,FRAME-RATE=25.000,CODECS="avc1.64001e,mp4a.40.2",AUDIO="aac",SUBTITLES="subs"
+360p.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=2500000,RESOLUTION=1280x720,FRAME-RATE=25.000,CODECS="avc1.64001f,mp4a.40.2",AUDIO="aac",SUBTITLES="subs"
+720p.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=6000000,RESOLUTION=1920x1080,FRAME-RATE=50.000,CODECS="hvc1.2.4.L123.B0,ec-3",VIDEO-RANGE=PQ,HDCP-LEVEL=TYPE-0,AUDIO="ec3",SUBTITLES="subs"
+1080p.m3u8
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=100000,RESOLUTION=640x360,CODECS="avc1.64001e",URI="360p_iframes.m3u8"
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=300000,RESOLUTION=1920x1080,CODECS="hvc1.2.4.L123.B0",VIDEO-RANGE=PQ,HDCP-LEVEL=TYPE-0,URI="1080p_iframes.m3u8"
+`
+
+func TestFilterMasterPlaylist(t *testing.T) {
+	is := is.New(t)
+	p, _, err := DecodeFrom(strings.NewReader(filterTestMaster), true)
+	is.NoErr(err)
+	m := p.(*MasterPlaylist)
+
+	out, err := m.Filter(DeviceProfile{MaxHeight: 720, Codecs: []string{CodecAVC, CodecAAC}, Languages: []string{"en"}})
+	is.NoErr(err)
+	var uris []string
+	for _, v := range out.Variants {
+		uris = append(uris, v.URI)
+	}
+	is.Equal(uris, []string{"360p.m3u8", "720p.m3u8", "360p_iframes.m3u8"}) // matching variants and I-frame variants
+	s := out.String()
+	is.True(strings.Contains(s, `URI="audio/en.m3u8"`))                       // audio in language kept
+	is.True(!strings.Contains(s, `URI="audio/de.m3u8"`))                      // audio in other language removed
+	is.True(!strings.Contains(s, `GROUP-ID="ec3"`))                           // unreferenced group removed
+	is.True(strings.Contains(s, `URI="subs/de.m3u8"`))                        // first rendition keeps group
+	is.True(!strings.Contains(s, `URI="subs/fr.m3u8"`))                       // other subtitles removed
+	is.True(!strings.Contains(s, "1080p"))                                    // HDR variant and its I-frames removed
+	is.Equal(len(m.Variants), 5)                                              // original is not changed
+	is.True(strings.Contains(m.String(), "audio/de.m3u8"))                    // original renditions are kept
+	is.True(out.Variants[0].Alternatives[0] != m.Variants[0].Alternatives[0]) // renditions are copied
+
+	ver, _ := out.CalcMinVersion()
+	is.Equal(out.Version(), ver) // version recalculated
+
+	out, err = m.Filter(DeviceProfile{MaxBandwidth: 7000000, HDCPLevel: "TYPE-0", Languages: []string{"en"}})
+	is.NoErr(err)
+	s = out.String()
+	is.True(strings.Contains(s, `URI="audio/en_ec3.m3u8"`))  // language subtag matches
+	is.True(strings.Contains(s, `URI="1080p_iframes.m3u8"`)) // I-frame variant kept with HDCP
+
+	out, err = m.Filter(DeviceProfile{MaxBandwidth: 500000})
+	is.True(errors.Is(err, ErrNoVariant)) // only I-frame variants fit
+	is.Equal(out, (*MasterPlaylist)(nil))
+}
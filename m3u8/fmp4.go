@@ -0,0 +1,473 @@
+package m3u8
+
+/*
+ This file defines functions for generating a media playlist from fragmented MP4 (CMAF) files.
+*/
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+var ErrInvalidMP4 = errors.New("invalid MP4 data")
+
+// mp4Box is an ISOBMFF box.
+type mp4Box struct {
+	typ     string
+	offset  int    // offset of the box in its parent data
+	size    int    // total size including the header
+	payload []byte // data after the header
+}
+
+// mp4Track is the timing information of a track in an init segment.
+type mp4Track struct {
+	id              uint32
+	timescale       uint32
+	defaultDuration uint32 // default sample duration from trex
+	defaultFlags    uint32 // default sample flags from trex
+}
+
+// MediaPlaylistFromFMP4 returns a closed VOD media playlist for a CMAF track stored as an
+// init segment and media segments in fsys. The init segment is set as EXT-X-MAP, and the
+// segment URIs are the given names. Durations are calculated from the moof/tfdt/trun boxes
+// of the first track in the init segment.
+func MediaPlaylistFromFMP4(fsys fs.FS, initName string, segmentNames ...string) (*MediaPlaylist, error) {
+	initData, err := fs.ReadFile(fsys, initName)
+	if err != nil {
+		return nil, err
+	}
+	track, err := parseMP4Init(initData)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", initName, err)
+	}
+	if len(segmentNames) == 0 {
+		return nil, ErrPlaylistEmpty
+	}
+	p, err := NewMediaPlaylist(0, uint(len(segmentNames)))
+	if err != nil {
+		return nil, err
+	}
+	p.Map = &Map{URI: initName}
+	for _, name := range segmentNames {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		ticks, err := mp4FragmentDuration(data, track)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if err := p.AppendSegment(&MediaSegment{URI: name, Duration: float64(ticks) / float64(track.timescale)}); err != nil {
+			return nil, err
+		}
+	}
+	finishVOD(p)
+	return p, nil
+}
+
+// MediaPlaylistFromFMP4File returns a closed VOD media playlist for a single fragmented MP4 file
+// in fsys with a sidx box. EXT-X-MAP refers to the byte range of the init part of the file, and
+// every subsegment in the sidx box becomes a segment with EXT-X-BYTERANGE.
+func MediaPlaylistFromFMP4File(fsys fs.FS, name string) (*MediaPlaylist, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	p, err := mp4ByteRangePlaylist(data, name)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return p, nil
+}
+
+func mp4ByteRangePlaylist(data []byte, name string) (*MediaPlaylist, error) {
+	boxes, err := parseMP4Boxes(data)
+	if err != nil {
+		return nil, err
+	}
+	moov := findMP4Box(boxes, "moov")
+	sidx := findMP4Box(boxes, "sidx")
+	if moov == nil || sidx == nil {
+		return nil, fmt.Errorf("%w: moov or sidx box missing", ErrInvalidMP4)
+	}
+	b := sidx.payload
+	if len(b) < 12 {
+		return nil, fmt.Errorf("%w: sidx box too short", ErrInvalidMP4)
+	}
+	timescale := binary.BigEndian.Uint32(b[8:])
+	var firstOffset uint64
+	pos := 12
+	if b[0] == 0 {
+		if len(b) < pos+12 {
+			return nil, fmt.Errorf("%w: sidx box too short", ErrInvalidMP4)
+		}
+		firstOffset = uint64(binary.BigEndian.Uint32(b[pos+4:]))
+		pos += 8
+	} else {
+		if len(b) < pos+20 {
+			return nil, fmt.Errorf("%w: sidx box too short", ErrInvalidMP4)
+		}
+		firstOffset = binary.BigEndian.Uint64(b[pos+8:])
+		pos += 16
+	}
+	refCount := int(binary.BigEndian.Uint16(b[pos+2:]))
+	pos += 4
+	if timescale == 0 || refCount == 0 || len(b) < pos+12*refCount {
+		return nil, fmt.Errorf("%w: invalid sidx box", ErrInvalidMP4)
+	}
+
+	p, err := NewMediaPlaylist(0, uint(refCount))
+	if err != nil {
+		return nil, err
+	}
+	p.Map = &Map{URI: name, Limit: int64(moov.offset + moov.size)}
+	offset := int64(sidx.offset+sidx.size) + int64(firstOffset)
+	for i := 0; i < refCount; i++ {
+		ref := b[pos+12*i:]
+		if ref[0]&0x80 != 0 {
+			return nil, fmt.Errorf("%w: hierarchical sidx not supported", ErrInvalidMP4)
+		}
+		size := int64(binary.BigEndian.Uint32(ref) & 0x7fffffff)
+		duration := binary.BigEndian.Uint32(ref[4:])
+		seg := &MediaSegment{
+			URI:      name,
+			Duration: float64(duration) / float64(timescale),
+			Limit:    size,
+			Offset:   offset,
+		}
+		if err := p.AppendSegment(seg); err != nil {
+			return nil, err
+		}
+		offset += size
+	}
+	if offset > int64(len(data)) {
+		return nil, fmt.Errorf("%w: sidx references beyond end of file", ErrInvalidMP4)
+	}
+	finishVOD(p)
+	return p, nil
+}
+
+// finishVOD sets the version and target duration of a generated playlist and closes it.
+func finishVOD(p *MediaPlaylist) {
+	p.MediaType = VOD
+	ver, _ := p.CalcMinVersion()
+	updateVersion(&p.ver, ver)
+	p.SetTargetDuration(p.CalculateTargetDuration(p.ver))
+	p.Close()
+}
+
+// parseMP4Init returns the timing information of the first track in an init segment.
+func parseMP4Init(data []byte) (mp4Track, error) {
+	boxes, err := parseMP4Boxes(data)
+	if err != nil {
+		return mp4Track{}, err
+	}
+	moov := findMP4Box(boxes, "moov")
+	if moov == nil {
+		return mp4Track{}, fmt.Errorf("%w: moov box missing", ErrInvalidMP4)
+	}
+	moovBoxes, err := parseMP4Boxes(moov.payload)
+	if err != nil {
+		return mp4Track{}, err
+	}
+	var track mp4Track
+	if mvhd := findMP4Box(moovBoxes, "mvhd"); mvhd != nil {
+		track.timescale = mp4HeaderTimescale(mvhd.payload)
+	}
+	trak, err := findMP4Path(moovBoxes, "trak")
+	if err != nil {
+		return mp4Track{}, err
+	}
+	trakBoxes, _ := parseMP4Boxes(trak.payload)
+	if tkhd := findMP4Box(trakBoxes, "tkhd"); tkhd != nil {
+		track.id = mp4HeaderTrackID(tkhd.payload)
+	}
+	if mdhd, err := findMP4Path(trakBoxes, "mdia", "mdhd"); err == nil {
+		if ts := mp4HeaderTimescale(mdhd.payload); ts > 0 {
+			track.timescale = ts
+		}
+	}
+	if track.timescale == 0 {
+		return mp4Track{}, fmt.Errorf("%w: no timescale", ErrInvalidMP4)
+	}
+	if mvex := findMP4Box(moovBoxes, "mvex"); mvex != nil {
+		mvexBoxes, _ := parseMP4Boxes(mvex.payload)
+		for _, trex := range mvexBoxes {
+			if trex.typ == "trex" && len(trex.payload) >= 24 && binary.BigEndian.Uint32(trex.payload[4:]) == track.id {
+				track.defaultDuration = binary.BigEndian.Uint32(trex.payload[12:])
+				track.defaultFlags = binary.BigEndian.Uint32(trex.payload[20:])
+			}
+		}
+	}
+	return track, nil
+}
+
+// mp4FragmentDuration returns the total sample duration of track in all moof boxes of data.
+func mp4FragmentDuration(data []byte, track mp4Track) (uint64, error) {
+	fragments, err := parseMP4Fragments(data, track)
+	if err != nil {
+		return 0, err
+	}
+	if len(fragments) == 0 {
+		return 0, fmt.Errorf("%w: no fragment for track %d", ErrInvalidMP4, track.id)
+	}
+	var total uint64
+	for _, f := range fragments {
+		total += f.duration
+	}
+	return total, nil
+}
+
+// mp4Fragment is the information about track in a moof box.
+type mp4Fragment struct {
+	moofOffset int    // offset of the moof box
+	baseTime   uint64 // base media decode time from tfdt
+	duration   uint64 // total duration of the samples
+	firstFlags uint32 // sample flags of the first sample
+	firstSize  uint32 // size of the first sample
+	firstCTO   int64  // composition time offset of the first sample
+	dataOffset int64  // offset of the first sample in data
+	// explicitBase is set if tfhd has a base-data-offset, so dataOffset is not relative to the moof box
+	explicitBase bool
+}
+
+// isSync reports whether the first sample of the fragment is a sync sample.
+func (f mp4Fragment) isSync() bool {
+	return f.firstFlags&0x00010000 == 0 // sample_is_non_sync_sample
+}
+
+// parseMP4Fragments returns the fragments of track in the moof boxes of data.
+func parseMP4Fragments(data []byte, track mp4Track) ([]mp4Fragment, error) {
+	boxes, err := parseMP4Boxes(data)
+	if err != nil {
+		return nil, err
+	}
+	var fragments []mp4Fragment
+	for _, moof := range boxes {
+		if moof.typ != "moof" {
+			continue
+		}
+		moofBoxes, err := parseMP4Boxes(moof.payload)
+		if err != nil {
+			return nil, err
+		}
+		for _, traf := range moofBoxes {
+			if traf.typ != "traf" {
+				continue
+			}
+			f, ok, err := parseMP4Traf(traf.payload, track)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				f.moofOffset = moof.offset
+				if !f.explicitBase {
+					f.dataOffset += int64(moof.offset)
+				}
+				fragments = append(fragments, f)
+			}
+		}
+	}
+	return fragments, nil
+}
+
+// parseMP4Traf returns the fragment information of a traf box if it belongs to track.
+// The data offset is relative to the moof box, unless tfhd has an explicit base-data-offset.
+func parseMP4Traf(data []byte, track mp4Track) (mp4Fragment, bool, error) {
+	var f mp4Fragment
+	boxes, err := parseMP4Boxes(data)
+	if err != nil {
+		return f, false, err
+	}
+	tfhd := findMP4Box(boxes, "tfhd")
+	if tfhd == nil || len(tfhd.payload) < 8 {
+		return f, false, fmt.Errorf("%w: tfhd box missing", ErrInvalidMP4)
+	}
+	if track.id != 0 && binary.BigEndian.Uint32(tfhd.payload[4:]) != track.id {
+		return f, false, nil
+	}
+	defaultDuration, defaultSize, defaultFlags := track.defaultDuration, uint32(0), track.defaultFlags
+	flags := binary.BigEndian.Uint32(tfhd.payload) & 0xffffff
+	b := tfhd.payload[8:]
+	for _, field := range []uint32{0x01, 0x02, 0x08, 0x10, 0x20} {
+		if flags&field == 0 {
+			continue
+		}
+		size := 4
+		if field == 0x01 {
+			size = 8
+		}
+		if len(b) < size {
+			return f, false, fmt.Errorf("%w: tfhd box too short", ErrInvalidMP4)
+		}
+		switch field {
+		case 0x01: // base-data-offset
+			f.dataOffset, f.explicitBase = int64(binary.BigEndian.Uint64(b)), true
+		case 0x08:
+			defaultDuration = binary.BigEndian.Uint32(b)
+		case 0x10:
+			defaultSize = binary.BigEndian.Uint32(b)
+		case 0x20:
+			defaultFlags = binary.BigEndian.Uint32(b)
+		}
+		b = b[size:]
+	}
+	if tfdt := findMP4Box(boxes, "tfdt"); tfdt != nil && len(tfdt.payload) >= 8 {
+		if tfdt.payload[0] == 1 && len(tfdt.payload) >= 12 {
+			f.baseTime = binary.BigEndian.Uint64(tfdt.payload[4:])
+		} else {
+			f.baseTime = uint64(binary.BigEndian.Uint32(tfdt.payload[4:]))
+		}
+	}
+
+	first := true
+	for _, trun := range boxes {
+		if trun.typ != "trun" {
+			continue
+		}
+		b := trun.payload
+		if len(b) < 8 {
+			return f, false, fmt.Errorf("%w: trun box too short", ErrInvalidMP4)
+		}
+		version, flags := b[0], binary.BigEndian.Uint32(b)&0xffffff
+		count := int64(binary.BigEndian.Uint32(b[4:]))
+		pos := 8
+		var dataOffset int32
+		firstFlags, hasFirstFlags := uint32(0), false
+		if flags&0x01 != 0 { // data-offset
+			if len(b) < pos+4 {
+				return f, false, fmt.Errorf("%w: trun box too short", ErrInvalidMP4)
+			}
+			dataOffset = int32(binary.BigEndian.Uint32(b[pos:]))
+			pos += 4
+		}
+		if flags&0x04 != 0 { // first-sample-flags
+			if len(b) < pos+4 {
+				return f, false, fmt.Errorf("%w: trun box too short", ErrInvalidMP4)
+			}
+			firstFlags, hasFirstFlags = binary.BigEndian.Uint32(b[pos:]), true
+			pos += 4
+		}
+		sampleSize := 0
+		for _, bit := range []uint32{0x100, 0x200, 0x400, 0x800} {
+			if flags&bit != 0 {
+				sampleSize += 4
+			}
+		}
+		if int64(len(b)-pos) < count*int64(sampleSize) {
+			return f, false, fmt.Errorf("%w: trun box too short for %d samples", ErrInvalidMP4, count)
+		}
+		if sampleSize == 0 && count > 1 {
+			// Samples without fields only differ in the first sample flags
+			f.duration += uint64(count-1) * uint64(defaultDuration)
+			count = 1
+		}
+		for i := 0; i < int(count); i++ {
+			sample := b[pos+i*sampleSize:]
+			duration, size, sampleFlags, cto := defaultDuration, defaultSize, defaultFlags, int64(0)
+			if flags&0x100 != 0 {
+				duration = binary.BigEndian.Uint32(sample)
+				sample = sample[4:]
+			}
+			if flags&0x200 != 0 {
+				size = binary.BigEndian.Uint32(sample)
+				sample = sample[4:]
+			}
+			if flags&0x400 != 0 {
+				sampleFlags = binary.BigEndian.Uint32(sample)
+				sample = sample[4:]
+			}
+			if flags&0x800 != 0 { // signed in version 1
+				if version == 1 {
+					cto = int64(int32(binary.BigEndian.Uint32(sample)))
+				} else {
+					cto = int64(binary.BigEndian.Uint32(sample))
+				}
+			}
+			if i == 0 && hasFirstFlags {
+				sampleFlags = firstFlags
+			}
+			if first {
+				f.firstFlags, f.firstSize, f.firstCTO = sampleFlags, size, cto
+				f.dataOffset += int64(dataOffset)
+				first = false
+			}
+			f.duration += uint64(duration)
+		}
+	}
+	return f, true, nil
+}
+
+// mp4HeaderTimescale returns the timescale of an mvhd or mdhd box payload.
+func mp4HeaderTimescale(b []byte) uint32 {
+	pos := 12
+	if len(b) > 0 && b[0] == 1 {
+		pos = 20
+	}
+	if len(b) < pos+4 {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b[pos:])
+}
+
+// mp4HeaderTrackID returns the track ID of a tkhd box payload.
+func mp4HeaderTrackID(b []byte) uint32 {
+	return mp4HeaderTimescale(b) // track_ID is at the same position as the timescale in mvhd
+}
+
+// parseMP4Boxes splits data into boxes.
+func parseMP4Boxes(data []byte) ([]mp4Box, error) {
+	var boxes []mp4Box
+	for pos := 0; pos < len(data); {
+		if len(data)-pos < 8 {
+			return nil, fmt.Errorf("%w: truncated box header at offset %d", ErrInvalidMP4, pos)
+		}
+		size := uint64(binary.BigEndian.Uint32(data[pos:]))
+		typ := string(data[pos+4 : pos+8])
+		header := 8
+		switch size {
+		case 0:
+			size = uint64(len(data) - pos)
+		case 1:
+			if len(data)-pos < 16 {
+				return nil, fmt.Errorf("%w: truncated box header at offset %d", ErrInvalidMP4, pos)
+			}
+			size = binary.BigEndian.Uint64(data[pos+8:])
+			header = 16
+		}
+		if size < uint64(header) || size > uint64(len(data)-pos) {
+			return nil, fmt.Errorf("%w: invalid size of %s box at offset %d", ErrInvalidMP4, typ, pos)
+		}
+		boxes = append(boxes, mp4Box{typ: typ, offset: pos, size: int(size), payload: data[pos+header : pos+int(size)]})
+		pos += int(size)
+	}
+	return boxes, nil
+}
+
+func findMP4Box(boxes []mp4Box, typ string) *mp4Box {
+	for i := range boxes {
+		if boxes[i].typ == typ {
+			return &boxes[i]
+		}
+	}
+	return nil
+}
+
+// findMP4Path returns the first box at the path of box types below boxes.
+func findMP4Path(boxes []mp4Box, path ...string) (*mp4Box, error) {
+	var box *mp4Box
+	for i, typ := range path {
+		if box = findMP4Box(boxes, typ); box == nil {
+			return nil, fmt.Errorf("%w: %s box missing", ErrInvalidMP4, typ)
+		}
+		if i < len(path)-1 {
+			var err error
+			if boxes, err = parseMP4Boxes(box.payload); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return box, nil
+}
@@ -0,0 +1,121 @@
+package m3u8
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/matryer/is"
+)
+
+// mp4TestBox returns a box with the given type and payload.
+func mp4TestBox(typ string, payload ...[]byte) []byte {
+	var data []byte
+	for _, p := range payload {
+		data = append(data, p...)
+	}
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(data)))
+	return append(append(b, typ...), data...)
+}
+
+func u32s(values ...uint32) []byte {
+	var b []byte
+	for _, v := range values {
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+	return b
+}
+
+// mp4TestInit returns an init segment with track 1 in timescale 1000 and default sample duration 40.
+func mp4TestInit() []byte {
+	return append(mp4TestBox("ftyp", []byte("cmfc"), u32s(0)),
+		mp4TestBox("moov",
+			mp4TestBox("mvhd", u32s(0, 0, 0, 600, 0)),
+			mp4TestBox("trak",
+				mp4TestBox("tkhd", u32s(0, 0, 0, 1, 0)),
+				mp4TestBox("mdia", mp4TestBox("mdhd", u32s(0, 0, 0, 1000, 0)))),
+			mp4TestBox("mvex", mp4TestBox("trex", u32s(0, 1, 1, 40, 0, 0))))...)
+}
+
+// mp4TestFragment returns a moof with a trun of n samples of the default duration
+// and a trun with explicit durations, followed by an mdat.
+func mp4TestFragment(n uint32, durations ...uint32) []byte {
+	explicit := u32s(0x000100, uint32(len(durations)))
+	explicit = append(explicit, u32s(durations...)...)
+	return append(mp4TestBox("moof",
+		mp4TestBox("mfhd", u32s(0, 1)),
+		mp4TestBox("traf",
+			mp4TestBox("tfhd", u32s(0x020000, 1)),
+			mp4TestBox("tfdt", u32s(0, 0)),
+			mp4TestBox("trun", u32s(0x000001, n, 0)),
+			mp4TestBox("trun", explicit))),
+		mp4TestBox("mdat", make([]byte, 16))...)
+}
+
+func TestMediaPlaylistFromFMP4(t *testing.T) {
+	is := is.New(t)
+	fsys := fstest.MapFS{
+		"init.mp4": &fstest.MapFile{Data: mp4TestInit()},
+		"seg0.m4s": &fstest.MapFile{Data: mp4TestFragment(50)},
+		"seg1.m4s": &fstest.MapFile{Data: append(mp4TestFragment(25), mp4TestFragment(24, 40)...)},
+		"seg2.m4s": &fstest.MapFile{Data: mp4TestFragment(0, 500, 500, 300)},
+	}
+	p, err := MediaPlaylistFromFMP4(fsys, "init.mp4", "seg0.m4s", "seg1.m4s", "seg2.m4s")
+	is.NoErr(err)                   // generate playlist
+	is.Equal(p.Map.URI, "init.mp4") // map from init segment
+	segs := p.GetAllSegments()
+	is.Equal(len(segs), 3)
+	is.Equal(segs[0].Duration, 2.0) // default sample durations
+	is.Equal(segs[1].Duration, 2.0) // several fragments
+	is.Equal(segs[2].Duration, 1.3) // explicit sample durations
+	is.Equal(p.TargetDuration, uint(2))
+	is.True(p.Closed) // VOD playlist must be closed
+
+	fsys["bad.m4s"] = &fstest.MapFile{Data: []byte{0, 0, 0, 100, 'm', 'o', 'o', 'f'}}
+	_, err = MediaPlaylistFromFMP4(fsys, "init.mp4", "bad.m4s")
+	is.True(errors.Is(err, ErrInvalidMP4)) // invalid box size must fail
+}
+
+func TestMediaPlaylistFromFMP4File(t *testing.T) {
+	is := is.New(t)
+	init := mp4TestInit()
+	frag0 := mp4TestFragment(50)
+	frag1 := mp4TestFragment(25)
+	sidxPayload := u32s(0, 1, 1000, 0, 0, 2)
+	sidxPayload = append(sidxPayload, u32s(uint32(len(frag0)), 2000, 0x90000000)...)
+	sidxPayload = append(sidxPayload, u32s(uint32(len(frag1)), 1000, 0x90000000)...)
+	sidx := mp4TestBox("sidx", sidxPayload)
+	data := append(append(append(append([]byte{}, init...), sidx...), frag0...), frag1...)
+
+	p, err := MediaPlaylistFromFMP4File(fstest.MapFS{"video.mp4": &fstest.MapFile{Data: data}}, "video.mp4")
+	is.NoErr(err)                                                    // generate playlist
+	is.Equal(*p.Map, Map{URI: "video.mp4", Limit: int64(len(init))}) // map covers init part
+	segs := p.GetAllSegments()
+	is.Equal(len(segs), 2)
+	is.Equal(segs[0].Offset, int64(len(init)+len(sidx))) // first subsegment after sidx
+	is.Equal(segs[0].Limit, int64(len(frag0)))
+	is.Equal(segs[0].Duration, 2.0)
+	is.Equal(segs[1].Offset, int64(len(init)+len(sidx)+len(frag0)))
+	is.Equal(segs[1].Duration, 1.0)
+	is.Equal(p.Version(), uint8(6)) // EXT-X-MAP with byte ranges
+}
+
+func TestMediaPlaylistFromFMP4SampleCount(t *testing.T) {
+	is := is.New(t)
+	fsys := fstest.MapFS{
+		"init.mp4": &fstest.MapFile{Data: mp4TestInit()},
+		"seg0.m4s": &fstest.MapFile{Data: mp4TestFragment(0xffffffff)},
+		"bad.m4s": &fstest.MapFile{Data: mp4TestBox("moof",
+			mp4TestBox("traf",
+				mp4TestBox("tfhd", u32s(0x020000, 1)),
+				mp4TestBox("trun", u32s(0x000100, 1000, 40)))),
+		},
+	}
+	p, err := MediaPlaylistFromFMP4(fsys, "init.mp4", "seg0.m4s")
+	is.NoErr(err)                                                      // samples without fields are not iterated
+	is.Equal(p.GetAllSegments()[0].Duration, float64(0xffffffff)*0.04) // default durations of all samples
+
+	_, err = MediaPlaylistFromFMP4(fsys, "init.mp4", "bad.m4s")
+	is.True(errors.Is(err, ErrInvalidMP4)) // sample count beyond the trun box must fail
+}
@@ -0,0 +1,324 @@
+package m3u8
+
+/*
+ This file defines a builder for I-frame playlists from MPEG-TS and fragmented MP4 segments.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+var ErrNoIFrames = errors.New("no I-frames found")
+
+// IFrame is the byte range and presentation time of a keyframe in a media segment.
+type IFrame struct {
+	URI    string  // URI of the media segment
+	Offset int64   // Offset of the byte range in the media segment
+	Size   int64   // Size of the byte range
+	Time   float64 // Time is the presentation time in seconds on the media timeline
+}
+
+// IFramePlaylistBuilder collects the keyframes of media segments and builds an
+// EXT-X-I-FRAMES-ONLY playlist with one byte-range segment per keyframe.
+// Segments must be added in playlist order, either all MPEG-TS or all fMP4.
+//
+// For MPEG-TS, keyframes are H.264 IDR or HEVC IRAP access units of the video stream
+// announced in the PMT, or PES packets with the random access indicator for other codecs.
+// The byte range covers the TS packets from the start of the PES packet to its last packet.
+// For fMP4, every fragment starting with a sync sample gives a keyframe, and the byte range
+// covers the moof box and the data of the first sample.
+type IFramePlaylistBuilder struct {
+	frames  []IFrame
+	end     float64   // end time of the last added segment in seconds
+	lastPTS int64     // last unwrapped PTS of MPEG-TS segments
+	hasPTS  bool      // lastPTS is set
+	track   *mp4Track // track of the fMP4 init segment
+	initURI string    // URI of the fMP4 init segment
+	initLen int64     // size of the fMP4 init segment
+}
+
+// NewIFramePlaylistBuilder returns an empty I-frame playlist builder.
+func NewIFramePlaylistBuilder() *IFramePlaylistBuilder {
+	return &IFramePlaylistBuilder{}
+}
+
+// SetFMP4Init sets the init segment of fMP4 media segments. It is used as EXT-X-MAP
+// of the I-frame playlist and provides the timescale of the first track.
+func (b *IFramePlaylistBuilder) SetFMP4Init(uri string, data []byte) error {
+	track, err := parseMP4Init(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", uri, err)
+	}
+	b.track, b.initURI, b.initLen = &track, uri, int64(len(data))
+	return nil
+}
+
+// AddTSSegment adds the keyframes of an MPEG-TS media segment.
+func (b *IFramePlaylistBuilder) AddTSSegment(uri string, data []byte) error {
+	keyframes, err := scanTSKeyframes(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", uri, err)
+	}
+	timing, err := scanTSTiming(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", uri, err)
+	}
+	for _, kf := range keyframes {
+		b.frames = append(b.frames, IFrame{URI: uri, Offset: kf.offset, Size: kf.size, Time: b.ptsTime(kf.pts)})
+	}
+	start := b.ptsTime(timing.first)
+	b.end = start + float64(timing.last-timing.first+timing.frameDur)/tsTimescale
+	return nil
+}
+
+// ptsTime unwraps a PTS value relative to the previous one and returns it in seconds.
+func (b *IFramePlaylistBuilder) ptsTime(pts int64) float64 {
+	if b.hasPTS {
+		pts = b.lastPTS + ptsDiff(pts, b.lastPTS)
+	}
+	b.lastPTS, b.hasPTS = pts, true
+	return float64(pts) / tsTimescale
+}
+
+// AddFMP4Segment adds the keyframes of an fMP4 media segment. SetFMP4Init must be called first.
+func (b *IFramePlaylistBuilder) AddFMP4Segment(uri string, data []byte) error {
+	if b.track == nil {
+		return fmt.Errorf("%s: %w: no init segment", uri, ErrInvalidMP4)
+	}
+	fragments, err := parseMP4Fragments(data, *b.track)
+	if err != nil {
+		return fmt.Errorf("%s: %w", uri, err)
+	}
+	timescale := float64(b.track.timescale)
+	for _, f := range fragments {
+		// The presentation time of a keyframe is its decode time from tfdt plus its
+		// composition offset from trun. Edit lists of the init segment are not applied.
+		start := float64(int64(f.baseTime)+f.firstCTO) / timescale
+		if f.isSync() {
+			end := f.dataOffset + int64(f.firstSize)
+			b.frames = append(b.frames, IFrame{
+				URI:    uri,
+				Offset: int64(f.moofOffset),
+				Size:   end - int64(f.moofOffset),
+				Time:   start,
+			})
+		}
+		b.end = start + float64(f.duration)/timescale
+	}
+	return nil
+}
+
+// IFrames returns the keyframes added so far.
+func (b *IFramePlaylistBuilder) IFrames() []IFrame {
+	return b.frames
+}
+
+// Playlist returns a closed VOD I-frame playlist. The duration of every I-frame segment
+// lasts until the next keyframe, and the last one until the end of the last media segment.
+func (b *IFramePlaylistBuilder) Playlist() (*MediaPlaylist, error) {
+	if len(b.frames) == 0 {
+		return nil, ErrNoIFrames
+	}
+	p, err := NewMediaPlaylist(0, uint(len(b.frames)))
+	if err != nil {
+		return nil, err
+	}
+	p.SetIframeOnly()
+	if b.initURI != "" {
+		p.Map = &Map{URI: b.initURI}
+	}
+	for i, f := range b.frames {
+		end := b.end
+		if i+1 < len(b.frames) {
+			end = b.frames[i+1].Time
+		}
+		seg := &MediaSegment{
+			URI:      f.URI,
+			Duration: math.Max(end-f.Time, 0),
+			Limit:    f.Size,
+			Offset:   f.Offset,
+		}
+		if err := p.AppendSegment(seg); err != nil {
+			return nil, err
+		}
+	}
+	finishVOD(p)
+	return p, nil
+}
+
+// Variant returns an EXT-X-I-FRAME-STREAM-INF variant with the I-frame playlist as chunklist.
+// BANDWIDTH and AVERAGE-BANDWIDTH are calculated from the I-frame byte ranges and the size
+// of the fMP4 init segment, and the other parameters are taken from params.
+func (b *IFramePlaylistBuilder) Variant(uri string, params VariantParams) (*Variant, error) {
+	p, err := b.Playlist()
+	if err != nil {
+		return nil, err
+	}
+	params.Iframe = true
+	sizes := SizeProviderFunc(func(uri string) (int64, error) {
+		if b.initURI != "" && uri == b.initURI {
+			return b.initLen, nil
+		}
+		return ByteRangeSizes().Size(uri)
+	})
+	if params.Bandwidth, params.AverageBandwidth, err = p.Bandwidth(sizes); err != nil {
+		return nil, err
+	}
+	return &Variant{URI: uri, Chunklist: p, VariantParams: params}, nil
+}
+
+// tsKeyframe is the byte range and PTS of a keyframe PES packet.
+type tsKeyframe struct {
+	offset int64
+	size   int64
+	pts    int64
+}
+
+// tsPES collects a video PES packet while scanning MPEG-TS data.
+type tsPES struct {
+	start, end int64
+	pts        int64
+	hasPTS     bool
+	random     bool   // random access indicator set
+	data       []byte // elementary stream data
+}
+
+// maxKeyframeScan limits the elementary stream data searched for keyframe NAL units.
+const maxKeyframeScan = 64 * 1024
+
+// scanTSKeyframes returns the keyframes of the video stream in MPEG-TS data.
+func scanTSKeyframes(data []byte) ([]tsKeyframe, error) {
+	if len(data) == 0 || len(data)%tsPacketSize != 0 {
+		return nil, fmt.Errorf("%w: size %d is not a multiple of %d", ErrInvalidTS, len(data), tsPacketSize)
+	}
+	var (
+		keyframes  []tsKeyframe
+		pmtPID     = -1
+		videoPID   = -1
+		streamType byte
+		cur        *tsPES
+	)
+	finish := func() {
+		if cur != nil && cur.hasPTS && isTSKeyframe(streamType, cur) {
+			keyframes = append(keyframes, tsKeyframe{offset: cur.start, size: cur.end - cur.start, pts: cur.pts})
+		}
+		cur = nil
+	}
+	for offset := 0; offset < len(data); offset += tsPacketSize {
+		pkt := data[offset : offset+tsPacketSize]
+		if pkt[0] != 0x47 {
+			return nil, fmt.Errorf("%w: no sync byte at offset %d", ErrInvalidTS, offset)
+		}
+		pusi := pkt[1]&0x40 != 0
+		pid := int(pkt[1]&0x1f)<<8 | int(pkt[2])
+		payload, random := 4, false
+		switch (pkt[3] >> 4) & 0x3 {
+		case 0, 2: // no payload
+			continue
+		case 3: // adaptation field and payload
+			random = pkt[4] > 0 && pkt[5]&0x40 != 0
+			payload += 1 + int(pkt[4])
+		}
+		if payload >= tsPacketSize {
+			continue
+		}
+		b := pkt[payload:]
+		switch {
+		case pid == 0 && pusi:
+			if p, ok := parsePAT(b); ok {
+				pmtPID = p
+			}
+		case pid == pmtPID && pusi:
+			if p, st, ok := parsePMTVideo(b); ok {
+				videoPID, streamType = p, st
+			}
+		case pusi && len(b) >= 9 && b[0] == 0 && b[1] == 0 && b[2] == 1 && b[3]&0xf0 == 0xe0 &&
+			(pid == videoPID || videoPID < 0):
+			finish()
+			videoPID = pid
+			_, pts, hasPTS := parsePESPTS(b)
+			cur = &tsPES{start: int64(offset), end: int64(offset + tsPacketSize), pts: pts, hasPTS: hasPTS, random: random}
+			if esStart := 9 + int(b[8]); esStart < len(b) {
+				cur.data = append(cur.data, b[esStart:]...)
+			}
+		case pid == videoPID && cur != nil:
+			cur.end = int64(offset + tsPacketSize)
+			if len(cur.data) < maxKeyframeScan {
+				cur.data = append(cur.data, b...)
+			}
+		}
+	}
+	finish()
+	return keyframes, nil
+}
+
+// parsePAT returns the PMT PID of the first program in a PAT section.
+func parsePAT(b []byte) (int, bool) {
+	if len(b) < 1 || len(b) < 1+int(b[0])+12 {
+		return 0, false
+	}
+	b = b[1+int(b[0]):] // pointer field
+	sectionLen := int(b[1]&0x0f)<<8 | int(b[2])
+	if b[0] != 0 || len(b) < 3+sectionLen {
+		return 0, false
+	}
+	for pos := 8; pos+4 <= 3+sectionLen-4; pos += 4 {
+		if program := int(b[pos])<<8 | int(b[pos+1]); program != 0 {
+			return int(b[pos+2]&0x1f)<<8 | int(b[pos+3]), true
+		}
+	}
+	return 0, false
+}
+
+// parsePMTVideo returns the PID and stream type of the first video stream in a PMT section.
+func parsePMTVideo(b []byte) (pid int, streamType byte, ok bool) {
+	if len(b) < 1 || len(b) < 1+int(b[0])+12 {
+		return 0, 0, false
+	}
+	b = b[1+int(b[0]):] // pointer field
+	sectionLen := int(b[1]&0x0f)<<8 | int(b[2])
+	if b[0] != 2 || len(b) < 3+sectionLen || sectionLen < 13 {
+		return 0, 0, false
+	}
+	end := 3 + sectionLen - 4 // without CRC
+	for pos := 12 + (int(b[10]&0x0f)<<8 | int(b[11])); pos+5 <= end; {
+		st := b[pos]
+		esPID := int(b[pos+1]&0x1f)<<8 | int(b[pos+2])
+		switch st {
+		case 0x01, 0x02, 0x10, 0x1b, 0x24: // MPEG-1, MPEG-2, MPEG-4 part 2, H.264, HEVC
+			return esPID, st, true
+		}
+		pos += 5 + (int(b[pos+3]&0x0f)<<8 | int(b[pos+4]))
+	}
+	return 0, 0, false
+}
+
+// isTSKeyframe reports whether a video PES packet contains a keyframe.
+func isTSKeyframe(streamType byte, pes *tsPES) bool {
+	switch streamType {
+	case 0x1b: // H.264
+		return hasNALUnit(pes.data, func(header byte) bool { return header&0x1f == 5 })
+	case 0x24: // HEVC
+		return hasNALUnit(pes.data, func(header byte) bool {
+			t := (header >> 1) & 0x3f
+			return t >= 16 && t <= 21
+		})
+	default:
+		return pes.random
+	}
+}
+
+// hasNALUnit reports whether the Annex B byte stream contains a NAL unit whose first header byte matches.
+func hasNALUnit(b []byte, match func(header byte) bool) bool {
+	for i := 0; i+3 < len(b); i++ {
+		if b[i] == 0 && b[i+1] == 0 && b[i+2] == 1 {
+			if match(b[i+3]) {
+				return true
+			}
+			i += 2
+		}
+	}
+	return false
+}
@@ -0,0 +1,165 @@
+package m3u8
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+// tsPacket returns a TS packet with payload padded by an adaptation field.
+func tsPacket(pid uint16, pusi bool, payload []byte) []byte {
+	pkt := []byte{0x47, byte(pid>>8) & 0x1f, byte(pid), 0x30}
+	if pusi {
+		pkt[1] |= 0x40
+	}
+	stuffing := tsPacketSize - 4 - len(payload) - 1
+	pkt = append(pkt, byte(stuffing))
+	if stuffing > 0 {
+		pkt = append(pkt, 0x00)
+		for i := 1; i < stuffing; i++ {
+			pkt = append(pkt, 0xff)
+		}
+	}
+	return append(pkt, payload...)
+}
+
+// tsIFrameSegment returns the segment of tsSegment with a PAT and PMT announcing H.264 video
+// on PID 0x101. Every video PES packet gets an IDR slice every second and a non-IDR slice
+// otherwise, and is continued by a second TS packet after the next audio packet.
+func tsIFrameSegment(pts int64) []byte {
+	pat := []byte{0, 0x00, 0xb0, 13, 0, 1, 0xc1, 0, 0, 0, 1, 0xf0, 0x00, 0, 0, 0, 0}
+	pmt := []byte{0, 0x02, 0xb0, 23, 0, 1, 0xc1, 0, 0, 0xe1, 0x01, 0xf0, 0,
+		0x1b, 0xe1, 0x01, 0xf0, 0, 0x0f, 0xe1, 0x02, 0xf0, 0, 0, 0, 0, 0}
+	data := append(tsPacket(0, true, pat), tsPacket(0x1000, true, pmt)...)
+	src := tsSegment(pts)
+	frame, pending := 0, false
+	for offset := 0; offset < len(src); offset += tsPacketSize {
+		pkt := src[offset : offset+tsPacketSize]
+		if pid := int(pkt[1]&0x1f)<<8 | int(pkt[2]); pid == 0x101 {
+			if pending {
+				data = append(data, tsPacket(0x101, false, make([]byte, 100))...)
+			}
+			nal := byte(0x41) // non-IDR slice
+			if frame%25 == 0 {
+				nal = 0x65 // IDR slice
+			}
+			copy(pkt[18:], []byte{0, 0, 0, 1, 0x09, 0xf0, 0, 0, 0, 1, nal, 0x88}) // after the PES header
+			frame, pending = frame+1, true
+		}
+		data = append(data, pkt...)
+	}
+	return append(data, tsPacket(0x101, false, make([]byte, 100))...)
+}
+
+func TestIFramePlaylistFromTS(t *testing.T) {
+	is := is.New(t)
+	b := NewIFramePlaylistBuilder()
+	is.NoErr(b.AddTSSegment("seg0.ts", tsIFrameSegment(ptsWrap-90000))) // segment crossing PTS wrap
+	is.NoErr(b.AddTSSegment("seg1.ts", tsIFrameSegment(90000)))
+
+	frames := b.IFrames()
+	is.Equal(len(frames), 4) // two IDR frames per segment
+	is.Equal(frames[0], IFrame{URI: "seg0.ts", Offset: 2 * tsPacketSize, Size: 3 * tsPacketSize, Time: frames[0].Time})
+	is.Equal(frames[1].Offset, int64((2+25*2+3)*tsPacketSize)) // second IDR frame after 25 frames and 3 audio packets
+	is.Equal(frames[1].Size, int64(2*tsPacketSize))            // no audio packet in between
+	is.Equal(frames[2].URI, "seg1.ts")
+
+	v, err := b.Variant("iframes.m3u8", VariantParams{Codecs: "avc1.64001f"})
+	is.NoErr(err) // build variant
+	p := v.Chunklist
+	is.True(p.Iframe) // I-frame only playlist
+	for i, seg := range p.GetAllSegments() {
+		is.Equal(seg.Duration, 1.0)         // time until next IDR frame
+		is.Equal(seg.Limit, frames[i].Size) // byte range of the IDR frame
+	}
+	is.Equal(v.Iframe, true)
+	is.Equal(v.Codecs, "avc1.64001f")
+	is.Equal(v.Bandwidth, uint32(3*tsPacketSize*8)) // peak bit rate
+	is.Equal(v.AverageBandwidth, uint32(5*tsPacketSize*8/2))
+	is.Equal(p.Version(), uint8(4)) // EXT-X-I-FRAMES-ONLY and EXT-X-BYTERANGE
+}
+
+func TestIFramePlaylistFromFMP4(t *testing.T) {
+	is := is.New(t)
+	b := NewIFramePlaylistBuilder()
+	err := b.AddFMP4Segment("seg0.m4s", mp4TestFragment(50))
+	is.True(errors.Is(err, ErrInvalidMP4)) // init segment required
+	is.NoErr(b.SetFMP4Init("init.mp4", mp4TestInit()))
+
+	// Two fragments per segment, where the second one does not start with a sync sample
+	nonSync := mp4TestBox("moof",
+		mp4TestBox("traf",
+			mp4TestBox("tfhd", u32s(0x020020, 1, 0x00010000)),
+			mp4TestBox("tfdt", u32s(0, 1000)),
+			mp4TestBox("trun", u32s(0x000201, 25, 100), make([]byte, 4*25))))
+	seg0 := append(mp4TestFragment(25), nonSync...)
+	is.NoErr(b.AddFMP4Segment("seg0.m4s", seg0))
+	is.Equal(len(b.IFrames()), 1) // non-sync fragment must be skipped
+
+	syncFrag := mp4TestBox("moof",
+		mp4TestBox("traf",
+			mp4TestBox("tfhd", u32s(0x020000, 1)),
+			mp4TestBox("tfdt", u32s(0, 2000)),
+			mp4TestBox("trun", u32s(0x000201, 50, 200), u32s(1000), make([]byte, 4*49))))
+	is.NoErr(b.AddFMP4Segment("seg1.m4s", syncFrag))
+
+	p, err := b.Playlist()
+	is.NoErr(err) // build playlist
+	is.Equal(p.Map.URI, "init.mp4")
+	segs := p.GetAllSegments()
+	is.Equal(len(segs), 2)
+	is.Equal(segs[0].Duration, 2.0) // until next sync fragment
+	is.Equal(segs[0].Offset, int64(0))
+	is.Equal(segs[1].Duration, 2.0)          // until end of last segment
+	is.Equal(segs[1].Limit, int64(200+1000)) // moof and first sample
+}
+
+func TestIFrameTimeFromFMP4CompositionOffset(t *testing.T) {
+	is := is.New(t)
+	b := NewIFramePlaylistBuilder()
+	is.NoErr(b.SetFMP4Init("init.mp4", mp4TestInit()))
+	frag := func(version byte, baseTime, cto uint32) []byte {
+		trun := append(u32s(uint32(version)<<24|0x000a01, 2, 200), u32s(1000, cto, 1000, 0)...)
+		return mp4TestBox("moof",
+			mp4TestBox("traf",
+				mp4TestBox("tfhd", u32s(0x020000, 1)),
+				mp4TestBox("tfdt", u32s(0, baseTime)),
+				mp4TestBox("trun", trun)))
+	}
+	is.NoErr(b.AddFMP4Segment("seg0.m4s", frag(0, 0, 80)))
+	is.NoErr(b.AddFMP4Segment("seg1.m4s", frag(1, 1000, 0xffffffb0))) // signed offset of -80
+
+	frames := b.IFrames()
+	is.Equal(frames[0].Time, 0.08) // decode time plus composition offset
+	is.Equal(frames[1].Time, 0.92) // negative offset in trun version 1
+}
+
+func TestIFrameVariantFromFMP4(t *testing.T) {
+	is := is.New(t)
+	b := NewIFramePlaylistBuilder()
+	is.NoErr(b.SetFMP4Init("init.mp4", mp4TestInit()))
+	frag := func(baseTime uint32) []byte {
+		return mp4TestBox("moof",
+			mp4TestBox("traf",
+				mp4TestBox("tfhd", u32s(0x020000, 1)),
+				mp4TestBox("tfdt", u32s(0, baseTime)),
+				mp4TestBox("trun", u32s(0x000201, 50, 200), u32s(1000), make([]byte, 4*49))))
+	}
+	is.NoErr(b.AddFMP4Segment("seg0.m4s", frag(0)))
+	is.NoErr(b.AddFMP4Segment("seg1.m4s", frag(2000)))
+
+	v, err := b.Variant("iframes.m3u8", VariantParams{})
+	is.NoErr(err) // init segment without byte range
+	is.Equal(v.Chunklist.Map.Limit, int64(0))
+	initBits := uint32(len(mp4TestInit()) * 8)
+	frameBits := uint32((200 + 1000) * 8)
+	is.Equal(v.Bandwidth, (initBits+frameBits)/2)          // init segment added to first segment
+	is.Equal(v.AverageBandwidth, (initBits+2*frameBits)/4) // total size over total duration
+}
+
+func TestIFramePlaylistEmpty(t *testing.T) {
+	is := is.New(t)
+	_, err := NewIFramePlaylistBuilder().Playlist()
+	is.True(errors.Is(err, ErrNoIFrames)) // no I-frames must fail
+}
@@ -0,0 +1,199 @@
+package m3u8
+
+/*
+ This file defines automatic key rotation for live media playlists.
+*/
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidKeyRotation = errors.New("invalid key rotation")
+
+// KeyRotationIV selects the IV attribute of keys added by KeyRotation.
+type KeyRotationIV uint
+
+const (
+	// IVImplicit omits the IV attribute, so the media sequence number of each segment is used.
+	IVImplicit KeyRotationIV = iota
+	// IVSequence sets the IV attribute to the media sequence number of the first segment of the key.
+	IVSequence
+	// IVRandom sets the IV attribute to 16 random bytes.
+	IVRandom
+)
+
+// KeyRotation adds a new EXT-X-KEY to segments appended to a live media playlist every
+// Segments segments or every Period seconds of media, whichever comes first.
+// The first appended segment always gets a key. Segments that already have keys when
+// appended are not changed, but restart the rotation period, and their key with the METHOD
+// and KEYFORMAT of the rotation becomes the current key, or none if they have no such key.
+type KeyRotation struct {
+	Method string // Method is the METHOD, e.g. AES-128 or SAMPLE-AES
+	// URITemplate is the URI of the keys, where "{index}" is replaced by the number of the
+	// key starting at 0.
+	URITemplate       string
+	Segments          uint          // Segments is the number of segments per key. 0 disables rotation by segments
+	Period            float64       // Period is the media duration in seconds per key. 0 disables rotation by time
+	IV                KeyRotationIV // IV selects the IV attribute
+	Keyformat         string        // Keyformat is the KEYFORMAT attribute
+	Keyformatversions string        // Keyformatversions is the KEYFORMATVERSIONS attribute
+	Rand              io.Reader     // Rand is the source of random IVs. Defaults to crypto/rand.Reader
+
+	index    uint64   // index is the number of the next key
+	started  bool     // started is set once the first key has been added
+	segments uint     // segments is the number of segments since the last key
+	elapsed  float64  // elapsed is the media duration since the last key
+	current  *Key     // current is the key of the last segment with keys
+	ivs      [][]byte // ivs are the random IVs generated for upcoming keys
+}
+
+// SetKeyRotation attaches a key rotation policy that adds keys to appended segments.
+// Pass nil to stop rotating keys.
+func (p *MediaPlaylist) SetKeyRotation(r *KeyRotation) error {
+	if r != nil {
+		if r.Method == "" || r.Method == "NONE" || r.URITemplate == "" {
+			return fmt.Errorf("%w: METHOD and URI template required", ErrInvalidKeyRotation)
+		}
+		if r.Segments == 0 && r.Period <= 0 {
+			return fmt.Errorf("%w: Segments or Period required", ErrInvalidKeyRotation)
+		}
+	}
+	p.keyRotation = r
+	return nil
+}
+
+// KeyRotation returns the key rotation policy of the playlist, or nil if there is none.
+func (p *MediaPlaylist) KeyRotation() *KeyRotation {
+	return p.keyRotation
+}
+
+// rotateKey adds a key to seg if the key rotation policy requires one.
+func (p *MediaPlaylist) rotateKey(seg *MediaSegment) error {
+	r := p.keyRotation
+	if r == nil {
+		return nil
+	}
+	if len(seg.Keys) == 0 && r.due() {
+		key, err := r.next(seg.SeqId)
+		if err != nil {
+			return err
+		}
+		seg.Keys = []Key{key}
+		if key.Keyformat != "" || key.Keyformatversions != "" {
+			updateVersion(&p.ver, 5) // [Protocol Version Compatibility]
+		}
+	} else if len(seg.Keys) > 0 {
+		r.current = nil
+		for i := range seg.Keys {
+			if seg.Keys[i].Method == r.Method && seg.Keys[i].Keyformat == r.Keyformat {
+				key := seg.Keys[i]
+				r.current = &key
+				break
+			}
+		}
+	}
+	if len(seg.Keys) > 0 {
+		r.started, r.segments, r.elapsed = true, 0, 0
+	}
+	r.segments++
+	r.elapsed += seg.Duration
+	return nil
+}
+
+// due reports whether the next segment needs a new key.
+func (r *KeyRotation) due() bool {
+	return !r.started || (r.Segments > 0 && r.segments >= r.Segments) || (r.Period > 0 && r.elapsed >= r.Period)
+}
+
+// next returns the next key for a first segment with media sequence number seqNo.
+func (r *KeyRotation) next(seqNo uint64) (Key, error) {
+	key, err := r.key(0, seqNo)
+	if err != nil {
+		return key, err
+	}
+	if len(r.ivs) > 0 {
+		r.ivs = r.ivs[1:]
+	}
+	r.index++
+	r.current = &key
+	return key, nil
+}
+
+// key returns the key i keys after the next one. The media sequence number is only
+// known for the next key.
+func (r *KeyRotation) key(i int, seqNo uint64) (Key, error) {
+	index := r.index + uint64(i)
+	uri := strings.ReplaceAll(r.URITemplate, "{index}", strconv.FormatUint(index, 10))
+	key := Key{Method: r.Method, URI: uri, Keyformat: r.Keyformat, Keyformatversions: r.Keyformatversions}
+	switch r.IV {
+	case IVSequence:
+		if i == 0 {
+			iv, _ := keyIV(Key{}, seqNo)
+			key.IV = "0x" + strings.ToUpper(hex.EncodeToString(iv))
+		}
+	case IVRandom:
+		for len(r.ivs) <= i {
+			iv := make([]byte, 16)
+			rnd := r.Rand
+			if rnd == nil {
+				rnd = rand.Reader
+			}
+			if _, err := io.ReadFull(rnd, iv); err != nil {
+				return key, fmt.Errorf("generate IV: %w", err)
+			}
+			r.ivs = append(r.ivs, iv)
+		}
+		key.IV = "0x" + strings.ToUpper(hex.EncodeToString(r.ivs[i]))
+	}
+	return key, nil
+}
+
+// UpcomingKeys returns the current key followed by the next keys, n keys in total.
+// The random IVs of the next keys are generated in advance and used when the keys are added.
+// The next keys have no IV with IVSequence, since the media sequence number of their first
+// segment is not known yet.
+func (r *KeyRotation) UpcomingKeys(n int) ([]Key, error) {
+	var keys []Key
+	if r.current != nil && n > 0 {
+		keys = append(keys, *r.current)
+	}
+	for i := 0; len(keys) < n; i++ {
+		key, err := r.key(i, 0)
+		if err != nil {
+			return nil, err
+		}
+		if r.IV == IVSequence {
+			key.IV = ""
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// UpdateSessionKeys replaces the EXT-X-SESSION-KEY tags of the master playlist with the METHOD
+// and KEYFORMAT of the rotation by the n keys of UpcomingKeys, so that clients can prefetch them.
+// Other session keys are kept.
+func (r *KeyRotation) UpdateSessionKeys(master *MasterPlaylist, n int) error {
+	keys, err := r.UpcomingKeys(n)
+	if err != nil {
+		return err
+	}
+	var sessionKeys []*Key
+	for _, key := range master.SessionKeys {
+		if key.Method != r.Method || key.Keyformat != r.Keyformat {
+			sessionKeys = append(sessionKeys, key)
+		}
+	}
+	for i := range keys {
+		sessionKeys = append(sessionKeys, &keys[i])
+	}
+	master.SessionKeys = sessionKeys
+	master.ResetCache()
+	return nil
+}
@@ -0,0 +1,93 @@
+package m3u8
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestKeyRotationBySegments(t *testing.T) {
+	is := is.New(t)
+	p, err := NewMediaPlaylist(3, 10)
+	is.NoErr(err)
+	p.SeqNo = 10
+	is.NoErr(p.SetKeyRotation(&KeyRotation{Method: "AES-128", URITemplate: "https://keys.example.com/k{index}.bin", Segments: 2, IV: IVSequence}))
+	for i := 0; i < 6; i++ {
+		p.Slide(fmt.Sprintf("seg%d.ts", i), 4, "")
+	}
+	var keyed []string
+	for _, seg := range p.GetAllSegments() {
+		if len(seg.Keys) > 0 {
+			keyed = append(keyed, seg.URI+" "+seg.Keys[0].URI+" "+seg.Keys[0].IV)
+		}
+	}
+	is.Equal(keyed, []string{"seg4.ts https://keys.example.com/k2.bin 0x0000000000000000000000000000000E"}) // third key on fifth segment
+	is.Equal(p.Keys[0].URI, "https://keys.example.com/k1.bin")                                              // key of removed segment kept
+
+	s := p.String()
+	is.True(strings.Contains(s, "#EXT-X-KEY:METHOD=AES-128,URI=\"https://keys.example.com/k1.bin\",IV=0x0000000000000000000000000000000C\n#EXT-X-MEDIA-SEQUENCE:13")) // header key
+	for _, seg := range p.GetAllSegments() {
+		iv, err := p.IVFor(seg)
+		is.NoErr(err) // every segment encrypted
+		is.Equal(len(iv), 16)
+	}
+
+	seg := &MediaSegment{URI: "seg6.ts", Duration: 4, Keys: []Key{{Method: "NONE"}}}
+	is.NoErr(p.AppendSegment(seg)) // explicit keys are kept
+	is.Equal(seg.Keys[0].Method, "NONE")
+	keys, err := p.KeyRotation().UpcomingKeys(1)
+	is.NoErr(err)
+	is.Equal(keys[0].URI, "https://keys.example.com/k3.bin") // no current key after METHOD=NONE
+
+	own := Key{Method: "AES-128", URI: "https://keys.example.com/own.bin"}
+	is.NoErr(p.AppendSegment(&MediaSegment{URI: "seg7.ts", Duration: 4, Keys: []Key{own}}))
+	keys, err = p.KeyRotation().UpcomingKeys(2)
+	is.NoErr(err)
+	is.Equal(keys[0], own)                                   // key of the segment is current
+	is.Equal(keys[1].URI, "https://keys.example.com/k3.bin") // next rotated key
+}
+
+func TestKeyRotationByPeriod(t *testing.T) {
+	is := is.New(t)
+	p, err := NewMediaPlaylist(0, 10)
+	is.NoErr(err)
+	r := &KeyRotation{
+		Method:            "SAMPLE-AES",
+		URITemplate:       "skd://key{index}",
+		Period:            10,
+		IV:                IVRandom,
+		Keyformat:         "com.apple.streamingkeydelivery",
+		Keyformatversions: "1",
+		Rand:              bytes.NewReader(bytes.Repeat([]byte{0xab}, 64)),
+	}
+	is.NoErr(p.SetKeyRotation(r))
+	is.NoErr(p.Append("seg0.ts", 6, ""))
+
+	m := NewMasterPlaylist()
+	m.SessionKeys = []*Key{{Method: "AES-128", URI: "other.bin"}}
+	is.NoErr(r.UpdateSessionKeys(m, 2)) // current and next key as session keys
+	is.Equal(len(m.SessionKeys), 3)
+	is.Equal(m.SessionKeys[1].URI, "skd://key0")
+	next := *m.SessionKeys[2]
+	is.Equal(next.URI, "skd://key1")
+
+	for i := 1; i < 4; i++ {
+		is.NoErr(p.Append(fmt.Sprintf("seg%d.ts", i), 6, ""))
+	}
+	segs := p.GetAllSegments()
+	is.Equal(len(segs[1].Keys), 0)
+	is.Equal(segs[2].Keys[0], next) // announced key used with the same IV after 12s
+	is.Equal(len(segs[3].Keys), 0)
+	is.Equal(p.Version(), uint8(5)) // KEYFORMAT requires version 5
+
+	r.Rand = bytes.NewReader(nil)
+	err = p.Append("seg4.ts", 6, "")
+	is.True(err != nil)          // IV generation failure
+	is.Equal(p.Count(), uint(4)) // segment not appended
+
+	is.True(errors.Is(p.SetKeyRotation(&KeyRotation{Method: "AES-128", URITemplate: "k.bin"}), ErrInvalidKeyRotation))
+}
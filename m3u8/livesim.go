@@ -0,0 +1,270 @@
+package m3u8
+
+/*
+ This file defines a simulator that turns a VOD media playlist into a looping live stream.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+var ErrLiveSimNotStarted = errors.New("no segment available yet")
+
+// LiveSim turns a VOD media playlist into a live stream that loops the VOD content.
+// Like the DASH-IF livesim, the sliding window is calculated from the wall clock:
+// the first segment of the first loop starts at Start, and a segment is available
+// once it has ended.
+//
+// Every loop boundary is signalled by EXT-X-DISCONTINUITY, and every segment gets
+// an EXT-X-PROGRAM-DATE-TIME value corresponding to its availability.
+// If PartDuration > 0, partial segments and a preload hint are generated for
+// low-latency HLS.
+type LiveSim struct {
+	Start        time.Time        // Start is the availability start time of the first segment
+	WinDuration  float64          // WinDuration is the minimal duration in seconds of the sliding window
+	PartDuration float64          // PartDuration is the duration of generated partial segments. 0 disables parts
+	Now          func() time.Time // Now is the clock used by ServeHTTP. Defaults to time.Now
+	// SegmentURI provides the URI of a live segment given the VOD segment and
+	// the live sequence number. Defaults to the VOD URI, or to the VOD URI with
+	// "_<seqNo>" added before the extension if PartDuration > 0.
+	SegmentURI func(vodSeg *MediaSegment, seqNo uint64) string
+	// PartURI provides the URI of a partial segment. Defaults to the segment URI
+	// with ".<part>" added before the extension.
+	PartURI func(vodSeg *MediaSegment, seqNo uint64, part int) string
+
+	vod      *MediaPlaylist
+	segs     []*MediaSegment
+	starts   []float64 // start time of each VOD segment relative to the loop start
+	loopDur  float64   // duration of one loop
+	discs    []uint64  // number of discontinuities in the VOD before each segment
+	loopDisc uint64    // number of discontinuities within one loop
+	maps     []*Map    // effective EXT-X-MAP for each VOD segment
+	keys     [][]Key   // effective EXT-X-KEY tags for each VOD segment
+}
+
+// NewLiveSim creates a live simulator for a VOD playlist starting at start and
+// with a sliding window of at least winDuration seconds.
+func NewLiveSim(vod *MediaPlaylist, start time.Time, winDuration float64) (*LiveSim, error) {
+	segs := vod.GetAllSegments()
+	if len(segs) == 0 {
+		return nil, ErrPlaylistEmpty
+	}
+	l := &LiveSim{
+		Start:       start,
+		WinDuration: winDuration,
+		vod:         vod,
+		segs:        segs,
+		starts:      make([]float64, len(segs)),
+		discs:       make([]uint64, len(segs)),
+		maps:        make([]*Map, len(segs)),
+		keys:        make([][]Key, len(segs)),
+	}
+	curMap := vod.Map
+	curKeys := vod.Keys
+	for i, seg := range segs {
+		l.starts[i] = l.loopDur
+		l.loopDur += seg.Duration
+		l.discs[i] = l.loopDisc
+		if i > 0 && seg.Discontinuity {
+			l.loopDisc++
+		}
+		if seg.Map != nil {
+			curMap = seg.Map
+		}
+		if len(seg.Keys) != 0 {
+			curKeys = seg.Keys
+		}
+		l.maps[i] = curMap
+		l.keys[i] = curKeys
+	}
+	if l.loopDur <= 0 {
+		return nil, fmt.Errorf("total duration %f of VOD playlist must be > 0", l.loopDur)
+	}
+	return l, nil
+}
+
+// segmentTime returns the VOD index and the start time in seconds relative
+// to Start for the live segment with sequence number seqNo.
+func (l *LiveSim) segmentTime(seqNo uint64) (idx int, start float64) {
+	n := uint64(len(l.segs))
+	loop, idx := seqNo/n, int(seqNo%n)
+	return idx, float64(loop)*l.loopDur + l.starts[idx]
+}
+
+// discontinuitiesBefore returns the number of discontinuities before the live segment seqNo.
+func (l *LiveSim) discontinuitiesBefore(seqNo uint64) uint64 {
+	n := uint64(len(l.segs))
+	loop, idx := seqNo/n, seqNo%n
+	boundaries := loop // loop boundaries at segments n, 2n, ... before seqNo
+	if loop > 0 && idx == 0 {
+		boundaries--
+	}
+	return loop*l.loopDisc + l.discs[idx] + boundaries
+}
+
+// lastAvailable returns the sequence number of the last segment that has ended at elapsed seconds.
+func (l *LiveSim) lastAvailable(elapsed float64) (uint64, bool) {
+	if elapsed < 0 {
+		return 0, false
+	}
+	n := uint64(len(l.segs))
+	loop := uint64(math.Floor(elapsed / l.loopDur))
+	rest := elapsed - float64(loop)*l.loopDur
+	nrDone := uint64(0)
+	for i, seg := range l.segs {
+		if l.starts[i]+seg.Duration > rest {
+			break
+		}
+		nrDone++
+	}
+	if loop*n+nrDone == 0 {
+		return 0, false
+	}
+	return loop*n + nrDone - 1, true
+}
+
+func (l *LiveSim) segmentURI(vodSeg *MediaSegment, seqNo uint64) string {
+	if l.SegmentURI != nil {
+		return l.SegmentURI(vodSeg, seqNo)
+	}
+	if l.PartDuration <= 0 {
+		return vodSeg.URI
+	}
+	ext := path.Ext(vodSeg.URI)
+	return fmt.Sprintf("%s_%d%s", strings.TrimSuffix(vodSeg.URI, ext), seqNo, ext)
+}
+
+func (l *LiveSim) partURI(vodSeg *MediaSegment, seqNo uint64, part int) string {
+	if l.PartURI != nil {
+		return l.PartURI(vodSeg, seqNo, part)
+	}
+	segURI := l.segmentURI(vodSeg, seqNo)
+	ext := path.Ext(segURI)
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(segURI, ext), part, ext)
+}
+
+// appendParts appends the partial segments of live segment seqNo that have ended at elapsed seconds.
+// It returns the URI of the next part, or "" if all parts are available.
+func (l *LiveSim) appendParts(p *MediaPlaylist, seqNo uint64, elapsed float64) (string, error) {
+	idx, start := l.segmentTime(seqNo)
+	vodSeg := l.segs[idx]
+	for part := 0; ; part++ {
+		partStart := float64(part) * l.PartDuration
+		if partStart >= vodSeg.Duration-1e-9 {
+			return "", nil
+		}
+		dur := math.Min(l.PartDuration, vodSeg.Duration-partStart)
+		uri := l.partURI(vodSeg, seqNo, part)
+		if start+partStart+dur > elapsed {
+			return uri, nil
+		}
+		if err := p.AppendPartial(uri, dur, part == 0); err != nil {
+			return "", err
+		}
+	}
+}
+
+// Playlist returns the live media playlist at time now.
+// It is a pure function of now, so it can be used with a fake clock.
+func (l *LiveSim) Playlist(now time.Time) (*MediaPlaylist, error) {
+	elapsed := now.Sub(l.Start).Seconds()
+	last, ok := l.lastAvailable(elapsed)
+	if !ok {
+		return nil, ErrLiveSimNotStarted
+	}
+	first := last
+	total := l.segs[int(last%uint64(len(l.segs)))].Duration
+	for first > 0 && total < l.WinDuration {
+		first--
+		total += l.segs[int(first%uint64(len(l.segs)))].Duration
+	}
+	count := uint(last - first + 1)
+	p, err := NewMediaPlaylist(count, count)
+	if err != nil {
+		return nil, err
+	}
+	p.SetVersion(l.vod.Version())
+	p.SetTargetDuration(l.vod.TargetDuration)
+	p.SetIndependentSegments(l.vod.IndependentSegments())
+	p.SeqNo = first
+	p.SegmentIndexing.NextMSNIndex = first
+	p.DiscontinuitySeq = l.vod.DiscontinuitySeq + l.discontinuitiesBefore(first)
+	firstIdx, _ := l.segmentTime(first)
+	p.Map = l.maps[firstIdx]
+	p.Keys = l.keys[firstIdx]
+	if l.PartDuration > 0 {
+		p.PartTargetDuration = l.PartDuration
+		p.ServerControl = &ServerControl{PartHoldBack: 3 * l.PartDuration}
+	}
+
+	n := len(l.segs)
+	for seqNo := first; seqNo <= last; seqNo++ {
+		idx, start := l.segmentTime(seqNo)
+		seg := *l.segs[idx]
+		seg.URI = l.segmentURI(l.segs[idx], seqNo)
+		seg.ProgramDateTime = l.Start.Add(time.Duration(start * float64(time.Second)))
+		seg.Map = nil
+		seg.Keys = nil
+		switch {
+		case seqNo == first:
+			// Map and keys are signalled in the playlist header
+			if idx == 0 && seqNo > 0 {
+				seg.Discontinuity = true
+			}
+		case idx == 0:
+			seg.Discontinuity = true
+			seg.Map = l.maps[0]
+			if !slices.Equal(l.keys[0], l.keys[n-1]) {
+				seg.Keys = l.keys[0]
+				if len(seg.Keys) == 0 {
+					seg.Keys = []Key{{Method: "NONE"}}
+				}
+			}
+		default:
+			seg.Map = l.segs[idx].Map
+			seg.Keys = l.segs[idx].Keys
+		}
+		if err := p.AppendSegment(&seg); err != nil {
+			return nil, err
+		}
+		if l.PartDuration > 0 && seqNo+3 > last {
+			if _, err := l.appendParts(p, seqNo, elapsed); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if l.PartDuration > 0 {
+		nextURI, err := l.appendParts(p, last+1, elapsed)
+		if err != nil {
+			return nil, err
+		}
+		if nextURI != "" {
+			p.SetPreloadHint("PART", nextURI)
+		}
+	}
+	ver, _ := p.CalcMinVersion()
+	updateVersion(&p.ver, ver)
+	return p, nil
+}
+
+// ServeHTTP serves the live media playlist at the current time of the Now clock.
+func (l *LiveSim) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	now := time.Now
+	if l.Now != nil {
+		now = l.Now
+	}
+	p, err := l.Playlist(now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	_, _ = w.Write(p.Encode().Bytes())
+}
@@ -0,0 +1,101 @@
+package m3u8
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func newLiveSimTestVOD(t *testing.T) *MediaPlaylist {
+	t.Helper()
+	is := is.New(t)
+	vod, err := NewMediaPlaylist(0, 3)
+	is.NoErr(err) // create VOD playlist
+	vod.SetDefaultMap("init.mp4", 0, 0)
+	is.NoErr(vod.Append("seg0.m4s", 4, "")) // append segment
+	is.NoErr(vod.Append("seg1.m4s", 4, "")) // append segment
+	is.NoErr(vod.Append("seg2.m4s", 2, "")) // append segment
+	vod.Close()
+	return vod
+}
+
+func TestLiveSimPlaylist(t *testing.T) {
+	is := is.New(t)
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	ls, err := NewLiveSim(newLiveSimTestVOD(t), start, 10)
+	is.NoErr(err) // create live simulator
+
+	_, err = ls.Playlist(start.Add(3 * time.Second))
+	is.Equal(err, ErrLiveSimNotStarted) // no segment should be available
+	_, err = ls.Playlist(start.Add(-time.Hour))
+	is.Equal(err, ErrLiveSimNotStarted) // time before start
+
+	p, err := ls.Playlist(start.Add(25 * time.Second))
+	is.NoErr(err)                           // generate live playlist
+	is.Equal(p.SeqNo, uint64(4))            // SeqNo not 4
+	is.Equal(p.Count(), uint(3))            // window not 3 segments
+	is.Equal(p.DiscontinuitySeq, uint64(1)) // discontinuity sequence not 1
+	is.True(!p.Closed)                      // live playlist must not be closed
+	segs := p.GetAllSegments()
+	is.Equal(segs[0].URI, "seg1.m4s")                                  // first segment not seg1
+	is.Equal(segs[0].ProgramDateTime, start.Add(14*time.Second))       // wrong program date time
+	is.True(segs[2].Discontinuity)                                     // loop boundary not discontinuity
+	is.True(strings.Contains(p.String(), `#EXT-X-MAP:URI="init.mp4"`)) // map missing
+
+	// Same time gives same playlist, later time slides the window
+	p2, err := ls.Playlist(start.Add(25 * time.Second))
+	is.NoErr(err)                     // generate live playlist again
+	is.Equal(p.String(), p2.String()) // playlist not deterministic
+	p3, err := ls.Playlist(start.Add(31 * time.Second))
+	is.NoErr(err)                                 // generate later live playlist
+	is.Equal(p3.SeqNo, uint64(6))                 // SeqNo not 6
+	is.Equal(p3.DiscontinuitySeq, uint64(1))      // discontinuity in window must not be counted
+	is.True(p3.GetAllSegments()[0].Discontinuity) // first segment keeps its discontinuity
+}
+
+func TestLiveSimParts(t *testing.T) {
+	is := is.New(t)
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	ls, err := NewLiveSim(newLiveSimTestVOD(t), start, 10)
+	is.NoErr(err) // create live simulator
+	ls.PartDuration = 1
+	p, err := ls.Playlist(start.Add(25 * time.Second))
+	is.NoErr(err) // generate low-latency playlist
+	out := p.String()
+	is.True(strings.Contains(out, "#EXT-X-PART-INF:PART-TARGET=1.000\n"))                           // part target missing
+	is.True(strings.Contains(out, `#EXT-X-PART:DURATION=1.000,INDEPENDENT=YES,URI="seg0_6.0.m4s"`)) // part of last segment missing
+	is.True(strings.Contains(out, `#EXT-X-PART:DURATION=1.000,INDEPENDENT=YES,URI="seg1_7.0.m4s"`)) // part of ongoing segment missing
+	is.True(strings.Contains(out, `#EXT-X-PRELOAD-HINT:TYPE=PART,URI="seg1_7.1.m4s"`))              // preload hint missing
+	is.True(strings.Contains(out, "seg0_6.m4s\n"))                                                  // segment URI with sequence number missing
+}
+
+func TestLiveSimServeHTTP(t *testing.T) {
+	is := is.New(t)
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	ls, err := NewLiveSim(newLiveSimTestVOD(t), start, 10)
+	is.NoErr(err) // create live simulator
+	now := start
+	ls.Now = func() time.Time { return now }
+
+	server := httptest.NewServer(ls)
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	is.NoErr(err)                                  // request playlist
+	is.Equal(resp.StatusCode, http.StatusNotFound) // playlist not yet available
+	resp.Body.Close()
+
+	now = start.Add(25 * time.Second)
+	resp, err = http.Get(server.URL)
+	is.NoErr(err)                                                              // request playlist
+	is.Equal(resp.StatusCode, http.StatusOK)                                   // playlist not served
+	is.Equal(resp.Header.Get("Content-Type"), "application/vnd.apple.mpegurl") // wrong content type
+	p, listType, err := DecodeFrom(resp.Body, true)
+	resp.Body.Close()
+	is.NoErr(err)                                 // decode served playlist
+	is.Equal(listType, MEDIA)                     // served playlist not media playlist
+	is.Equal(p.(*MediaPlaylist).SeqNo, uint64(4)) // served SeqNo not 4
+}
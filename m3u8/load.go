@@ -0,0 +1,203 @@
+package m3u8
+
+/*
+ This file defines functions for loading a master playlist together with its media playlists.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+)
+
+var ErrNotMasterPlaylist = errors.New("not a master playlist")
+var ErrNotMediaPlaylist = errors.New("not a media playlist")
+var ErrUnexpectedStatus = errors.New("unexpected HTTP status")
+
+// loadWorkers is the maximal number of media playlists fetched concurrently by LoadPresentation.
+const loadWorkers = 8
+
+// Fetcher fetches a playlist given its URI.
+type Fetcher interface {
+	Fetch(ctx context.Context, uri string) (io.ReadCloser, error)
+}
+
+// FetcherFunc is an adapter to use a function as a Fetcher.
+type FetcherFunc func(ctx context.Context, uri string) (io.ReadCloser, error)
+
+// Fetch calls f(ctx, uri).
+func (f FetcherFunc) Fetch(ctx context.Context, uri string) (io.ReadCloser, error) {
+	return f(ctx, uri)
+}
+
+// NewFSFetcher returns a Fetcher reading playlists from fsys.
+// URIs are percent-decoded and cleaned, and used as paths in fsys with any leading slash removed.
+func NewFSFetcher(fsys fs.FS) Fetcher {
+	return FetcherFunc(func(ctx context.Context, uri string) (io.ReadCloser, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		name, err := url.PathUnescape(uri)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: uri, Err: fs.ErrInvalid}
+		}
+		return fsys.Open(strings.TrimPrefix(path.Clean("/"+name), "/"))
+	})
+}
+
+// NewHTTPFetcher returns a Fetcher making GET requests with client.
+// If client is nil, http.DefaultClient is used. Responses with a status other than 200 OK
+// result in an error wrapping ErrUnexpectedStatus.
+func NewHTTPFetcher(client *http.Client) Fetcher {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return FetcherFunc(func(ctx context.Context, uri string) (io.ReadCloser, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%w: %s", ErrUnexpectedStatus, resp.Status)
+		}
+		return resp.Body, nil
+	})
+}
+
+// LoadPresentation fetches and decodes the master playlist at root and all media playlists
+// it references. Variant, I-frame variant and rendition playlists are fetched concurrently
+// and attached to Variant.Chunklist and Alternative.Chunklist. A media playlist referenced
+// more than once is only fetched once.
+//
+// Relative URIs are resolved against the location of the master playlist, and the
+// BaseURL of every playlist is set to its location. The URIs in the playlists are not changed.
+// The first error stops the loading and is returned.
+func LoadPresentation(ctx context.Context, root string, fetch Fetcher) (*MasterPlaylist, error) {
+	rootURL, err := url.Parse(root)
+	if err != nil {
+		return nil, err
+	}
+	p, listType, err := fetchPlaylist(ctx, fetch, root)
+	if err != nil {
+		return nil, fmt.Errorf("load %s: %w", root, err)
+	}
+	if listType != MASTER {
+		return nil, fmt.Errorf("load %s: %w", root, ErrNotMasterPlaylist)
+	}
+	master := p.(*MasterPlaylist)
+	master.BaseURL = rootURL
+
+	// Collect the distinct media playlist URIs
+	var uris []string
+	chunklists := make(map[string]*MediaPlaylist)
+	addURI := func(uri string) {
+		if uri == "" {
+			return
+		}
+		uri = resolveURI(rootURL, uri)
+		if _, ok := chunklists[uri]; !ok {
+			chunklists[uri] = nil
+			uris = append(uris, uri)
+		}
+	}
+	for _, v := range master.Variants {
+		addURI(v.URI)
+		for _, alt := range v.Alternatives {
+			addURI(alt.URI)
+		}
+	}
+	for _, alt := range master.Alternatives {
+		addURI(alt.URI)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	jobs := make(chan string)
+	for i := 0; i < loadWorkers && i < len(uris); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for uri := range jobs {
+				pl, err := loadMediaPlaylist(ctx, fetch, uri)
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = fmt.Errorf("load %s: %w", uri, err)
+					cancel()
+				}
+				chunklists[uri] = pl
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, uri := range uris {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- uri
+	}
+	close(jobs)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, v := range master.Variants {
+		if v.URI != "" {
+			v.Chunklist = chunklists[resolveURI(rootURL, v.URI)]
+		}
+		for _, alt := range v.Alternatives {
+			if alt.URI != "" {
+				alt.Chunklist = chunklists[resolveURI(rootURL, alt.URI)]
+			}
+		}
+	}
+	for _, alt := range master.Alternatives {
+		if alt.URI != "" {
+			alt.Chunklist = chunklists[resolveURI(rootURL, alt.URI)]
+		}
+	}
+	return master, nil
+}
+
+// loadMediaPlaylist fetches and decodes the media playlist at uri and sets its BaseURL.
+func loadMediaPlaylist(ctx context.Context, fetch Fetcher, uri string) (*MediaPlaylist, error) {
+	p, listType, err := fetchPlaylist(ctx, fetch, uri)
+	if err != nil {
+		return nil, err
+	}
+	if listType != MEDIA {
+		return nil, ErrNotMediaPlaylist
+	}
+	pl := p.(*MediaPlaylist)
+	pl.BaseURL, _ = url.Parse(uri)
+	return pl, nil
+}
+
+func fetchPlaylist(ctx context.Context, fetch Fetcher, uri string) (Playlist, ListType, error) {
+	rc, err := fetch.Fetch(ctx, uri)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rc.Close()
+	return DecodeFrom(rc, false)
+}
@@ -0,0 +1,130 @@
+package m3u8
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/matryer/is"
+)
+
+const loadTestMaster = `#EXTM3U
+#EXT-X-VERSION:4
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="English",DEFAULT=YES,URI="audio/en.m3u8"
+#EXT-X-MEDIA:TYPE=CLOSED-CAPTIONS,GROUP-ID="cc",NAME="English",INSTREAM-ID="CC1"
+#EXT-X-STREAM-INF:BANDWIDTH=1000000,AUDIO="aac",CLOSED-CAPTIONS="cc"
+video/720p.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=2000000,AUDIO="aac",CLOSED-CAPTIONS="cc"
+video/1080p.m3u8
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=100000,URI="video/iframes.m3u8"
+`
+
+const loadTestMedia = `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:4
+#EXTINF:4.000,
+seg0.ts
+#EXT-X-ENDLIST
+`
+
+func loadTestFS() fstest.MapFS {
+	fsys := fstest.MapFS{"vod/master.m3u8": &fstest.MapFile{Data: []byte(loadTestMaster)}}
+	for _, name := range []string{"audio/en.m3u8", "video/720p.m3u8", "video/1080p.m3u8", "video/iframes.m3u8"} {
+		fsys["vod/"+name] = &fstest.MapFile{Data: []byte(loadTestMedia)}
+	}
+	return fsys
+}
+
+func TestLoadPresentationFS(t *testing.T) {
+	is := is.New(t)
+	fsys := loadTestFS()
+	var mu sync.Mutex
+	fetched := make(map[string]int)
+	fetch := FetcherFunc(func(ctx context.Context, uri string) (io.ReadCloser, error) {
+		mu.Lock()
+		fetched[uri]++
+		mu.Unlock()
+		return NewFSFetcher(fsys).Fetch(ctx, uri)
+	})
+
+	m, err := LoadPresentation(context.Background(), "vod/master.m3u8", fetch)
+	is.NoErr(err)                                                              // load presentation
+	is.Equal(len(fetched), 5)                                                  // master and 4 media playlists
+	is.Equal(fetched["vod/audio/en.m3u8"], 1)                                  // shared rendition must be fetched once
+	is.Equal(len(m.Variants), 3)                                               // variants
+	is.True(m.Variants[0].Chunklist != nil)                                    // variant chunklist not set
+	is.True(m.Variants[2].Chunklist != nil)                                    // I-frame chunklist not set
+	is.Equal(m.Variants[1].Chunklist.BaseURL.String(), "vod/video/1080p.m3u8") // chunklist base URL
+	is.Equal(m.Variants[0].URI, "video/720p.m3u8")                             // URIs must not be changed
+	for _, alt := range m.Alternatives {
+		if alt.Type == "AUDIO" {
+			is.True(alt.Chunklist != nil) // rendition chunklist not set
+			is.Equal(alt.Chunklist.Count(), uint(1))
+		} else {
+			is.Equal(alt.Chunklist, nil) // closed captions have no chunklist
+		}
+	}
+}
+
+func TestLoadPresentationHTTP(t *testing.T) {
+	is := is.New(t)
+	srv := httptest.NewServer(http.FileServer(http.FS(loadTestFS())))
+	defer srv.Close()
+
+	m, err := LoadPresentation(context.Background(), srv.URL+"/vod/master.m3u8", NewHTTPFetcher(srv.Client()))
+	is.NoErr(err) // load presentation
+	for _, v := range m.Variants {
+		is.True(v.Chunklist != nil) // chunklist not set
+	}
+	is.Equal(m.Variants[0].Chunklist.BaseURL.String(), srv.URL+"/vod/video/720p.m3u8") // chunklist base URL
+
+	_, err = LoadPresentation(context.Background(), srv.URL+"/vod/missing.m3u8", NewHTTPFetcher(srv.Client()))
+	is.True(errors.Is(err, ErrUnexpectedStatus)) // missing master must fail
+}
+
+func TestLoadPresentationErrors(t *testing.T) {
+	is := is.New(t)
+	fsys := loadTestFS()
+	delete(fsys, "vod/video/1080p.m3u8")
+	_, err := LoadPresentation(context.Background(), "vod/master.m3u8", NewFSFetcher(fsys))
+	is.True(errors.Is(err, fs.ErrNotExist)) // missing media playlist must fail
+
+	_, err = LoadPresentation(context.Background(), "vod/audio/en.m3u8", NewFSFetcher(loadTestFS()))
+	is.True(errors.Is(err, ErrNotMasterPlaylist)) // media playlist as root must fail
+
+	fsys = loadTestFS()
+	fsys["vod/video/1080p.m3u8"] = fsys["vod/master.m3u8"]
+	_, err = LoadPresentation(context.Background(), "vod/master.m3u8", NewFSFetcher(fsys))
+	is.True(errors.Is(err, ErrNotMediaPlaylist))                   // master playlist as media playlist must fail
+	is.True(strings.Contains(err.Error(), "vod/video/1080p.m3u8")) // error must name the URI
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = LoadPresentation(ctx, "vod/master.m3u8", NewFSFetcher(loadTestFS()))
+	is.True(errors.Is(err, context.Canceled)) // canceled context must fail
+}
+
+func TestFSFetcherEscapedURIs(t *testing.T) {
+	is := is.New(t)
+	fsys := fstest.MapFS{
+		"vod/master.m3u8":        &fstest.MapFile{Data: []byte("#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1000000\nvideo/720p%20hd.m3u8\n")},
+		"vod/video/720p hd.m3u8": &fstest.MapFile{Data: []byte(loadTestMedia)},
+	}
+	m, err := LoadPresentation(context.Background(), "/vod/master.m3u8", NewFSFetcher(fsys))
+	is.NoErr(err)                                       // percent-encoded URI must be decoded
+	is.Equal(m.Variants[0].Chunklist.Count(), uint(1))  // chunklist loaded from "720p hd.m3u8"
+	is.Equal(m.Variants[0].URI, "video/720p%20hd.m3u8") // URI must not be changed
+
+	f, err := NewFSFetcher(fsys).Fetch(context.Background(), "vod/audio/../video/720p%20hd.m3u8")
+	is.NoErr(err) // path must be cleaned
+	f.Close()
+	_, err = NewFSFetcher(fsys).Fetch(context.Background(), "vod/video/%zz.m3u8")
+	is.True(errors.Is(err, fs.ErrInvalid)) // invalid escape must fail
+}
@@ -94,7 +94,7 @@ func TestReadWriteAlternative(t *testing.T) {
 			}
 			is.NoErr(err)
 			out := bytes.Buffer{}
-			writeExtXMedia(&out, &alt)
+			writeExtXMedia(&out, &alt, nil)
 			is.Equal(c.line, trimLineEnd(out.String())) // EXT-X-MEDIA line must match
 		})
 	}
@@ -189,7 +189,7 @@ func TestReadWriteDateRange(t *testing.T) {
 			}
 			is.NoErr(err) // parseDateRange did not succeed
 			out := bytes.Buffer{}
-			writeDateRange(&out, dr, DefaultFloatPrecision)
+			writeDateRange(&out, dr, DefaultFloatPrecision, nil)
 			is.Equal(c.line, trimLineEnd(out.String())) // EXT-X-DATERANGE line must match
 		})
 	}
@@ -302,7 +302,7 @@ func TestReadWriteExtXIFrameStreamInf(t *testing.T) {
 			}
 			is.NoErr(err)
 			out := bytes.Buffer{}
-			writeExtXIFrameStreamInf(&out, vnt, DefaultFloatPrecision)
+			writeExtXIFrameStreamInf(&out, vnt, DefaultFloatPrecision, nil)
 			outStr := trimLineEnd(out.String())
 			is.Equal(c.line, outStr) // EXT-X-STREAM-INF line must match
 		})
@@ -346,7 +346,7 @@ func TestReadWriteSessionData(t *testing.T) {
 			}
 			is.NoErr(err)
 			out := bytes.Buffer{}
-			writeSessionData(&out, sd)
+			writeSessionData(&out, sd, nil)
 			outStr := trimLineEnd(out.String())
 			is.Equal(c.line, outStr) // EXT-X-SESSION-DATA line must match
 		})
@@ -352,6 +352,7 @@ func decode(buf *bytes.Buffer, strict bool, customDecoders []CustomDecoder) (Pla
 	switch state.listType {
 	case MASTER:
 		master.attachRenditionsToVariants(state.alternatives)
+		master.Alternatives = state.alternatives
 		return master, MASTER, nil
 	case MEDIA:
 		if media.Closed || media.MediaType == EVENT {
@@ -1053,8 +1054,9 @@ func decodeLineOfMediaPlaylist(p *MediaPlaylist, state *decodingState, line stri
 		if state.tagKey {
 			p.Segments[p.last()].Keys = state.xkeys
 			// First EXT-X-KEY may appeared in the header of the playlist and linked to first segment
-			// but for convenient playlist generation it also linked as default playlist key
-			if len(p.Keys) == 0 {
+			// but for convenient playlist generation it also linked as default playlist key.
+			// A key appearing after the first segment does not apply to the segments before it.
+			if len(p.Keys) == 0 && p.Count() == 1 {
 				p.Keys = state.xkeys
 			}
 			// reset state
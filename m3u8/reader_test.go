@@ -97,6 +97,24 @@ func TestDecodeMasterPlaylistWithAlternativesB(t *testing.T) {
 	// fmt.Println(p.Encode().String())
 }
 
+func TestDecodeWithSetsMasterAlternatives(t *testing.T) {
+	is := is.New(t)
+	data, err := os.ReadFile("sample-playlists/master-with-alternatives.m3u8")
+	is.NoErr(err) // must read file
+	p := NewMasterPlaylist()
+	is.NoErr(p.Decode(*bytes.NewBuffer(data), false))
+	is.Equal(len(p.Alternatives), 9) // all EXT-X-MEDIA tags stored by MasterPlaylist.Decode
+
+	pl, listType, err := DecodeWith(bytes.NewReader(data), false, nil)
+	is.NoErr(err)
+	is.Equal(listType, MASTER)
+	is.Equal(pl.(*MasterPlaylist).Alternatives, p.Alternatives) // same alternatives as MasterPlaylist.Decode
+
+	pl, _, err = DecodeFrom(bytes.NewReader(data), false)
+	is.NoErr(err)
+	is.Equal(len(pl.(*MasterPlaylist).Alternatives), 9) // DecodeFrom uses the same decoder
+}
+
 func TestDecodeMasterPlaylistWithClosedCaptionEqNone(t *testing.T) {
 	is := is.New(t)
 	f, err := os.Open("sample-playlists/master-with-closed-captions-eq-none.m3u8")
@@ -593,6 +611,28 @@ func TestDecodeMediaPlaylistWithDiscontinuitySeq(t *testing.T) {
 	}
 }
 
+func TestDecodeMediaPlaylistWithKeyAfterFirstSegment(t *testing.T) {
+	is := is.New(t)
+	const playlist = `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:10
+#EXTINF:10.000,
+clear.ts
+#EXT-X-KEY:METHOD=AES-128,URI="key1.bin"
+#EXTINF:10.000,
+enc.ts
+#EXT-X-ENDLIST
+`
+	p, _, err := DecodeFrom(strings.NewReader(playlist), true)
+	is.NoErr(err) // must decode playlist
+	pp := p.(*MediaPlaylist)
+	is.Equal(len(pp.Keys), 0)                          // key after the first segment is no playlist key
+	is.Equal(len(pp.EffectiveKeys(pp.Segments[0])), 0) // first segment not encrypted
+	is.Equal(pp.EffectiveKeys(pp.Segments[1])[0].URI, "key1.bin")
+	out := pp.String()
+	is.True(strings.Index(out, "clear.ts") < strings.Index(out, "#EXT-X-KEY")) // first segment stays clear after encoding
+}
+
 func TestDecodeMasterPlaylistWithCustomTags(t *testing.T) {
 	is := is.New(t)
 	cases := []struct {
@@ -0,0 +1,274 @@
+package m3u8
+
+/*
+ This file defines the management of rendition groups (EXT-X-MEDIA) in master playlists.
+*/
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidRendition = errors.New("invalid rendition")
+var ErrDuplicateRendition = errors.New("duplicate rendition NAME in group")
+var ErrMultipleDefaults = errors.New("multiple DEFAULT=YES renditions in group")
+var ErrRenditionNotFound = errors.New("rendition not found")
+
+// RenditionGroup is the set of renditions with the same TYPE and GROUP-ID.
+type RenditionGroup struct {
+	Type       string         // Type is AUDIO, VIDEO, SUBTITLES or CLOSED-CAPTIONS
+	GroupId    string         // GroupId is the GROUP-ID
+	Renditions []*Alternative // Renditions are the renditions in playlist order
+}
+
+// Default returns the rendition with DEFAULT=YES, or nil if there is none.
+func (g *RenditionGroup) Default() *Alternative {
+	for _, alt := range g.Renditions {
+		if alt.Default {
+			return alt
+		}
+	}
+	return nil
+}
+
+// Rendition returns the rendition with the given NAME, or nil if there is none.
+func (g *RenditionGroup) Rendition(name string) *Alternative {
+	for _, alt := range g.Renditions {
+		if alt.Name == name {
+			return alt
+		}
+	}
+	return nil
+}
+
+// RenditionGroups returns the rendition groups of the master playlist in order of appearance.
+// Renditions are collected from Alternatives and from the Alternatives of all variants,
+// and a rendition attached to several variants is only included once.
+func (p *MasterPlaylist) RenditionGroups() []*RenditionGroup {
+	var groups []*RenditionGroup
+	index := make(map[string]*RenditionGroup)
+	seen := make(map[*Alternative]bool)
+	add := func(alt *Alternative) {
+		if alt == nil || seen[alt] {
+			return
+		}
+		seen[alt] = true
+		key := alt.Type + "/" + alt.GroupId
+		g, ok := index[key]
+		if !ok {
+			g = &RenditionGroup{Type: alt.Type, GroupId: alt.GroupId}
+			index[key] = g
+			groups = append(groups, g)
+		}
+		g.Renditions = append(g.Renditions, alt)
+	}
+	for _, alt := range p.Alternatives {
+		add(alt)
+	}
+	for _, v := range p.Variants {
+		for _, alt := range v.Alternatives {
+			add(alt)
+		}
+	}
+	return groups
+}
+
+// RenditionGroup returns the rendition group with the given TYPE and GROUP-ID, or nil if there is none.
+func (p *MasterPlaylist) RenditionGroup(typ, groupID string) *RenditionGroup {
+	for _, g := range p.RenditionGroups() {
+		if g.Type == typ && g.GroupId == groupID {
+			return g
+		}
+	}
+	return nil
+}
+
+// AddRendition adds a rendition to the group given by its TYPE and GROUP-ID. The rendition is
+// added to Alternatives and attached to every variant referencing the group.
+// An error is returned if TYPE, GROUP-ID or NAME is missing, if the group already has
+// a rendition with the same NAME, or if the rendition and another one in the group
+// both have DEFAULT=YES.
+func (p *MasterPlaylist) AddRendition(alt *Alternative) error {
+	switch {
+	case alt == nil:
+		return fmt.Errorf("%w: nil", ErrInvalidRendition)
+	case alt.Type != "AUDIO" && alt.Type != "VIDEO" && alt.Type != "SUBTITLES" && alt.Type != "CLOSED-CAPTIONS":
+		return fmt.Errorf("%w: TYPE %q", ErrInvalidRendition, alt.Type)
+	case alt.GroupId == "" || alt.Name == "":
+		return fmt.Errorf("%w: missing GROUP-ID or NAME", ErrInvalidRendition)
+	}
+	if g := p.RenditionGroup(alt.Type, alt.GroupId); g != nil {
+		if g.Rendition(alt.Name) != nil {
+			return fmt.Errorf("%w: %s %q", ErrDuplicateRendition, alt.GroupId, alt.Name)
+		}
+		if d := g.Default(); alt.Default && d != nil {
+			return fmt.Errorf("%w: %s %q and %q", ErrMultipleDefaults, alt.GroupId, d.Name, alt.Name)
+		}
+	}
+	p.Alternatives = append(p.Alternatives, alt)
+	for _, v := range p.Variants {
+		if ref := groupRef(v, alt.Type); ref != nil && *ref == alt.GroupId {
+			v.Alternatives = append(v.Alternatives, alt)
+		}
+	}
+	p.buf.Reset()
+	return nil
+}
+
+// RemoveRendition removes the rendition with the given TYPE, GROUP-ID and NAME from
+// Alternatives and from all variants. It reports whether a rendition was removed.
+// If it was the last rendition of the group, the references to the group are removed
+// from all variants as by RemoveRenditionGroup.
+func (p *MasterPlaylist) RemoveRendition(typ, groupID, name string) bool {
+	removed := p.removeRenditions(func(alt *Alternative) bool {
+		return alt.Type == typ && alt.GroupId == groupID && alt.Name == name
+	})
+	if removed && p.RenditionGroup(typ, groupID) == nil {
+		p.removeGroupRefs(typ, groupID)
+	}
+	return removed
+}
+
+// RemoveRenditionGroup removes all renditions of the group with the given TYPE and GROUP-ID,
+// and removes the references to the group from all variants. It reports whether a rendition
+// was removed.
+func (p *MasterPlaylist) RemoveRenditionGroup(typ, groupID string) bool {
+	removed := p.removeRenditions(func(alt *Alternative) bool {
+		return alt.Type == typ && alt.GroupId == groupID
+	})
+	p.removeGroupRefs(typ, groupID)
+	return removed
+}
+
+// removeGroupRefs clears the references to a group in all variants. A CLOSED-CAPTIONS reference
+// is cleared rather than set to NONE, as NONE would be required for all variants.
+func (p *MasterPlaylist) removeGroupRefs(typ, groupID string) {
+	for _, v := range p.Variants {
+		if ref := groupRef(v, typ); ref != nil && *ref == groupID {
+			*ref = ""
+		}
+	}
+	p.buf.Reset()
+}
+
+// removeRenditions removes all renditions matching remove and reports whether there were any.
+func (p *MasterPlaylist) removeRenditions(remove func(alt *Alternative) bool) bool {
+	removed := false
+	filter := func(alts []*Alternative) []*Alternative {
+		var kept []*Alternative
+		for _, alt := range alts {
+			if alt != nil && remove(alt) {
+				removed = true
+				continue
+			}
+			kept = append(kept, alt)
+		}
+		return kept
+	}
+	p.Alternatives = filter(p.Alternatives)
+	for _, v := range p.Variants {
+		v.Alternatives = filter(v.Alternatives)
+	}
+	p.buf.Reset()
+	return removed
+}
+
+// SetDefaultRendition sets DEFAULT=YES for the rendition with the given TYPE, GROUP-ID and NAME,
+// and DEFAULT=NO for all other renditions of the group.
+func (p *MasterPlaylist) SetDefaultRendition(typ, groupID, name string) error {
+	g := p.RenditionGroup(typ, groupID)
+	if g == nil || g.Rendition(name) == nil {
+		return fmt.Errorf("%w: %s %s %q", ErrRenditionNotFound, typ, groupID, name)
+	}
+	for _, alt := range g.Renditions {
+		alt.Default = alt.Name == name
+	}
+	p.buf.Reset()
+	return nil
+}
+
+// ValidateRenditionGroups checks that NAME is unique within every rendition group, that every
+// group has at most one rendition with DEFAULT=YES, and that every group referenced by a
+// variant exists. The errors of all violations are joined together.
+func (p *MasterPlaylist) ValidateRenditionGroups() error {
+	var errs []error
+	groups := p.RenditionGroups()
+	exists := make(map[string]bool)
+	for _, g := range groups {
+		exists[g.Type+"/"+g.GroupId] = true
+		names := make(map[string]bool)
+		var defaults []string
+		for _, alt := range g.Renditions {
+			if names[alt.Name] {
+				errs = append(errs, fmt.Errorf("%w: %s %q", ErrDuplicateRendition, g.GroupId, alt.Name))
+			}
+			names[alt.Name] = true
+			if alt.Default {
+				defaults = append(defaults, alt.Name)
+			}
+		}
+		if len(defaults) > 1 {
+			errs = append(errs, fmt.Errorf("%w: %s %q", ErrMultipleDefaults, g.GroupId, defaults))
+		}
+	}
+	for _, v := range p.Variants {
+		for _, typ := range []string{"AUDIO", "VIDEO", "SUBTITLES", "CLOSED-CAPTIONS"} {
+			ref := groupRef(v, typ)
+			if ref != nil && *ref != "" && *ref != "NONE" && !exists[typ+"/"+*ref] {
+				errs = append(errs, fmt.Errorf("%w: %s group %q referenced by %s", ErrRenditionNotFound, typ, *ref, v.URI))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// groupRef returns the variant attribute referencing a rendition group of type typ,
+// or nil for an unknown type. I-frame variants only reference VIDEO groups.
+func groupRef(v *Variant, typ string) *string {
+	switch {
+	case typ == "VIDEO":
+		return &v.Video
+	case v.Iframe:
+		return nil
+	case typ == "AUDIO":
+		return &v.Audio
+	case typ == "SUBTITLES":
+		return &v.Subtitles
+	case typ == "CLOSED-CAPTIONS":
+		return &v.Captions
+	}
+	return nil
+}
+
+// withGroupRefs returns the variant with its group references made consistent with the
+// rendition groups in groups, which are given by TYPE/GROUP-ID. An empty reference is set to
+// the GROUP-ID of the first attached rendition of its type, and a reference to a group without
+// renditions is dropped. CLOSED-CAPTIONS=NONE is kept. A copy is returned if anything changes.
+func withGroupRefs(v *Variant, groups map[string]bool) *Variant {
+	c := *v
+	changed := false
+	for _, typ := range []string{"AUDIO", "VIDEO", "SUBTITLES", "CLOSED-CAPTIONS"} {
+		ref := groupRef(&c, typ)
+		if ref == nil || (typ == "CLOSED-CAPTIONS" && *ref == "NONE") {
+			continue
+		}
+		resolved := *ref
+		if resolved == "" {
+			for _, alt := range v.Alternatives {
+				if alt != nil && alt.Type == typ {
+					resolved = alt.GroupId
+					break
+				}
+			}
+		} else if !groups[typ+"/"+resolved] {
+			resolved = ""
+		}
+		if resolved != *ref {
+			*ref, changed = resolved, true
+		}
+	}
+	if !changed {
+		return v
+	}
+	return &c
+}
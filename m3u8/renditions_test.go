@@ -0,0 +1,110 @@
+package m3u8
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestRenditionGroups(t *testing.T) {
+	is := is.New(t)
+	m := NewMasterPlaylist()
+	m.Append("360p.m3u8", nil, VariantParams{Bandwidth: 800000, Audio: "aac"})
+	m.Append("720p.m3u8", nil, VariantParams{Bandwidth: 2500000, Audio: "aac", Subtitles: "subs"})
+	en := &Alternative{Type: "AUDIO", GroupId: "aac", Name: "English", Language: "en", Default: true, URI: "audio/en.m3u8"}
+	is.NoErr(m.AddRendition(en)) // add rendition
+	de := &Alternative{Type: "AUDIO", GroupId: "aac", Name: "Deutsch", Language: "de", URI: "audio/de.m3u8"}
+	is.NoErr(m.AddRendition(de))
+	is.NoErr(m.AddRendition(&Alternative{Type: "SUBTITLES", GroupId: "subs", Name: "Deutsch", Language: "de", URI: "subs/de.m3u8"}))
+
+	is.Equal(m.Variants[0].Alternatives, []*Alternative{en, de}) // renditions attached by AddRendition
+	is.Equal(len(m.Variants[1].Alternatives), 3)
+	m.Append("1080p.m3u8", nil, VariantParams{Bandwidth: 5000000, Audio: "aac"})
+	is.Equal(len(m.Variants[2].Alternatives), 0) // Append does not attach renditions
+	is.True(strings.Contains(m.String(), "#EXT-X-STREAM-INF:BANDWIDTH=5000000,AUDIO=\"aac\"\n"))
+	groups := m.RenditionGroups()
+	is.Equal(len(groups), 2)
+	is.Equal(groups[0].Renditions, []*Alternative{en, de}) // shared renditions listed once
+	is.Equal(m.RenditionGroup("AUDIO", "aac").Default(), en)
+	is.Equal(m.RenditionGroup("VIDEO", "aac"), (*RenditionGroup)(nil))
+	is.Equal(strings.Count(m.String(), "#EXT-X-MEDIA:"), 3)
+
+	err := m.AddRendition(&Alternative{Type: "AUDIO", GroupId: "aac", Name: "English", URI: "audio/en2.m3u8"})
+	is.True(errors.Is(err, ErrDuplicateRendition)) // NAME must be unique in group
+	err = m.AddRendition(&Alternative{Type: "AUDIO", GroupId: "aac", Name: "Francais", Default: true})
+	is.True(errors.Is(err, ErrMultipleDefaults)) // one DEFAULT per group
+	err = m.AddRendition(&Alternative{Type: "DATA", GroupId: "x", Name: "x"})
+	is.True(errors.Is(err, ErrInvalidRendition)) // unknown TYPE
+	is.NoErr(m.ValidateRenditionGroups())
+
+	is.NoErr(m.SetDefaultRendition("AUDIO", "aac", "Deutsch")) // switch default
+	is.True(de.Default && !en.Default)
+	is.True(errors.Is(m.SetDefaultRendition("AUDIO", "aac", "Francais"), ErrRenditionNotFound))
+
+	is.True(m.RemoveRendition("AUDIO", "aac", "English")) // remove rendition
+	is.Equal(m.Variants[1].Alternatives[0], de)
+	is.True(!strings.Contains(m.String(), "audio/en.m3u8"))
+	is.True(!m.RemoveRendition("AUDIO", "aac", "English")) // already removed
+
+	is.True(m.RemoveRenditionGroup("SUBTITLES", "subs")) // remove group
+	is.Equal(m.Variants[1].Subtitles, "")                // reference removed
+	is.True(!strings.Contains(m.String(), "SUBTITLES"))
+
+	m.Variants[0].Video = "missing"
+	m.Variants[1].Alternatives = append(m.Variants[1].Alternatives, &Alternative{Type: "AUDIO", GroupId: "aac", Name: "Deutsch", Default: true})
+	err = m.ValidateRenditionGroups()
+	is.True(errors.Is(err, ErrRenditionNotFound))  // missing group
+	is.True(errors.Is(err, ErrDuplicateRendition)) // duplicate NAME
+	is.True(errors.Is(err, ErrMultipleDefaults))   // two defaults
+}
+
+func TestEncodeAttachedRenditionGroups(t *testing.T) {
+	is := is.New(t)
+	m := NewMasterPlaylist()
+	audio := &Alternative{Type: "AUDIO", GroupId: "aac", Name: "English", URI: "audio/en.m3u8"}
+	m.Append("360p.m3u8", nil, VariantParams{Bandwidth: 800000, Alternatives: []*Alternative{audio}})
+	is.True(strings.Contains(m.String(), `#EXT-X-STREAM-INF:BANDWIDTH=800000,AUDIO="aac"`)) // group reference from attached rendition
+	is.Equal(m.Variants[0].Audio, "")                                                       // variant not changed
+}
+
+func TestEncodeDanglingGroupReferences(t *testing.T) {
+	is := is.New(t)
+	m := NewMasterPlaylist()
+	m.Append("360p.m3u8", nil, VariantParams{Bandwidth: 800000, Audio: "aac", Subtitles: "subs"})
+	m.Append("720p.m3u8", nil, VariantParams{Bandwidth: 2500000, Audio: "aac", Subtitles: "subs", Video: "missing"})
+	m.Append("iframes.m3u8", nil, VariantParams{Bandwidth: 100000, Iframe: true, Video: "missing"})
+	is.NoErr(m.AddRendition(&Alternative{Type: "AUDIO", GroupId: "aac", Name: "English", URI: "audio/en.m3u8"}))
+	is.NoErr(m.AddRendition(&Alternative{Type: "SUBTITLES", GroupId: "subs", Name: "English", URI: "subs/en.m3u8"}))
+
+	is.True(m.RemoveRendition("SUBTITLES", "subs", "English")) // remove last rendition of a referenced group
+	is.Equal(m.Variants[0].Subtitles, "")                      // reference to emptied group removed
+	out := m.String()
+	is.True(!strings.Contains(out, "SUBTITLES"))                                                     // no reference to emptied group
+	is.True(!strings.Contains(out, `VIDEO="missing"`))                                               // reference to unknown group dropped
+	is.True(strings.Contains(out, "#EXT-X-STREAM-INF:BANDWIDTH=2500000,AUDIO=\"aac\"\n720p.m3u8\n")) // existing group kept
+	is.Equal(m.Variants[1].Video, "missing")                                                         // variant not changed by encoding
+
+	video := &Alternative{Type: "VIDEO", GroupId: "cam", Name: "Main", URI: "video/main.m3u8"}
+	m.Variants[2].Video = ""
+	m.Variants[2].Alternatives = []*Alternative{video}
+	m.ResetCache()
+	is.True(strings.Contains(m.String(), `#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=100000,VIDEO="cam",URI="iframes.m3u8"`)) // I-frame VIDEO from attached rendition
+}
+
+func TestRemoveClosedCaptionsGroup(t *testing.T) {
+	is := is.New(t)
+	m := NewMasterPlaylist()
+	m.Append("360p.m3u8", nil, VariantParams{Bandwidth: 800000, Captions: "cc1"})
+	m.Append("720p.m3u8", nil, VariantParams{Bandwidth: 2500000, Captions: "cc2"})
+	is.NoErr(m.AddRendition(&Alternative{Type: "CLOSED-CAPTIONS", GroupId: "cc1", Name: "English", InstreamId: "CC1"}))
+	is.NoErr(m.AddRendition(&Alternative{Type: "CLOSED-CAPTIONS", GroupId: "cc2", Name: "English", InstreamId: "CC1"}))
+
+	is.True(m.RemoveRenditionGroup("CLOSED-CAPTIONS", "cc1"))
+	is.Equal(m.Variants[0].Captions, "")    // reference cleared instead of NONE
+	is.Equal(m.Variants[1].Captions, "cc2") // other group kept
+	out := m.String()
+	is.True(!strings.Contains(out, "CLOSED-CAPTIONS=NONE")) // no mix of NONE and groups
+	is.True(strings.Contains(out, `CLOSED-CAPTIONS="cc2"`))
+}
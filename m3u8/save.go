@@ -0,0 +1,204 @@
+package m3u8
+
+/*
+ This file defines functions for writing a master playlist and its media playlists to disk.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var ErrURINotLocal = errors.New("URI is not a relative local path")
+var ErrSegmentMissing = errors.New("segment file does not exist")
+
+// WriteOptions provides options for WritePresentation.
+type WriteOptions struct {
+	MasterName     string      // MasterName is the file name of the master playlist. Defaults to "master.m3u8"
+	Perm           fs.FileMode // Perm is the permission of written files. Defaults to 0644
+	VerifySegments bool        // VerifySegments checks that all segment and EXT-X-MAP files exist in dir
+}
+
+// WritePresentation writes the master playlist and the media playlists attached to
+// Variant.Chunklist and Alternative.Chunklist into dir. File names are derived from the URIs,
+// which must be relative paths within dir. Query strings are ignored.
+//
+// Every file is first written to a temporary file and then renamed, so a playlist on disk is
+// either the old or the new version. The media playlists are renamed before the master
+// playlist, so the master playlist never references a media playlist that is not written.
+// If VerifySegments is set, nothing is written unless every segment and EXT-X-MAP URI
+// with a relative path refers to an existing file.
+func WritePresentation(dir string, master *MasterPlaylist, opts WriteOptions) error {
+	if opts.MasterName == "" {
+		opts.MasterName = "master.m3u8"
+	}
+	if opts.Perm == 0 {
+		opts.Perm = 0o644
+	}
+	masterPath, err := localPath(".", opts.MasterName)
+	if err != nil {
+		return err
+	}
+
+	// Collect the media playlists by file path
+	var paths []string
+	chunklists := make(map[string]*MediaPlaylist)
+	addChunklist := func(uri string, pl *MediaPlaylist) error {
+		if pl == nil {
+			return nil
+		}
+		p, err := localPath(path.Dir(masterPath), uri)
+		if err != nil {
+			return err
+		}
+		if prev, ok := chunklists[p]; ok {
+			if prev != pl {
+				return fmt.Errorf("different media playlists for %s", p)
+			}
+			return nil
+		}
+		chunklists[p] = pl
+		paths = append(paths, p)
+		return nil
+	}
+	for _, v := range master.Variants {
+		if err := addChunklist(v.URI, v.Chunklist); err != nil {
+			return err
+		}
+		for _, alt := range v.Alternatives {
+			if err := addChunklist(alt.URI, alt.Chunklist); err != nil {
+				return err
+			}
+		}
+	}
+	for _, alt := range master.Alternatives {
+		if err := addChunklist(alt.URI, alt.Chunklist); err != nil {
+			return err
+		}
+	}
+
+	if opts.VerifySegments {
+		for _, p := range paths {
+			if err := verifySegments(dir, path.Dir(p), chunklists[p]); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Write all temporary files before renaming any of them
+	var tmpFiles []string
+	defer func() {
+		for _, tmp := range tmpFiles {
+			_ = os.Remove(tmp)
+		}
+	}()
+	targets := make([]string, 0, len(paths)+1)
+	for _, p := range append(paths, masterPath) {
+		var data []byte
+		if p == masterPath {
+			data = master.Encode().Bytes()
+		} else {
+			data = chunklists[p].Encode().Bytes()
+		}
+		target := filepath.Join(dir, filepath.FromSlash(p))
+		tmp, err := writeTempFile(target, data, opts.Perm)
+		if err != nil {
+			return err
+		}
+		tmpFiles = append(tmpFiles, tmp)
+		targets = append(targets, target)
+	}
+	for i, tmp := range tmpFiles {
+		if err := os.Rename(tmp, targets[i]); err != nil {
+			tmpFiles = tmpFiles[i:]
+			return err
+		}
+	}
+	tmpFiles = nil
+	return nil
+}
+
+// localPath converts a URI relative to base, a clean relative path, to a clean relative path.
+// The path must stay within the directory after cleaning.
+func localPath(base, uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "" || u.Host != "" || u.Path == "" || strings.HasPrefix(u.Path, "/") {
+		return "", fmt.Errorf("%w: %q", ErrURINotLocal, uri)
+	}
+	p := path.Join(base, u.Path)
+	if !fs.ValidPath(p) || p == "." || !filepath.IsLocal(filepath.FromSlash(p)) {
+		return "", fmt.Errorf("%w: %q", ErrURINotLocal, uri)
+	}
+	return p, nil
+}
+
+// verifySegments checks that the segment and map files of a media playlist in playlistDir exist in dir.
+// URIs with a scheme, a host or an absolute path are not checked, and relative paths leaving dir
+// result in an error wrapping ErrURINotLocal.
+func verifySegments(dir, playlistDir string, pl *MediaPlaylist) error {
+	check := func(uri string) error {
+		u, err := url.Parse(uri)
+		if err != nil || u.Scheme != "" || u.Host != "" || strings.HasPrefix(u.Path, "/") {
+			return nil
+		}
+		p, err := localPath(playlistDir, uri)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(p))); err != nil {
+			return fmt.Errorf("%w: %s", ErrSegmentMissing, p)
+		}
+		return nil
+	}
+	if pl.Map != nil {
+		if err := check(pl.Map.URI); err != nil {
+			return err
+		}
+	}
+	for _, seg := range pl.GetAllSegments() {
+		if err := check(seg.URI); err != nil {
+			return err
+		}
+		if seg.Map != nil {
+			if err := check(seg.Map.URI); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeTempFile writes data to a new temporary file next to target and returns its name.
+func writeTempFile(target string, data []byte, perm fs.FileMode) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,97 @@
+package m3u8
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func newSaveTestMaster(is *is.I) *MasterPlaylist {
+	master := NewMasterPlaylist()
+	for _, name := range []string{"720p", "1080p"} {
+		pl, err := NewMediaPlaylist(0, 2)
+		is.NoErr(err) // create media playlist
+		is.NoErr(pl.Append(name+"/seg0.ts", 4, ""))
+		is.NoErr(pl.Append(name+"/seg1.ts", 4, ""))
+		pl.Close()
+		master.Append("video/"+name+".m3u8?token=1", pl, VariantParams{Bandwidth: 1000000})
+	}
+	return master
+}
+
+func TestWritePresentation(t *testing.T) {
+	is := is.New(t)
+	dir := t.TempDir()
+	master := newSaveTestMaster(is)
+
+	err := WritePresentation(dir, master, WriteOptions{})
+	is.NoErr(err) // write presentation
+	data, err := os.ReadFile(filepath.Join(dir, "master.m3u8"))
+	is.NoErr(err) // read master playlist
+	is.Equal(string(data), master.String())
+	data, err = os.ReadFile(filepath.Join(dir, "video", "1080p.m3u8"))
+	is.NoErr(err)                                                // read media playlist
+	is.True(strings.Contains(string(data), "\n1080p/seg1.ts\n")) // media playlist content
+	entries, err := os.ReadDir(filepath.Join(dir, "video"))
+	is.NoErr(err)
+	is.Equal(len(entries), 2) // temporary files must be removed
+}
+
+func TestWritePresentationVerifySegments(t *testing.T) {
+	is := is.New(t)
+	dir := t.TempDir()
+	master := newSaveTestMaster(is)
+
+	err := WritePresentation(dir, master, WriteOptions{MasterName: "main.m3u8", VerifySegments: true})
+	is.True(errors.Is(err, ErrSegmentMissing)) // missing segments must fail
+	_, err = os.Stat(filepath.Join(dir, "main.m3u8"))
+	is.True(errors.Is(err, os.ErrNotExist)) // nothing must be written
+
+	for _, name := range []string{"720p", "1080p"} {
+		is.NoErr(os.MkdirAll(filepath.Join(dir, "video", name), 0o755))
+		for _, seg := range []string{"seg0.ts", "seg1.ts"} {
+			is.NoErr(os.WriteFile(filepath.Join(dir, "video", name, seg), nil, 0o644))
+		}
+	}
+	err = WritePresentation(dir, master, WriteOptions{MasterName: "main.m3u8", VerifySegments: true})
+	is.NoErr(err) // all segments exist
+	_, err = os.Stat(filepath.Join(dir, "main.m3u8"))
+	is.NoErr(err) // master playlist written
+}
+
+func TestWritePresentationNonLocalURI(t *testing.T) {
+	is := is.New(t)
+	for _, uri := range []string{"https://example.com/720p.m3u8", "../720p.m3u8", "/720p.m3u8"} {
+		master := newSaveTestMaster(is)
+		master.Variants[0].URI = uri
+		err := WritePresentation(t.TempDir(), master, WriteOptions{})
+		is.True(errors.Is(err, ErrURINotLocal)) // non-local URI must fail
+	}
+}
+
+func TestWritePresentationSegmentOutsideDir(t *testing.T) {
+	is := is.New(t)
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "out")
+	is.NoErr(os.WriteFile(filepath.Join(parent, "escape.ts"), nil, 0o644))
+	master := newSaveTestMaster(is)
+	for _, name := range []string{"720p/seg0.ts", "720p/seg1.ts", "1080p/seg0.ts"} {
+		is.NoErr(os.MkdirAll(filepath.Join(dir, "video", filepath.Dir(name)), 0o755))
+		is.NoErr(os.WriteFile(filepath.Join(dir, "video", name), nil, 0o644))
+	}
+	master.Variants[1].Chunklist.Segments[1].URI = "../../escape.ts" // exists, but outside dir
+	err := WritePresentation(dir, master, WriteOptions{VerifySegments: true})
+	is.True(errors.Is(err, ErrURINotLocal)) // segment leaving dir must fail
+	_, err = os.Stat(filepath.Join(dir, "master.m3u8"))
+	is.True(errors.Is(err, os.ErrNotExist)) // nothing must be written
+
+	master = newSaveTestMaster(is)
+	master.Variants[0].URI = "../video/720p.m3u8"
+	is.NoErr(WritePresentation(dir, master, WriteOptions{MasterName: "sub/master.m3u8"})) // relative path within dir
+	_, err = os.Stat(filepath.Join(dir, "video", "720p.m3u8"))
+	is.NoErr(err) // media playlist written next to the master directory
+}
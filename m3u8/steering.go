@@ -0,0 +1,476 @@
+package m3u8
+
+/*
+ This file defines the content steering manifest, a server handler for it,
+ and the application of pathway clones to master playlists.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+var ErrInvalidSteeringManifest = errors.New("invalid steering manifest")
+var ErrPathwayNotFound = errors.New("pathway not found")
+var ErrInvalidPathway = errors.New("invalid pathway")
+
+// DefaultPathwayId is the PATHWAY-ID of variants without a PATHWAY-ID attribute.
+const DefaultPathwayId = "."
+
+// SteeringManifest is the JSON document at the SERVER-URI of EXT-X-CONTENT-STEERING.
+type SteeringManifest struct {
+	Version         int            `json:"VERSION"`                  // VERSION must be 1
+	TTL             int            `json:"TTL"`                      // TTL is the number of seconds until the manifest is reloaded
+	ReloadURI       string         `json:"RELOAD-URI,omitempty"`     // RELOAD-URI is the URI to reload the manifest from
+	PathwayPriority []string       `json:"PATHWAY-PRIORITY"`         // PATHWAY-PRIORITY lists pathway IDs in order of preference
+	PathwayClones   []PathwayClone `json:"PATHWAY-CLONES,omitempty"` // PATHWAY-CLONES define new pathways from existing ones
+}
+
+// PathwayClone is an entry of PATHWAY-CLONES defining pathway ID as a copy of pathway BaseId.
+type PathwayClone struct {
+	BaseId         string         `json:"BASE-ID"`
+	Id             string         `json:"ID"`
+	URIReplacement URIReplacement `json:"URI-REPLACEMENT"`
+}
+
+// URIReplacement defines how the URIs of a cloned pathway are derived from the base pathway.
+type URIReplacement struct {
+	Host             string            `json:"HOST,omitempty"`               // HOST replaces the host of URIs
+	Params           map[string]string `json:"PARAMS,omitempty"`             // PARAMS are added to the query of URIs
+	PerVariantURIs   map[string]string `json:"PER-VARIANT-URIS,omitempty"`   // PER-VARIANT-URIS maps STABLE-VARIANT-ID to URI
+	PerRenditionURIs map[string]string `json:"PER-RENDITION-URIS,omitempty"` // PER-RENDITION-URIS maps STABLE-RENDITION-ID to URI
+}
+
+// DecodeSteeringManifest decodes and validates a steering manifest.
+func DecodeSteeringManifest(r io.Reader) (*SteeringManifest, error) {
+	m := &SteeringManifest{}
+	if err := json.NewDecoder(r).Decode(m); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSteeringManifest, err.Error())
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Encode validates the steering manifest and returns its JSON encoding.
+func (m *SteeringManifest) Encode() (*bytes.Buffer, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	if err := json.NewEncoder(buf).Encode(m); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// Validate checks that VERSION is 1, TTL is positive, PATHWAY-PRIORITY is not empty,
+// and that all pathway IDs are valid and unique. Every clone must be based on a pathway
+// that is listed in PATHWAY-PRIORITY or defined by a previous clone.
+func (m *SteeringManifest) Validate() error {
+	invalid := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w: %s", ErrInvalidSteeringManifest, fmt.Sprintf(format, args...))
+	}
+	if m.Version != 1 {
+		return invalid("unsupported VERSION %d", m.Version)
+	}
+	if m.TTL <= 0 {
+		return invalid("TTL must be positive")
+	}
+	if len(m.PathwayPriority) == 0 {
+		return invalid("empty PATHWAY-PRIORITY")
+	}
+	known := make(map[string]bool)
+	for _, id := range m.PathwayPriority {
+		if !validPathwayId(id) || known[id] {
+			return invalid("invalid or duplicate pathway ID %q in PATHWAY-PRIORITY", id)
+		}
+		known[id] = true
+	}
+	defined := make(map[string]bool)
+	for _, c := range m.PathwayClones {
+		if !validPathwayId(c.Id) || defined[c.Id] {
+			return invalid("invalid or duplicate clone ID %q", c.Id)
+		}
+		if !known[c.BaseId] && !defined[c.BaseId] {
+			return invalid("unknown BASE-ID %q of clone %q", c.BaseId, c.Id)
+		}
+		defined[c.Id] = true
+	}
+	return nil
+}
+
+// validPathwayId reports whether id only contains the characters [a-zA-Z0-9._-].
+func validPathwayId(id string) bool {
+	if id == "" {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// SteeringRequest describes a request for the steering manifest.
+type SteeringRequest struct {
+	Pathway    string        // Pathway is the _HLS_pathway query parameter, the pathway currently in use
+	Throughput uint64        // Throughput is the _HLS_throughput query parameter in bits per second, or 0
+	Request    *http.Request // Request is the HTTP request
+}
+
+// SteeringPolicy decides the PATHWAY-PRIORITY for a steering request.
+type SteeringPolicy interface {
+	// PathwayPriority returns the pathway IDs in order of preference given the configured priority.
+	PathwayPriority(req SteeringRequest, priority []string) []string
+}
+
+// SteeringPolicyFunc is an adapter to use a function as a SteeringPolicy.
+type SteeringPolicyFunc func(req SteeringRequest, priority []string) []string
+
+// PathwayPriority calls f(req, priority).
+func (f SteeringPolicyFunc) PathwayPriority(req SteeringRequest, priority []string) []string {
+	return f(req, priority)
+}
+
+// SteeringServer serves a steering manifest at the SERVER-URI of EXT-X-CONTENT-STEERING.
+type SteeringServer struct {
+	// Manifest is the served manifest. Its PATHWAY-PRIORITY is passed to Policy.
+	Manifest SteeringManifest
+	// Policy decides the PATHWAY-PRIORITY of every response. If nil, or if it returns
+	// no pathways, the PATHWAY-PRIORITY of Manifest is served.
+	Policy SteeringPolicy
+}
+
+// ServeHTTP serves the steering manifest. The _HLS_pathway and _HLS_throughput query
+// parameters are passed to the policy, and an invalid _HLS_throughput results in
+// 400 Bad Request.
+func (s *SteeringServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	req := SteeringRequest{Pathway: query.Get("_HLS_pathway"), Request: r}
+	if t := query.Get("_HLS_throughput"); t != "" {
+		throughput, err := strconv.ParseUint(t, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid _HLS_throughput", http.StatusBadRequest)
+			return
+		}
+		req.Throughput = throughput
+	}
+	m := s.Manifest
+	if s.Policy != nil {
+		if priority := s.Policy.PathwayPriority(req, append([]string(nil), m.PathwayPriority...)); len(priority) > 0 {
+			m.PathwayPriority = priority
+		}
+	}
+	buf, err := m.Encode()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(buf.Bytes())
+}
+
+// ApplyPathwayClones adds the pathways defined by clones to the master playlist, as a client
+// does when it receives PATHWAY-CLONES. Every variant of the BASE-ID pathway is copied with
+// PATHWAY-ID set to the clone ID. Variants without PATHWAY-ID belong to DefaultPathwayId.
+// The renditions of the groups referenced by these variants are copied to new groups with
+// the clone ID appended to the GROUP-ID, e.g. "aac-cdn2".
+//
+// URIs are replaced by PER-VARIANT-URIS and PER-RENDITION-URIS by STABLE-VARIANT-ID and
+// STABLE-RENDITION-ID. Otherwise, the host is replaced by HOST and the PARAMS are added to
+// the query. Relative URIs are resolved against BaseURL before HOST is applied.
+// Clones whose ID already exists in the playlist are skipped.
+func (p *MasterPlaylist) ApplyPathwayClones(clones []PathwayClone) error {
+	for _, c := range clones {
+		if p.hasPathway(c.Id) {
+			continue
+		}
+		repl := c.URIReplacement
+		err := p.clonePathway(c.BaseId, c.Id, func(kind URIKind, uri, stableId string) (string, error) {
+			perURI := repl.PerVariantURIs
+			if kind == URIRendition {
+				perURI = repl.PerRenditionURIs
+			}
+			if u, ok := perURI[stableId]; ok && stableId != "" {
+				return u, nil
+			}
+			return repl.apply(p.BaseURL, uri)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	p.buf.Reset()
+	return nil
+}
+
+// Pathway defines a pathway, typically a CDN, for MasterPlaylist.AddPathways.
+type Pathway struct {
+	Id      string            // Id is the PATHWAY-ID
+	Host    string            // Host replaces the host of URIs. Relative URIs are resolved against BaseURL
+	Params  map[string]string // Params are added to the query of URIs
+	Rewrite URIRewriter       // Rewrite is applied to URIs after Host and Params, if not nil
+}
+
+// AddPathways prepares the master playlist for content steering with one pathway per entry
+// of pathways. The variants without PATHWAY-ID and their renditions are replaced by one copy
+// per pathway, as in ApplyPathwayClones, with the GROUP-IDs of every pathway suffixed by its ID
+// and URIs rewritten by Host, Params and Rewrite of the pathway.
+//
+// Copies of the same variant or rendition share the STABLE-VARIANT-ID or STABLE-RENDITION-ID
+// of the original. Variants and renditions without one get "v1", "v2", ... and "r1", "r2", ...
+// in playlist order. EXT-X-CONTENT-STEERING is set with serverURI and the first pathway as
+// the default pathway. If an error is returned, the master playlist is not changed.
+func (p *MasterPlaylist) AddPathways(serverURI string, pathways []Pathway) error {
+	if len(pathways) == 0 {
+		return fmt.Errorf("%w: no pathways", ErrInvalidPathway)
+	}
+	seen := make(map[string]bool)
+	for _, pw := range pathways {
+		if !validPathwayId(pw.Id) || pw.Id == DefaultPathwayId || seen[pw.Id] || p.hasPathway(pw.Id) {
+			return fmt.Errorf("%w: invalid or duplicate PATHWAY-ID %q", ErrInvalidPathway, pw.Id)
+		}
+		seen[pw.Id] = true
+	}
+
+	// Find the variants and renditions to be replaced
+	var template []*Variant
+	templateAlts := make(map[*Alternative]bool)
+	groups := make(map[string]bool)
+	for _, v := range p.Variants {
+		if pathwayOf(v) != DefaultPathwayId {
+			continue
+		}
+		template = append(template, v)
+		for _, alt := range v.Alternatives {
+			templateAlts[alt] = true
+		}
+		groups["AUDIO/"+v.Audio] = groups["AUDIO/"+v.Audio] || v.Audio != ""
+		groups["VIDEO/"+v.Video] = groups["VIDEO/"+v.Video] || v.Video != ""
+		groups["SUBTITLES/"+v.Subtitles] = groups["SUBTITLES/"+v.Subtitles] || v.Subtitles != ""
+		groups["CLOSED-CAPTIONS/"+v.Captions] = groups["CLOSED-CAPTIONS/"+v.Captions] || (v.Captions != "" && v.Captions != "NONE")
+	}
+	if len(template) == 0 {
+		return fmt.Errorf("%w: %q", ErrPathwayNotFound, DefaultPathwayId)
+	}
+	var altOrder []*Alternative
+	for _, alt := range p.Alternatives {
+		if groups[alt.Type+"/"+alt.GroupId] {
+			templateAlts[alt] = true
+		}
+		altOrder = append(altOrder, alt)
+	}
+	for _, v := range template {
+		altOrder = append(altOrder, v.Alternatives...)
+	}
+
+	// Check that the URIs can be rewritten for every pathway before changing the playlist
+	for _, pw := range pathways {
+		repl := URIReplacement{Host: pw.Host, Params: pw.Params}
+		for _, v := range template {
+			if _, err := repl.apply(p.BaseURL, v.URI); err != nil {
+				return err
+			}
+		}
+		for _, alt := range altOrder {
+			if !templateAlts[alt] {
+				continue
+			}
+			if _, err := repl.apply(p.BaseURL, alt.URI); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Assign stable IDs, which are copied to the clones
+	for i, v := range template {
+		if v.StableVariantId == "" {
+			v.StableVariantId = fmt.Sprintf("v%d", i+1)
+		}
+	}
+	n := 0
+	for _, alt := range altOrder {
+		if templateAlts[alt] && alt.StableRenditionId == "" {
+			n++
+			alt.StableRenditionId = fmt.Sprintf("r%d", n)
+		}
+	}
+
+	variants, alts := p.Variants, p.Alternatives
+	for _, pw := range pathways {
+		repl := URIReplacement{Host: pw.Host, Params: pw.Params}
+		err := p.clonePathway(DefaultPathwayId, pw.Id, func(kind URIKind, uri, stableId string) (string, error) {
+			uri, err := repl.apply(p.BaseURL, uri)
+			if err != nil {
+				return "", err
+			}
+			if uri == "" {
+				return uri, nil
+			}
+			return pw.Rewrite.apply(kind, uri), nil
+		})
+		if err != nil {
+			p.Variants, p.Alternatives = variants, alts
+			return err
+		}
+	}
+
+	var kept []*Variant
+	for _, v := range p.Variants {
+		if pathwayOf(v) != DefaultPathwayId {
+			kept = append(kept, v)
+		}
+	}
+	p.Variants = kept
+	var keptAlts []*Alternative
+	for _, alt := range p.Alternatives {
+		if !templateAlts[alt] {
+			keptAlts = append(keptAlts, alt)
+		}
+	}
+	p.Alternatives = keptAlts
+	p.ContentSteering = &ContentSteering{ServerURI: serverURI, PathwayId: pathways[0].Id}
+	p.buf.Reset()
+	return nil
+}
+
+// apply applies HOST and PARAMS to uri.
+func (repl URIReplacement) apply(base *url.URL, uri string) (string, error) {
+	if uri == "" || (repl.Host == "" && len(repl.Params) == 0) {
+		return uri, nil
+	}
+	if repl.Host != "" {
+		uri = resolveURI(base, uri)
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	if repl.Host != "" {
+		if u.Host == "" {
+			return "", fmt.Errorf("cannot replace host of relative URI %q", uri)
+		}
+		u.Host = repl.Host
+	}
+	if len(repl.Params) > 0 {
+		q := u.Query()
+		for k, v := range repl.Params {
+			q.Set(k, v)
+		}
+		u.RawQuery = q.Encode()
+	}
+	return u.String(), nil
+}
+
+// hasPathway reports whether a variant of the master playlist belongs to pathway id.
+func (p *MasterPlaylist) hasPathway(id string) bool {
+	for _, v := range p.Variants {
+		if pathwayOf(v) == id {
+			return true
+		}
+	}
+	return false
+}
+
+// pathwayOf returns the PATHWAY-ID of the variant.
+func pathwayOf(v *Variant) string {
+	if v.PathwayId == "" {
+		return DefaultPathwayId
+	}
+	return v.PathwayId
+}
+
+// clonePathway copies the variants of pathway baseId and the renditions they reference to
+// pathway id, with URIs provided by replace given the kind of URI and the stable ID.
+func (p *MasterPlaylist) clonePathway(baseId, id string,
+	replace func(kind URIKind, uri, stableId string) (string, error)) error {
+	var base []*Variant
+	for _, v := range p.Variants {
+		if pathwayOf(v) == baseId {
+			base = append(base, v)
+		}
+	}
+	if len(base) == 0 {
+		return fmt.Errorf("%w: %q", ErrPathwayNotFound, baseId)
+	}
+	groupId := func(group string) string {
+		if group == "" || group == "NONE" {
+			return group
+		}
+		return group + "-" + id
+	}
+	clones := make(map[*Alternative]*Alternative)
+	cloneAlt := func(alt *Alternative) (*Alternative, error) {
+		if c, ok := clones[alt]; ok {
+			return c, nil
+		}
+		c := *alt
+		c.GroupId = groupId(alt.GroupId)
+		c.Chunklist = nil
+		var err error
+		if c.URI, err = replace(URIRendition, alt.URI, alt.StableRenditionId); err != nil {
+			return nil, err
+		}
+		clones[alt] = &c
+		return &c, nil
+	}
+	var variants []*Variant
+	for _, v := range base {
+		c := *v
+		c.PathwayId = id
+		c.Chunklist = nil
+		c.Audio, c.Video, c.Subtitles = groupId(v.Audio), groupId(v.Video), groupId(v.Subtitles)
+		if v.Captions != "" && v.Captions != "NONE" {
+			c.Captions = groupId(v.Captions)
+		}
+		kind := URIVariant
+		if v.Iframe {
+			kind = URIIFrameVariant
+		}
+		var err error
+		if c.URI, err = replace(kind, v.URI, v.StableVariantId); err != nil {
+			return err
+		}
+		c.Alternatives = nil
+		for _, alt := range v.Alternatives {
+			altCopy, err := cloneAlt(alt)
+			if err != nil {
+				return err
+			}
+			c.Alternatives = append(c.Alternatives, altCopy)
+		}
+		variants = append(variants, &c)
+	}
+	// Renditions only listed in the playlist belong to the groups of the base variants
+	referenced := make(map[string]bool)
+	for _, v := range base {
+		referenced["AUDIO/"+v.Audio] = v.Audio != ""
+		referenced["VIDEO/"+v.Video] = v.Video != ""
+		referenced["SUBTITLES/"+v.Subtitles] = v.Subtitles != ""
+		referenced["CLOSED-CAPTIONS/"+v.Captions] = v.Captions != "" && v.Captions != "NONE"
+	}
+	var alts []*Alternative
+	for _, alt := range p.Alternatives {
+		if referenced[alt.Type+"/"+alt.GroupId] {
+			altCopy, err := cloneAlt(alt)
+			if err != nil {
+				return err
+			}
+			alts = append(alts, altCopy)
+		}
+	}
+	p.Variants = append(p.Variants, variants...)
+	p.Alternatives = append(p.Alternatives, alts...)
+	return nil
+}
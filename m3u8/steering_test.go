@@ -0,0 +1,198 @@
+package m3u8
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+const steeringTestManifest = `{
+  "VERSION": 1,
+  "TTL": 300,
+  "RELOAD-URI": "https://steering.example.com/manifest.json?session=abc",
+  "PATHWAY-PRIORITY": ["cdn-a", "cdn-b"],
+  "PATHWAY-CLONES": [{
+    "BASE-ID": "cdn-a",
+    "ID": "cdn-c",
+    "URI-REPLACEMENT": {
+      "HOST": "cdn-c.example.com",
+      "PARAMS": {"token": "xyz"},
+      "PER-VARIANT-URIS": {"hd": "https://cdn-d.example.com/hd/index.m3u8"}
+    }
+  }]
+}`
+
+func TestDecodeSteeringManifest(t *testing.T) {
+	is := is.New(t)
+	m, err := DecodeSteeringManifest(strings.NewReader(steeringTestManifest))
+	is.NoErr(err) // decode steering manifest
+	is.Equal(m.TTL, 300)
+	is.Equal(m.PathwayPriority, []string{"cdn-a", "cdn-b"})
+	is.Equal(len(m.PathwayClones), 1)
+	is.Equal(m.PathwayClones[0].URIReplacement.Params["token"], "xyz")
+
+	buf, err := m.Encode()
+	is.NoErr(err) // encode steering manifest
+	m2, err := DecodeSteeringManifest(buf)
+	is.NoErr(err)
+	is.Equal(m2, m) // round trip
+
+	for _, s := range []string{
+		`{"VERSION":2,"TTL":300,"PATHWAY-PRIORITY":["a"]}`,
+		`{"VERSION":1,"PATHWAY-PRIORITY":["a"]}`,
+		`{"VERSION":1,"TTL":300,"PATHWAY-PRIORITY":[]}`,
+		`{"VERSION":1,"TTL":300,"PATHWAY-PRIORITY":["a","a"]}`,
+		`{"VERSION":1,"TTL":300,"PATHWAY-PRIORITY":["a b"]}`,
+		`{"VERSION":1,"TTL":300,"PATHWAY-PRIORITY":["a"],"PATHWAY-CLONES":[{"BASE-ID":"x","ID":"b"}]}`,
+		`{"VERSION":1,`,
+	} {
+		_, err := DecodeSteeringManifest(strings.NewReader(s))
+		is.True(errors.Is(err, ErrInvalidSteeringManifest)) // invalid manifest must fail
+	}
+}
+
+func TestSteeringServer(t *testing.T) {
+	is := is.New(t)
+	m, err := DecodeSteeringManifest(strings.NewReader(steeringTestManifest))
+	is.NoErr(err)
+	var got SteeringRequest
+	s := &SteeringServer{Manifest: *m, Policy: SteeringPolicyFunc(func(req SteeringRequest, priority []string) []string {
+		got = req
+		if req.Throughput > 0 && req.Throughput < 1000000 {
+			return []string{"cdn-b", "cdn-a"}
+		}
+		return priority
+	})}
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/steering?_HLS_pathway=cdn-a&_HLS_throughput=500000", nil))
+	is.Equal(rec.Code, http.StatusOK)
+	is.Equal(rec.Header().Get("Content-Type"), "application/json")
+	is.Equal(got.Pathway, "cdn-a")           // pathway passed to policy
+	is.Equal(got.Throughput, uint64(500000)) // throughput passed to policy
+	resp, err := DecodeSteeringManifest(rec.Body)
+	is.NoErr(err)
+	is.Equal(resp.PathwayPriority, []string{"cdn-b", "cdn-a"}) // priority from policy
+	is.Equal(resp.ReloadURI, m.ReloadURI)
+	is.Equal(m.PathwayPriority, []string{"cdn-a", "cdn-b"}) // configured manifest not changed
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/steering", nil))
+	resp, err = DecodeSteeringManifest(rec.Body)
+	is.NoErr(err)
+	is.Equal(resp.PathwayPriority, []string{"cdn-a", "cdn-b"}) // default priority
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/steering?_HLS_throughput=fast", nil))
+	is.Equal(rec.Code, http.StatusBadRequest) // invalid throughput
+}
+
+const steeringTestMaster = `#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-CONTENT-STEERING:SERVER-URI="https://steering.example.com/manifest.json",PATHWAY-ID="cdn-a"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="English",LANGUAGE="en",DEFAULT=YES,STABLE-RENDITION-ID="en",URI="audio/en.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=800000,CODECS="avc1.64001e,mp4a.40.2",AUDIO="aac",PATHWAY-ID="cdn-a",STABLE-VARIANT-ID="sd"
+sd/index.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=2500000,CODECS="avc1.64001f,mp4a.40.2",AUDIO="aac",PATHWAY-ID="cdn-a",STABLE-VARIANT-ID="hd"
+hd/index.m3u8
+`
+
+func TestApplyPathwayClones(t *testing.T) {
+	is := is.New(t)
+	p, _, err := DecodeFrom(strings.NewReader(steeringTestMaster), true)
+	is.NoErr(err)
+	master := p.(*MasterPlaylist)
+	master.BaseURL, _ = url.Parse("https://cdn-a.example.com/vod/master.m3u8")
+	m, err := DecodeSteeringManifest(strings.NewReader(steeringTestManifest))
+	is.NoErr(err)
+
+	is.NoErr(master.ApplyPathwayClones(m.PathwayClones)) // apply clones
+	is.Equal(len(master.Variants), 4)
+	sd, hd := master.Variants[2], master.Variants[3]
+	is.Equal(sd.PathwayId, "cdn-c")
+	is.Equal(sd.URI, "https://cdn-c.example.com/vod/sd/index.m3u8?token=xyz") // HOST and PARAMS applied
+	is.Equal(hd.URI, "https://cdn-d.example.com/hd/index.m3u8")               // PER-VARIANT-URIS applied
+	is.Equal(sd.StableVariantId, "sd")
+	is.Equal(sd.Audio, "aac-cdn-c")
+	is.Equal(sd.Alternatives[0], hd.Alternatives[0]) // renditions shared between cloned variants
+	is.Equal(sd.Alternatives[0].URI, "https://cdn-c.example.com/vod/audio/en.m3u8?token=xyz")
+	is.Equal(master.Variants[0].URI, "sd/index.m3u8") // base pathway unchanged
+	is.Equal(master.Variants[0].Alternatives[0].GroupId, "aac")
+
+	s := master.String()
+	is.Equal(strings.Count(s, "#EXT-X-MEDIA:"), 2) // one cloned rendition
+	is.True(strings.Contains(s, `GROUP-ID="aac-cdn-c"`))
+	is.True(strings.Contains(s, `PATHWAY-ID="cdn-c"`))
+
+	is.NoErr(master.ApplyPathwayClones(m.PathwayClones)) // existing clone is skipped
+	is.Equal(len(master.Variants), 4)
+
+	err = master.ApplyPathwayClones([]PathwayClone{{BaseId: "cdn-x", Id: "cdn-y"}})
+	is.True(errors.Is(err, ErrPathwayNotFound)) // unknown base pathway
+}
+
+func TestAddPathways(t *testing.T) {
+	is := is.New(t)
+	p, _, err := DecodeFrom(strings.NewReader(abrTestMaster), true)
+	is.NoErr(err)
+	master := p.(*MasterPlaylist)
+	master.BaseURL, _ = url.Parse("https://origin.example.com/vod/master.m3u8")
+
+	err = master.AddPathways("https://steering.example.com/manifest.json", []Pathway{
+		{Id: "cdn-a", Host: "cdn-a.example.com"},
+		{Id: "cdn-b", Host: "cdn-b.example.com", Params: map[string]string{"token": "xyz"},
+			Rewrite: func(kind URIKind, uri string) string {
+				if kind == URIIFrameVariant {
+					return strings.Replace(uri, "/vod/", "/trick/", 1)
+				}
+				return uri
+			}},
+	})
+	is.NoErr(err) // add pathways
+	is.Equal(len(master.Variants), 12)
+	is.Equal(len(master.Alternatives), 6)
+	is.Equal(*master.ContentSteering, ContentSteering{ServerURI: "https://steering.example.com/manifest.json", PathwayId: "cdn-a"})
+
+	a, b := master.Variants[0], master.Variants[6]
+	is.Equal(a.PathwayId, "cdn-a")
+	is.Equal(b.PathwayId, "cdn-b")
+	is.Equal(a.URI, "https://cdn-a.example.com/vod/360p.m3u8")
+	is.Equal(b.URI, "https://cdn-b.example.com/vod/360p.m3u8?token=xyz")
+	is.Equal(a.StableVariantId, "v1") // generated stable ID
+	is.Equal(b.StableVariantId, "v1") // same stable ID in every pathway
+	is.Equal(a.Audio, "aac-cdn-a")
+	is.Equal(master.Variants[11].URI, "https://cdn-b.example.com/trick/iframes.m3u8?token=xyz") // Rewrite by kind
+	is.Equal(a.Alternatives[0].StableRenditionId, b.Alternatives[0].StableRenditionId)
+	is.Equal(a.Alternatives[0].GroupId, "aac-cdn-a")
+	is.Equal(b.Alternatives[0].URI, "https://cdn-b.example.com/vod/audio/en.m3u8?token=xyz")
+
+	s := master.String()
+	is.Equal(strings.Count(s, "#EXT-X-MEDIA:"), 6)                     // renditions per pathway
+	is.True(!strings.Contains(s, `GROUP-ID="aac"`))                    // original groups replaced
+	is.True(strings.Contains(s, `#EXT-X-CONTENT-STEERING:SERVER-URI`)) // steering tag
+
+	err = master.AddPathways("https://steering.example.com/manifest.json", []Pathway{{Id: "cdn-c"}})
+	is.True(errors.Is(err, ErrPathwayNotFound)) // no variants without pathway left
+	err = master.AddPathways("https://steering.example.com/manifest.json", []Pathway{{Id: "cdn-a"}})
+	is.True(errors.Is(err, ErrInvalidPathway)) // existing pathway
+
+	p, _, err = DecodeFrom(strings.NewReader(abrTestMaster), true)
+	is.NoErr(err)
+	master = p.(*MasterPlaylist)
+	err = master.AddPathways("/steering.json", []Pathway{{Id: "cdn-a"}, {Id: "cdn-b", Host: "cdn-b.example.com"}})
+	is.True(err != nil)               // host of relative URI without BaseURL
+	is.Equal(len(master.Variants), 6) // variants unchanged on error
+	is.Equal(master.Variants[0].URI, "360p.m3u8")
+	is.Equal(master.ContentSteering, (*ContentSteering)(nil))
+	for _, v := range master.Variants {
+		is.Equal(v.StableVariantId, "") // no stable IDs assigned on error
+	}
+	for _, alt := range master.Alternatives {
+		is.Equal(alt.StableRenditionId, "") // no stable IDs assigned on error
+	}
+}
@@ -7,6 +7,7 @@ package m3u8
 import (
 	"bytes"
 	"io"
+	"net/url"
 	"time"
 )
 
@@ -125,6 +126,7 @@ type MediaPlaylist struct {
 	SeqNo               uint64            // EXT-X-MEDIA-SEQUENCE
 	Segments            []*MediaSegment   // List of segments in the playlist. Output may be limited by winsize.
 	Args                string            // optional query placed after URIs (URI?Args)
+	BaseURL             *url.URL          // optional location of the playlist, used to resolve relative URIs
 	Defines             []Define          // EXT-X-DEFINE tags
 	Iframe              bool              // EXT-X-I-FRAMES-ONLY
 	Closed              bool              // is this VOD/EVENT (closed) or Live (sliding) playlist?
@@ -139,6 +141,8 @@ type MediaPlaylist struct {
 	Custom              CustomMap         // Custom-provided tags for encoding
 	customDecoders      []CustomDecoder   // customDecoders provides custom tags for decoding
 	winsize             uint              // max number of segments encoded sliding playlist, set to 0 for VOD and EVENT
+	winDuration         float64           // duration in seconds of sliding window, overrides winsize if > 0
+	winMinTargetDurs    uint              // minimum sliding window in number of target durations
 	capacity            uint              // total capacity of slice used for the playlist
 	head                uint              // head of FIFO, we add segments to head
 	tail                uint              // tail of FIFO, we remove segments from tail
@@ -155,6 +159,7 @@ type MediaPlaylist struct {
 	ServerControl       *ServerControl    // EXT-X-SERVER-CONTROL tags, MAY appear in any Media Playlist
 	skippedSegments     uint64            // EXT-X-SKIP:SKIPPED-SEGMENTS tag parsed from the playlist. Read-only
 	writePrecision      int               // Output decimal places for float values (-1 provides necessary number)
+	keyRotation         *KeyRotation      // keyRotation adds keys to appended segments if set
 }
 
 // MasterPlaylist represents a master (multivariant) playlist which
@@ -163,6 +168,7 @@ type MediaPlaylist struct {
 type MasterPlaylist struct {
 	Variants            []*Variant       // Variants is a list of media playlists
 	Args                string           // optional query placed after URI (URI?Args)
+	BaseURL             *url.URL         // optional location of the playlist, used to resolve relative URIs
 	StartTime           float64          // EXT-X-START:TIME-OFFSET=<n> (positive or negative)
 	StartTimePrecise    bool             // EXT-X-START:PRECISE=YES
 	Defines             []Define         // EXT-X-DEFINE tags
@@ -181,7 +187,7 @@ type MasterPlaylist struct {
 // Variant structure represents media playlist variants in master playlists.
 type Variant struct {
 	URI       string         // URI is the path to the media playlist. Parameter for I-frame playlist.
-	Chunklist *MediaPlaylist // Chunklist is the media playlist for the variant.
+	Chunklist *MediaPlaylist // Chunklist is the media playlist for the variant. Set by LoadPresentation
 	VariantParams
 }
 
@@ -215,21 +221,22 @@ type VariantParams struct {
 // Alternative represents an EXT-X-MEDIA tag.
 // Attributes are listed in same order as in specification for easy comparison.
 type Alternative struct {
-	Type              string    // TYPE parameter
-	URI               string    // URI parameter
-	GroupId           string    // GROUP-ID parameter
-	Language          string    // LANGUAGE parameter
-	AssocLanguage     string    // ASSOC-LANGUAGE parameter
-	Name              string    // NAME parameter
-	StableRenditionId string    // STABLE-RENDITION-ID parameter
-	Default           bool      // DEFAULT parameter
-	Autoselect        bool      // AUTOSELECT parameter
-	Forced            bool      // FORCED parameter
-	InstreamId        string    // INSTREAM-ID parameter
-	BitDepth          byte      // BIT-DEPTH parameter
-	SampleRate        uint32    // SAMPLE-RATE parameter
-	Characteristics   string    // CHARACTERISTICS parameter
-	Channels          *Channels // CHANNELS parameter
+	Type              string         // TYPE parameter
+	URI               string         // URI parameter
+	GroupId           string         // GROUP-ID parameter
+	Language          string         // LANGUAGE parameter
+	AssocLanguage     string         // ASSOC-LANGUAGE parameter
+	Name              string         // NAME parameter
+	StableRenditionId string         // STABLE-RENDITION-ID parameter
+	Default           bool           // DEFAULT parameter
+	Autoselect        bool           // AUTOSELECT parameter
+	Forced            bool           // FORCED parameter
+	InstreamId        string         // INSTREAM-ID parameter
+	BitDepth          byte           // BIT-DEPTH parameter
+	SampleRate        uint32         // SAMPLE-RATE parameter
+	Characteristics   string         // CHARACTERISTICS parameter
+	Channels          *Channels      // CHANNELS parameter
+	Chunklist         *MediaPlaylist // Chunklist is the media playlist for the rendition. Set by LoadPresentation
 }
 
 type Channels struct {
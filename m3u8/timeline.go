@@ -0,0 +1,163 @@
+package m3u8
+
+/*
+ This file defines the media timeline of a media playlist with interpolated program date times.
+*/
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrNotInTimeline = errors.New("time is outside the playlist")
+
+// Timeline is the media timeline of a media playlist as computed by MediaPlaylist.Timeline.
+type Timeline struct {
+	Segments []*TimelineSegment // Segments are in playlist order, followed by the segment in progress, if any
+	Duration time.Duration      // Duration is the total duration of all segments and partial segments
+}
+
+// TimelineSegment is a media segment on the timeline.
+type TimelineSegment struct {
+	// Segment is the media segment, or nil for the segment in progress
+	// of a low-latency playlist that only has partial segments yet.
+	Segment  *MediaSegment
+	SeqId    uint64        // SeqId is the media sequence number
+	Start    time.Duration // Start is the offset from the start of the first segment
+	Duration time.Duration // Duration is the duration of the segment, or the sum of its parts if in progress
+	// Discontinuity is the discontinuity sequence number of the segment, which is
+	// EXT-X-DISCONTINUITY-SEQUENCE plus the EXT-X-DISCONTINUITY tags up to the segment.
+	Discontinuity uint64
+	// ProgramDateTime is the program date time of the segment, taken from EXT-X-PROGRAM-DATE-TIME or
+	// interpolated from the nearest segment with one before or after it in the same discontinuity
+	// sequence. It is zero if no segment in the discontinuity sequence has a program date time.
+	ProgramDateTime time.Time
+	Parts           []*TimelinePart // Parts are the partial segments of the segment
+}
+
+// TimelinePart is a partial segment on the timeline.
+type TimelinePart struct {
+	Part            *PartialSegment // Part is the partial segment
+	Start           time.Duration   // Start is the offset from the start of the first segment
+	Duration        time.Duration   // Duration is the duration of the partial segment
+	ProgramDateTime time.Time       // ProgramDateTime is the explicit or interpolated program date time
+}
+
+// End returns the end offset of the segment.
+func (s *TimelineSegment) End() time.Duration {
+	return s.Start + s.Duration
+}
+
+// Timeline computes the start offset, discontinuity sequence number and program date time of every
+// segment and partial segment of the playlist. Offsets are relative to the start of the first segment.
+func (p *MediaPlaylist) Timeline() *Timeline {
+	t := &Timeline{}
+	for _, st := range p.segmentStates() {
+		seg := st.seg
+		t.Segments = append(t.Segments, &TimelineSegment{
+			Segment:         seg,
+			SeqId:           seg.SeqId,
+			Start:           seconds(st.start),
+			Duration:        seconds(seg.Duration),
+			Discontinuity:   st.disc,
+			ProgramDateTime: st.pdt,
+		})
+	}
+	backfillProgramDateTimes(t.Segments)
+	if n := len(t.Segments); n > 0 {
+		t.Duration = t.Segments[n-1].End()
+	}
+
+	// Attach partial segments, and add the segment in progress for parts after the last segment
+	bySeqId := make(map[uint64]*TimelineSegment)
+	for _, s := range t.Segments {
+		bySeqId[s.SeqId] = s
+	}
+	for _, ps := range p.PartialSegments {
+		s, ok := bySeqId[ps.SeqID]
+		if !ok {
+			if len(t.Segments) == 0 || ps.SeqID != t.Segments[len(t.Segments)-1].SeqId+1 {
+				continue
+			}
+			last := t.Segments[len(t.Segments)-1]
+			s = &TimelineSegment{SeqId: ps.SeqID, Start: last.End(), Discontinuity: last.Discontinuity}
+			if !last.ProgramDateTime.IsZero() {
+				s.ProgramDateTime = last.ProgramDateTime.Add(last.Duration)
+			}
+			t.Segments = append(t.Segments, s)
+			bySeqId[ps.SeqID] = s
+		}
+		part := &TimelinePart{Part: ps, Start: s.Start, Duration: seconds(ps.Duration)}
+		if n := len(s.Parts); n > 0 {
+			part.Start = s.Parts[n-1].Start + s.Parts[n-1].Duration
+		}
+		switch {
+		case !ps.ProgramDateTime.IsZero():
+			part.ProgramDateTime = ps.ProgramDateTime
+			if s.Segment == nil && s.ProgramDateTime.IsZero() {
+				s.ProgramDateTime = ps.ProgramDateTime.Add(s.Start - part.Start)
+			}
+		case !s.ProgramDateTime.IsZero():
+			part.ProgramDateTime = s.ProgramDateTime.Add(part.Start - s.Start)
+		}
+		s.Parts = append(s.Parts, part)
+		if s.Segment == nil {
+			s.Duration = part.Start + part.Duration - s.Start
+			t.Duration = s.End()
+		}
+	}
+	return t
+}
+
+// backfillProgramDateTimes sets the program date time of segments before the first segment with
+// a program date time in the same discontinuity sequence.
+func backfillProgramDateTimes(segs []*TimelineSegment) {
+	for i := len(segs) - 2; i >= 0; i-- {
+		next := segs[i+1]
+		if segs[i].ProgramDateTime.IsZero() && !next.ProgramDateTime.IsZero() && !next.Segment.Discontinuity {
+			segs[i].ProgramDateTime = next.ProgramDateTime.Add(-segs[i].Duration)
+		}
+	}
+}
+
+// SegmentAt returns the segment containing offset, and the partial segment containing it if the
+// segment has parts. Offsets are relative to the start of the first segment.
+// If offset is outside the timeline, ErrNotInTimeline is returned.
+func (t *Timeline) SegmentAt(offset time.Duration) (*TimelineSegment, *TimelinePart, error) {
+	for _, s := range t.Segments {
+		if offset >= s.Start && offset < s.End() {
+			return s, partAt(s, offset), nil
+		}
+	}
+	return nil, nil, ErrNotInTimeline
+}
+
+// SegmentAtTime returns the segment with the program date time range containing tm, and the
+// partial segment containing it if the segment has parts. Segments without a program date time
+// are not considered. If no segment contains tm, ErrNotInTimeline is returned.
+func (t *Timeline) SegmentAtTime(tm time.Time) (*TimelineSegment, *TimelinePart, error) {
+	for _, s := range t.Segments {
+		if s.ProgramDateTime.IsZero() {
+			continue
+		}
+		if !tm.Before(s.ProgramDateTime) && tm.Before(s.ProgramDateTime.Add(s.Duration)) {
+			return s, partAt(s, s.Start+tm.Sub(s.ProgramDateTime)), nil
+		}
+	}
+	return nil, nil, ErrNotInTimeline
+}
+
+// partAt returns the partial segment of s containing offset, or nil.
+func partAt(s *TimelineSegment, offset time.Duration) *TimelinePart {
+	for _, part := range s.Parts {
+		if offset >= part.Start && offset < part.Start+part.Duration {
+			return part
+		}
+	}
+	return nil
+}
+
+// seconds converts seconds to a duration.
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,81 @@
+package m3u8
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestTimelineLowLatency(t *testing.T) {
+	is := is.New(t)
+	p, err := readTestMediaPlaylist(t, "sample-playlists/media-playlist-low-latency.m3u8")
+	is.NoErr(err)
+	tl := p.Timeline()
+	is.Equal(len(tl.Segments), 9)         // 8 segments and the segment in progress
+	is.Equal(tl.Duration, 34*time.Second) // 8 segments of 4s and 2 parts of 1s
+	pdt := time.Date(2025, 2, 10, 14, 43, 10, 134000000, time.UTC)
+
+	first := tl.Segments[0]
+	is.Equal(first.Start, time.Duration(0))
+	is.True(first.ProgramDateTime.Equal(pdt.Add(-12 * time.Second))) // interpolated backwards
+
+	s, part, err := tl.SegmentAt(21 * time.Second)
+	is.NoErr(err)
+	is.Equal(s.Segment.URI, "fileSequence248.m4s")
+	is.Equal(part, (*TimelinePart)(nil)) // no parts
+	is.True(s.ProgramDateTime.Equal(pdt.Add(8 * time.Second)))
+
+	s, part, err = tl.SegmentAt(26500 * time.Millisecond)
+	is.NoErr(err)
+	is.Equal(s.Segment.URI, "fileSequence249.m4s")
+	is.Equal(part.Part.URI, "filePart249.3.m4s") // partial segment at offset
+	is.Equal(part.Start, 26*time.Second)
+
+	s, part, err = tl.SegmentAtTime(pdt.Add(21500 * time.Millisecond))
+	is.NoErr(err)
+	is.Equal(s.Segment, (*MediaSegment)(nil)) // segment in progress
+	is.Equal(s.SeqId, tl.Segments[7].SeqId+1)
+	is.Equal(part.Part.URI, "filePart251.2.m4s")
+	is.True(part.ProgramDateTime.Equal(pdt.Add(21 * time.Second)))
+
+	_, _, err = tl.SegmentAt(34 * time.Second)
+	is.True(errors.Is(err, ErrNotInTimeline)) // after the end
+	_, _, err = tl.SegmentAtTime(pdt.Add(-time.Hour))
+	is.True(errors.Is(err, ErrNotInTimeline)) // before the start
+}
+
+func TestTimelineDiscontinuities(t *testing.T) {
+	is := is.New(t)
+	p, err := NewMediaPlaylist(0, 5)
+	is.NoErr(err)
+	p.DiscontinuitySeq = 3
+	pdt := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	is.NoErr(p.Append("a0.ts", 6, ""))
+	is.NoErr(p.SetProgramDateTime(pdt))
+	is.NoErr(p.Append("a1.ts", 6, ""))
+	is.NoErr(p.Append("b0.ts", 4, ""))
+	is.NoErr(p.SetDiscontinuity())
+	is.NoErr(p.Append("b1.ts", 4, ""))
+	is.NoErr(p.Append("c0.ts", 4, ""))
+	is.NoErr(p.SetDiscontinuity())
+	is.NoErr(p.SetProgramDateTime(pdt.Add(time.Hour)))
+
+	tl := p.Timeline()
+	var discs []uint64
+	for _, s := range tl.Segments {
+		discs = append(discs, s.Discontinuity)
+	}
+	is.Equal(discs, []uint64{3, 3, 4, 4, 5}) // discontinuity sequence numbers
+	is.True(tl.Segments[1].ProgramDateTime.Equal(pdt.Add(6 * time.Second)))
+	is.True(tl.Segments[2].ProgramDateTime.IsZero()) // reset at discontinuity
+	is.True(tl.Segments[3].ProgramDateTime.IsZero())
+	is.Equal(tl.Segments[4].Start, 20*time.Second)
+
+	s, _, err := tl.SegmentAtTime(pdt.Add(time.Hour + time.Second))
+	is.NoErr(err)
+	is.Equal(s.Segment.URI, "c0.ts")
+	_, _, err = tl.SegmentAtTime(pdt.Add(13 * time.Second))
+	is.True(errors.Is(err, ErrNotInTimeline)) // no program date time after discontinuity
+}
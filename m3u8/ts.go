@@ -0,0 +1,245 @@
+package m3u8
+
+/*
+ This file defines functions for generating a media playlist from MPEG-TS segment files.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+var ErrInvalidTS = errors.New("invalid MPEG-TS data")
+var ErrNoPTS = errors.New("no PES packet with PTS found")
+
+const (
+	tsPacketSize = 188
+	tsTimescale  = 90000
+	ptsWrap      = 1 << 33
+)
+
+// TSScanOptions provides options for MediaPlaylistFromTS.
+type TSScanOptions struct {
+	// ProgramDateTime is set as EXT-X-PROGRAM-DATE-TIME of the first segment and of each
+	// segment after a discontinuity, counting on from the previous segments. Zero disables it.
+	ProgramDateTime time.Time
+	// MaxPTSGap is the largest gap in seconds between the end of a segment and the start
+	// of the next one that is not signalled as discontinuity. Defaults to 1 second.
+	MaxPTSGap float64
+}
+
+// naturalLess compares names with runs of digits compared by numeric value.
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		da, db := digitPrefix(a), digitPrefix(b)
+		if da == 0 || db == 0 {
+			if a[0] != b[0] {
+				return a[0] < b[0]
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		na, nb := strings.TrimLeft(a[:da], "0"), strings.TrimLeft(b[:db], "0")
+		if len(na) != len(nb) {
+			return len(na) < len(nb)
+		}
+		if na != nb {
+			return na < nb
+		}
+		a, b = a[da:], b[db:]
+	}
+	return len(a) < len(b)
+}
+
+// digitPrefix returns the number of leading ASCII digits of s.
+func digitPrefix(s string) int {
+	n := 0
+	for n < len(s) && s[n] >= '0' && s[n] <= '9' {
+		n++
+	}
+	return n
+}
+
+// tsTiming is the presentation time range of one MPEG-TS file in 90 kHz ticks.
+type tsTiming struct {
+	first    int64 // lowest PTS
+	last     int64 // highest PTS
+	frameDur int64 // smallest distance between two PTS values, 0 if unknown
+}
+
+// MediaPlaylistFromTS scans the .ts files in dir of fsys in natural name order, where numbers
+// in names are compared by value so that seg2.ts comes before seg10.ts, and returns a closed
+// VOD media playlist with one segment per file. URIs are the file names.
+//
+// Durations are calculated from the PES PTS values of the first video stream, or of the
+// first stream with PTS if there is no video. A segment lasts until the first PTS of the
+// next segment, unless there is a PTS jump, in which case the next segment is marked with
+// EXT-X-DISCONTINUITY and the duration is calculated from the PTS range of the segment itself.
+func MediaPlaylistFromTS(fsys fs.FS, dir string, opts TSScanOptions) (*MediaPlaylist, error) {
+	if opts.MaxPTSGap <= 0 {
+		opts.MaxPTSGap = 1
+	}
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return naturalLess(entries[i].Name(), entries[j].Name()) })
+	var names []string
+	var timings []tsTiming
+	for _, e := range entries {
+		if e.IsDir() || !strings.EqualFold(path.Ext(e.Name()), ".ts") {
+			continue
+		}
+		data, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		t, err := scanTSTiming(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", e.Name(), err)
+		}
+		names = append(names, e.Name())
+		timings = append(timings, t)
+	}
+	if len(names) == 0 {
+		return nil, ErrPlaylistEmpty
+	}
+
+	p, err := NewMediaPlaylist(0, uint(len(names)))
+	if err != nil {
+		return nil, err
+	}
+	maxGap := int64(opts.MaxPTSGap * tsTimescale)
+	pdt := opts.ProgramDateTime
+	discontinuity := false
+	for i, t := range timings {
+		dur := t.last - t.first + t.frameDur
+		nextDiscontinuity := false
+		if i+1 < len(timings) {
+			next := timings[i+1].first
+			if gap := ptsDiff(next, t.last+t.frameDur); gap > maxGap || gap < -maxGap {
+				nextDiscontinuity = true
+			} else {
+				dur = ptsDiff(next, t.first)
+			}
+		}
+		seg := &MediaSegment{
+			URI:           names[i],
+			Duration:      float64(dur) / tsTimescale,
+			Discontinuity: discontinuity,
+		}
+		if !pdt.IsZero() && (i == 0 || discontinuity) {
+			seg.ProgramDateTime = pdt
+		}
+		if err := p.AppendSegment(seg); err != nil {
+			return nil, err
+		}
+		if !pdt.IsZero() {
+			pdt = pdt.Add(time.Duration(dur) * time.Second / tsTimescale)
+		}
+		discontinuity = nextDiscontinuity
+	}
+	finishVOD(p)
+	return p, nil
+}
+
+// scanTSTiming returns the PTS range of the first video stream in data, or of the
+// first stream with PTS if there is no video stream.
+func scanTSTiming(data []byte) (tsTiming, error) {
+	if len(data) == 0 || len(data)%tsPacketSize != 0 {
+		return tsTiming{}, fmt.Errorf("%w: size %d is not a multiple of %d", ErrInvalidTS, len(data), tsPacketSize)
+	}
+	ptsByPID := make(map[uint16][]int64)
+	var pids []uint16
+	videoPID, hasVideo := uint16(0), false
+	for offset := 0; offset < len(data); offset += tsPacketSize {
+		pkt := data[offset : offset+tsPacketSize]
+		if pkt[0] != 0x47 {
+			return tsTiming{}, fmt.Errorf("%w: no sync byte at offset %d", ErrInvalidTS, offset)
+		}
+		if pkt[1]&0x40 == 0 { // no payload unit start
+			continue
+		}
+		pid := uint16(pkt[1]&0x1f)<<8 | uint16(pkt[2])
+		payload := 4
+		switch (pkt[3] >> 4) & 0x3 {
+		case 0, 2: // no payload
+			continue
+		case 3: // adaptation field and payload
+			payload += 1 + int(pkt[4])
+		}
+		if payload >= tsPacketSize {
+			continue
+		}
+		streamID, pts, ok := parsePESPTS(pkt[payload:])
+		if !ok {
+			continue
+		}
+		if _, seen := ptsByPID[pid]; !seen {
+			pids = append(pids, pid)
+		}
+		ptsByPID[pid] = append(ptsByPID[pid], pts)
+		if !hasVideo && streamID&0xf0 == 0xe0 {
+			videoPID, hasVideo = pid, true
+		}
+	}
+	if len(pids) == 0 {
+		return tsTiming{}, ErrNoPTS
+	}
+	if !hasVideo {
+		videoPID = pids[0]
+	}
+	return ptsTiming(ptsByPID[videoPID]), nil
+}
+
+// parsePESPTS returns the stream id and PTS of a PES packet header.
+func parsePESPTS(b []byte) (streamID byte, pts int64, ok bool) {
+	if len(b) < 14 || b[0] != 0 || b[1] != 0 || b[2] != 1 {
+		return 0, 0, false
+	}
+	streamID = b[3]
+	if b[7]&0x80 == 0 { // no PTS
+		return streamID, 0, false
+	}
+	pts = int64(b[9]>>1&0x07)<<30 | int64(b[10])<<22 | int64(b[11]>>1)<<15 | int64(b[12])<<7 | int64(b[13]>>1)
+	return streamID, pts, true
+}
+
+// ptsTiming unwraps 33-bit PTS values in decoding order and returns their range.
+func ptsTiming(values []int64) tsTiming {
+	unwrapped := make([]int64, len(values))
+	unwrapped[0] = values[0]
+	for i := 1; i < len(values); i++ {
+		unwrapped[i] = unwrapped[i-1] + ptsDiff(values[i], values[i-1])
+	}
+	sort.Slice(unwrapped, func(i, j int) bool { return unwrapped[i] < unwrapped[j] })
+	t := tsTiming{first: unwrapped[0], last: unwrapped[len(unwrapped)-1]}
+	for i := 1; i < len(unwrapped); i++ {
+		if d := unwrapped[i] - unwrapped[i-1]; d > 0 && (t.frameDur == 0 || d < t.frameDur) {
+			t.frameDur = d
+		}
+	}
+	t.first %= ptsWrap
+	if t.first < 0 {
+		t.first += ptsWrap
+	}
+	t.last = t.first + unwrapped[len(unwrapped)-1] - unwrapped[0]
+	return t
+}
+
+// ptsDiff returns a - b for 33-bit PTS values, taking wrap-around into account.
+func ptsDiff(a, b int64) int64 {
+	d := (a - b) % ptsWrap
+	switch {
+	case d >= ptsWrap/2:
+		d -= ptsWrap
+	case d < -ptsWrap/2:
+		d += ptsWrap
+	}
+	return d
+}
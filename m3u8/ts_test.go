@@ -0,0 +1,109 @@
+package m3u8
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+// tsPESPacket returns a TS packet starting a PES packet with the given PTS.
+func tsPESPacket(pid uint16, streamID byte, pts int64) []byte {
+	pkt := make([]byte, tsPacketSize)
+	for i := range pkt {
+		pkt[i] = 0xff
+	}
+	pkt[0] = 0x47
+	pkt[1] = 0x40 | byte(pid>>8)&0x1f
+	pkt[2] = byte(pid)
+	pkt[3] = 0x10
+	pts %= ptsWrap
+	copy(pkt[4:], []byte{0, 0, 1, streamID, 0, 0, 0x80, 0x80, 0x05,
+		0x21 | byte(pts>>30&0x07)<<1, byte(pts >> 22), byte(pts>>15&0x7f)<<1 | 1, byte(pts >> 7), byte(pts&0x7f)<<1 | 1})
+	return pkt
+}
+
+// tsSegment returns a TS segment with 50 video frames at 25 fps starting at pts,
+// and an audio stream with other PTS values.
+func tsSegment(pts int64) []byte {
+	var data []byte
+	for i := int64(0); i < 50; i++ {
+		data = append(data, tsPESPacket(0x101, 0xe0, pts+i*3600)...)
+		if i%10 == 0 {
+			data = append(data, tsPESPacket(0x102, 0xc0, pts+i*3600+1000)...)
+		}
+	}
+	return data
+}
+
+func TestMediaPlaylistFromTS(t *testing.T) {
+	is := is.New(t)
+	fsys := fstest.MapFS{
+		"vod/seg0.ts":     &fstest.MapFile{Data: tsSegment(90000)},
+		"vod/seg1.ts":     &fstest.MapFile{Data: tsSegment(270000)},
+		"vod/seg2.ts":     &fstest.MapFile{Data: tsSegment(ptsWrap - 270000)},
+		"vod/seg3.ts":     &fstest.MapFile{Data: tsSegment(ptsWrap - 90000)},
+		"vod/seg4.ts":     &fstest.MapFile{Data: tsSegment(90000)},
+		"vod/index.m3u8":  &fstest.MapFile{Data: []byte("#EXTM3U\n")},
+		"vod/sub/seg5.ts": &fstest.MapFile{Data: tsSegment(0)},
+	}
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	p, err := MediaPlaylistFromTS(fsys, "vod", TSScanOptions{ProgramDateTime: start})
+	is.NoErr(err) // scan segments
+	segs := p.GetAllSegments()
+	is.Equal(len(segs), 5) // only .ts files in dir
+	for i, seg := range segs {
+		is.Equal(seg.Duration, 2.0)         // segment duration
+		is.Equal(seg.Discontinuity, i == 2) // discontinuity only at PTS jump
+	}
+	is.Equal(segs[0].URI, "seg0.ts")
+	is.Equal(segs[0].ProgramDateTime, start)                    // first program date time
+	is.Equal(segs[2].ProgramDateTime, start.Add(4*time.Second)) // program date time after discontinuity
+	is.True(segs[1].ProgramDateTime.IsZero())                   // program date time only when needed
+	is.Equal(p.TargetDuration, uint(2))                         // target duration
+	is.True(p.Closed)                                           // VOD playlist must be closed
+	is.Equal(p.MediaType, VOD)                                  // playlist type
+}
+
+func TestMediaPlaylistFromTSNaturalOrder(t *testing.T) {
+	is := is.New(t)
+	fsys := fstest.MapFS{}
+	for i := 0; i < 12; i++ {
+		fsys[fmt.Sprintf("seg%d.ts", i)] = &fstest.MapFile{Data: tsSegment(90000 + int64(i)*180000)}
+	}
+	p, err := MediaPlaylistFromTS(fsys, ".", TSScanOptions{})
+	is.NoErr(err) // scan segments
+	segs := p.GetAllSegments()
+	is.Equal(len(segs), 12)
+	for i, seg := range segs {
+		is.Equal(seg.URI, fmt.Sprintf("seg%d.ts", i)) // numeric order
+		is.True(!seg.Discontinuity)                   // no PTS jump
+	}
+}
+
+func TestNaturalLess(t *testing.T) {
+	is := is.New(t)
+	is.True(naturalLess("seg2.ts", "seg10.ts"))  // numbers by value
+	is.True(!naturalLess("seg10.ts", "seg2.ts")) // numbers by value
+	is.True(naturalLess("a9b", "a10a"))          // first number decides
+	is.True(naturalLess("a1b", "a1c"))           // text after equal numbers
+	is.True(naturalLess("seg", "seg0"))          // prefix first
+	is.True(!naturalLess("x007", "x7"))          // leading zeros ignored
+}
+
+func TestMediaPlaylistFromTSErrors(t *testing.T) {
+	is := is.New(t)
+	_, err := MediaPlaylistFromTS(fstest.MapFS{"seg0.ts": &fstest.MapFile{Data: []byte{0x47}}}, ".", TSScanOptions{})
+	is.True(errors.Is(err, ErrInvalidTS)) // truncated packet must fail
+
+	noPTS := tsPESPacket(0x101, 0xe0, 0)
+	noPTS[11] = 0 // clear PTS flag
+	_, err = MediaPlaylistFromTS(fstest.MapFS{"seg0.ts": &fstest.MapFile{Data: noPTS}}, ".", TSScanOptions{})
+	is.True(errors.Is(err, ErrNoPTS)) // missing PTS must fail
+
+	_, err = MediaPlaylistFromTS(fstest.MapFS{"a.txt": &fstest.MapFile{}}, ".", TSScanOptions{})
+	is.True(errors.Is(err, ErrPlaylistEmpty)) // no segments must fail
+}
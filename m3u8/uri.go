@@ -0,0 +1,281 @@
+package m3u8
+
+/*
+ This file defines functions for resolving, relativizing and rewriting URIs in playlists.
+*/
+
+import (
+	"net/url"
+	"strings"
+)
+
+// URIKind identifies the kind of URI passed to a URIRewriter.
+type URIKind uint
+
+const (
+	URISegment         URIKind = iota // URI of a media segment
+	URIPartialSegment                 // URI of EXT-X-PART
+	URIMap                            // URI of EXT-X-MAP
+	URIKey                            // URI of EXT-X-KEY
+	URIPreloadHint                    // URI of EXT-X-PRELOAD-HINT
+	URIAsset                          // X-ASSET-URI or X-ASSET-LIST of an interstitial EXT-X-DATERANGE
+	URIVariant                        // URI of EXT-X-STREAM-INF
+	URIIFrameVariant                  // URI of EXT-X-I-FRAME-STREAM-INF
+	URIRendition                      // URI of EXT-X-MEDIA
+	URISessionData                    // URI of EXT-X-SESSION-DATA
+	URISessionKey                     // URI of EXT-X-SESSION-KEY
+	URIContentSteering                // SERVER-URI of EXT-X-CONTENT-STEERING
+)
+
+// URIRewriter returns the URI to write instead of uri.
+// It is used by EncodeWithURIRewriter, for example to add per-viewer tokens.
+type URIRewriter func(kind URIKind, uri string) string
+
+// apply returns uri rewritten by r, or uri unchanged if r is nil.
+func (r URIRewriter) apply(kind URIKind, uri string) string {
+	if r == nil {
+		return uri
+	}
+	return r(kind, uri)
+}
+
+// DecodeOptions provides options for DecodeWithOptions.
+type DecodeOptions struct {
+	Strict         bool            // Strict makes decoding return the first syntax error
+	CustomDecoders []CustomDecoder // CustomDecoders are used for custom tags
+	BaseURL        *url.URL        // BaseURL is the location of the playlist and is stored in the playlist
+	ResolveURIs    bool            // ResolveURIs resolves all relative URIs against BaseURL
+}
+
+// DecodeWithOptions detects the type of playlist and decodes it. It accepts either bytes.Buffer
+// or io.Reader as input. The base URL is stored in the playlist, and if opts.ResolveURIs is true,
+// all relative URIs in the playlist are resolved against it.
+func DecodeWithOptions(input interface{}, opts DecodeOptions) (Playlist, ListType, error) {
+	p, listType, err := DecodeWith(input, opts.Strict, opts.CustomDecoders)
+	if err != nil {
+		return p, listType, err
+	}
+	switch pl := p.(type) {
+	case *MasterPlaylist:
+		pl.BaseURL = opts.BaseURL
+		if opts.ResolveURIs {
+			pl.ResolveURIs(opts.BaseURL)
+		}
+	case *MediaPlaylist:
+		pl.BaseURL = opts.BaseURL
+		if opts.ResolveURIs {
+			pl.ResolveURIs(opts.BaseURL)
+		}
+	}
+	return p, listType, nil
+}
+
+// ResolveURIs resolves all relative URIs in the media playlist against base.
+// If base is nil, the BaseURL of the playlist is used, otherwise BaseURL is set to base.
+// This covers segments, partial segments, EXT-X-MAP, EXT-X-KEY, EXT-X-PRELOAD-HINT
+// and interstitial X-ASSET-URI and X-ASSET-LIST date range attributes.
+// URIs containing variable references are left unchanged.
+func (p *MediaPlaylist) ResolveURIs(base *url.URL) {
+	if base == nil {
+		base = p.BaseURL
+	}
+	if base == nil {
+		return
+	}
+	p.BaseURL = base
+	p.rewriteURIs(func(uri string) string {
+		return resolveURI(base, uri)
+	})
+}
+
+// RelativizeURIs makes all URIs in the media playlist relative to newBase, for example
+// when the playlist is moved to a new location. URIs are first resolved against the
+// BaseURL of the playlist. URIs with another scheme or host than newBase stay absolute.
+// BaseURL is set to newBase.
+func (p *MediaPlaylist) RelativizeURIs(newBase *url.URL) {
+	oldBase := p.BaseURL
+	p.BaseURL = newBase
+	p.rewriteURIs(func(uri string) string {
+		return relativeURI(newBase, resolveURI(oldBase, uri))
+	})
+}
+
+// rewriteURIs replaces every URI in the media playlist by f(uri).
+// Maps and keys are replaced by copies, since they may be shared between segments.
+func (p *MediaPlaylist) rewriteURIs(f func(uri string) string) {
+	p.Map = rewriteMapURI(p.Map, f)
+	p.Keys = rewriteKeyURIs(p.Keys, f)
+	for _, seg := range p.GetAllSegments() {
+		if seg == nil {
+			continue
+		}
+		seg.URI = f(seg.URI)
+		seg.Map = rewriteMapURI(seg.Map, f)
+		seg.Keys = rewriteKeyURIs(seg.Keys, f)
+		for _, dr := range seg.SCTE35DateRanges {
+			rewriteDateRangeURIs(dr, f)
+		}
+	}
+	for _, ps := range p.PartialSegments {
+		ps.URI = f(ps.URI)
+	}
+	if p.PreloadHints != nil {
+		p.PreloadHints.URI = f(p.PreloadHints.URI)
+	}
+	for _, dr := range p.DateRanges {
+		rewriteDateRangeURIs(dr, f)
+	}
+	p.ResetCache()
+}
+
+// ResolveURIs resolves all relative URIs in the master playlist against base.
+// If base is nil, the BaseURL of the playlist is used, otherwise BaseURL is set to base.
+// This covers variants, I-frame variants, EXT-X-MEDIA, EXT-X-SESSION-DATA, EXT-X-SESSION-KEY
+// and the SERVER-URI of EXT-X-CONTENT-STEERING.
+// Variant chunklists without BaseURL get the resolved variant URI as BaseURL.
+func (p *MasterPlaylist) ResolveURIs(base *url.URL) {
+	if base == nil {
+		base = p.BaseURL
+	}
+	if base == nil {
+		return
+	}
+	p.BaseURL = base
+	p.rewriteURIs(func(uri string) string {
+		return resolveURI(base, uri)
+	})
+	for _, v := range p.Variants {
+		if v.Chunklist != nil && v.Chunklist.BaseURL == nil {
+			if u, err := url.Parse(v.URI); err == nil {
+				v.Chunklist.BaseURL = u
+			}
+		}
+	}
+}
+
+// RelativizeURIs makes all URIs in the master playlist relative to newBase.
+// URIs are first resolved against the BaseURL of the playlist.
+// URIs with another scheme or host than newBase stay absolute.
+// BaseURL is set to newBase.
+func (p *MasterPlaylist) RelativizeURIs(newBase *url.URL) {
+	oldBase := p.BaseURL
+	p.BaseURL = newBase
+	p.rewriteURIs(func(uri string) string {
+		return relativeURI(newBase, resolveURI(oldBase, uri))
+	})
+}
+
+// rewriteURIs replaces every URI in the master playlist by f(uri).
+// Alternatives shared between the playlist and its variants are only rewritten once.
+func (p *MasterPlaylist) rewriteURIs(f func(uri string) string) {
+	done := make(map[*Alternative]bool)
+	rewriteAlt := func(alt *Alternative) {
+		if alt == nil || done[alt] {
+			return
+		}
+		done[alt] = true
+		alt.URI = f(alt.URI)
+	}
+	for _, alt := range p.Alternatives {
+		rewriteAlt(alt)
+	}
+	for _, v := range p.Variants {
+		v.URI = f(v.URI)
+		for _, alt := range v.Alternatives {
+			rewriteAlt(alt)
+		}
+	}
+	for _, sd := range p.SessionDatas {
+		sd.URI = f(sd.URI)
+	}
+	for _, key := range p.SessionKeys {
+		if key.Method != "NONE" {
+			key.URI = f(key.URI)
+		}
+	}
+	if p.ContentSteering != nil {
+		p.ContentSteering.ServerURI = f(p.ContentSteering.ServerURI)
+	}
+	p.ResetCache()
+}
+
+func rewriteMapURI(m *Map, f func(uri string) string) *Map {
+	if m == nil {
+		return nil
+	}
+	rewritten := *m
+	rewritten.URI = f(m.URI)
+	return &rewritten
+}
+
+func rewriteKeyURIs(keys []Key, f func(uri string) string) []Key {
+	if len(keys) == 0 {
+		return keys
+	}
+	rewritten := make([]Key, len(keys))
+	for i, key := range keys {
+		rewritten[i] = key
+		if key.Method != "NONE" {
+			rewritten[i].URI = f(key.URI)
+		}
+	}
+	return rewritten
+}
+
+// rewriteDateRangeURIs rewrites the URI attributes of interstitial date ranges.
+func rewriteDateRangeURIs(dr *DateRange, f func(uri string) string) {
+	for i, xa := range dr.XAttrs {
+		switch xa.Key {
+		case "X-ASSET-URI", "X-ASSET-LIST":
+			dr.XAttrs[i].Val = `"` + f(deQuote(xa.Val)) + `"`
+		}
+	}
+}
+
+// resolveURI resolves a reference against base. The reference is returned unchanged
+// if base is nil, if it contains a variable reference, or if it is not a valid URI.
+// A relative base path, such as a file path, gives a relative result.
+func resolveURI(base *url.URL, ref string) string {
+	if base == nil || ref == "" || strings.Contains(ref, "{$") {
+		return ref
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	resolved := base.ResolveReference(u)
+	if !base.IsAbs() && base.Host == "" && !strings.HasPrefix(base.Path, "/") && !strings.HasPrefix(u.Path, "/") {
+		resolved.Path = strings.TrimPrefix(resolved.Path, "/")
+	}
+	return resolved.String()
+}
+
+// relativeURI returns target relative to base if they share scheme and host.
+// Otherwise target is returned unchanged.
+func relativeURI(base *url.URL, target string) string {
+	if base == nil || target == "" || strings.Contains(target, "{$") {
+		return target
+	}
+	t, err := url.Parse(target)
+	if err != nil || !t.IsAbs() || t.Scheme != base.Scheme || t.Host != base.Host || t.User.String() != base.User.String() {
+		return target
+	}
+	baseDir := strings.Split(base.Path, "/")
+	baseDir = baseDir[:len(baseDir)-1] // remove the file name
+	targetParts := strings.Split(t.Path, "/")
+	common := 0
+	for common < len(baseDir) && common < len(targetParts)-1 && baseDir[common] == targetParts[common] {
+		common++
+	}
+	var parts []string
+	for i := common; i < len(baseDir); i++ {
+		parts = append(parts, "..")
+	}
+	parts = append(parts, targetParts[common:]...)
+	rel := strings.Join(parts, "/")
+	if rel == "" || strings.Contains(strings.SplitN(rel, "/", 2)[0], ":") {
+		rel = "./" + rel
+	}
+	r := url.URL{Path: rel, RawQuery: t.RawQuery, Fragment: t.Fragment}
+	return r.String()
+}
@@ -0,0 +1,239 @@
+package m3u8
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestResolveMediaPlaylistURIs(t *testing.T) {
+	is := is.New(t)
+	src := `#EXTM3U
+#EXT-X-VERSION:6
+#EXT-X-TARGETDURATION:4
+#EXT-X-MEDIA-SEQUENCE:10
+#EXT-X-KEY:METHOD=SAMPLE-AES,URI="skd://key1",KEYFORMAT="com.apple.streamingkeydelivery",KEYFORMATVERSIONS="1"
+#EXT-X-MAP:URI="../init.mp4"
+#EXTINF:4.000,
+seg10.m4s
+#EXT-X-DATERANGE:ID="ad1",CLASS="com.apple.hls.interstitial",START-DATE="2025-01-01T00:00:00Z",X-ASSET-URI="ads/ad1.m3u8"
+#EXTINF:4.000,
+/abs/seg11.m4s
+#EXT-X-PART:DURATION=1.000,INDEPENDENT=YES,URI="seg12.0.m4s"
+#EXT-X-PRELOAD-HINT:TYPE=PART,URI="seg12.1.m4s"
+`
+	base, _ := url.Parse("https://example.com/live/video/index.m3u8")
+	p, listType, err := DecodeWithOptions(strings.NewReader(src), DecodeOptions{Strict: true, BaseURL: base, ResolveURIs: true})
+	is.NoErr(err)             // decode with options
+	is.Equal(listType, MEDIA) // must be media playlist
+	pl := p.(*MediaPlaylist)
+	is.Equal(pl.BaseURL, base) // base URL not stored
+	segs := pl.GetAllSegments()
+	is.Equal(segs[0].URI, "https://example.com/live/video/seg10.m4s")                         // relative segment URI not resolved
+	is.Equal(segs[1].URI, "https://example.com/abs/seg11.m4s")                                // absolute path not resolved
+	is.Equal(pl.Map.URI, "https://example.com/live/init.mp4")                                 // map URI not resolved
+	is.Equal(pl.Keys[0].URI, "skd://key1")                                                    // key URI with scheme must be unchanged
+	is.Equal(pl.PartialSegments[0].URI, "https://example.com/live/video/seg12.0.m4s")         // part URI not resolved
+	is.Equal(pl.PreloadHints.URI, "https://example.com/live/video/seg12.1.m4s")               // preload hint URI not resolved
+	is.Equal(pl.DateRanges[0].XAttrs[0].Val, `"https://example.com/live/video/ads/ad1.m3u8"`) // asset URI not resolved
+
+	newBase, _ := url.Parse("https://example.com/archive/index.m3u8")
+	pl.RelativizeURIs(newBase)
+	is.Equal(pl.BaseURL, newBase)                                         // base URL not updated
+	is.Equal(segs[0].URI, "../live/video/seg10.m4s")                      // segment URI not made relative
+	is.Equal(segs[1].URI, "../abs/seg11.m4s")                             // segment URI not made relative
+	is.Equal(pl.Map.URI, "../live/init.mp4")                              // map URI not made relative
+	is.Equal(pl.Keys[0].URI, "skd://key1")                                // key URI with other scheme must be unchanged
+	is.True(strings.Contains(pl.String(), "\n../live/video/seg10.m4s\n")) // relative URI not encoded
+}
+
+func TestResolveMasterPlaylistURIs(t *testing.T) {
+	is := is.New(t)
+	src := `#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-CONTENT-STEERING:SERVER-URI="steering.json"
+#EXT-X-SESSION-DATA:DATA-ID="com.example.title",URI="title.json"
+#EXT-X-SESSION-KEY:METHOD=AES-128,URI="key.bin"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="English",DEFAULT=YES,URI="audio/en.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=1000000,AUDIO="aac"
+video/720p.m3u8
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=100000,URI="video/iframes.m3u8"
+`
+	base, _ := url.Parse("https://example.com/vod/master.m3u8")
+	p, _, err := DecodeWithOptions(strings.NewReader(src), DecodeOptions{Strict: true, BaseURL: base})
+	is.NoErr(err) // decode with options
+	m := p.(*MasterPlaylist)
+	is.Equal(m.Variants[0].URI, "video/720p.m3u8") // URIs must not be resolved without ResolveURIs
+	m.Variants[0].Chunklist, _ = NewMediaPlaylist(0, 1)
+
+	m.ResolveURIs(nil)
+	is.Equal(m.Variants[0].URI, "https://example.com/vod/video/720p.m3u8")                        // variant URI not resolved
+	is.Equal(m.Variants[1].URI, "https://example.com/vod/video/iframes.m3u8")                     // I-frame variant URI not resolved
+	is.Equal(m.Alternatives[0].URI, "https://example.com/vod/audio/en.m3u8")                      // alternative URI not resolved
+	is.Equal(m.Variants[0].Alternatives[0].URI, "https://example.com/vod/audio/en.m3u8")          // shared alternative not resolved
+	is.Equal(m.SessionDatas[0].URI, "https://example.com/vod/title.json")                         // session data URI not resolved
+	is.Equal(m.SessionKeys[0].URI, "https://example.com/vod/key.bin")                             // session key URI not resolved
+	is.Equal(m.ContentSteering.ServerURI, "https://example.com/vod/steering.json")                // steering URI not resolved
+	is.Equal(m.Variants[0].Chunklist.BaseURL.String(), "https://example.com/vod/video/720p.m3u8") // chunklist base URL not set
+
+	newBase, _ := url.Parse("https://example.com/vod/v2/master.m3u8")
+	m.RelativizeURIs(newBase)
+	is.Equal(m.Variants[0].URI, "../video/720p.m3u8")         // variant URI not made relative
+	is.Equal(m.Alternatives[0].URI, "../audio/en.m3u8")       // shared alternative rewritten twice
+	is.Equal(m.ContentSteering.ServerURI, "../steering.json") // steering URI not made relative
+}
+
+func TestRelativeURI(t *testing.T) {
+	base, _ := url.Parse("https://example.com/a/b/index.m3u8")
+	cases := []struct {
+		target   string
+		expected string
+	}{
+		{"https://example.com/a/b/seg.ts", "seg.ts"},
+		{"https://example.com/a/b/c/seg.ts?token=1", "c/seg.ts?token=1"},
+		{"https://example.com/x/seg.ts", "../../x/seg.ts"},
+		{"https://example.com/a/b/x:y.ts", "./x:y.ts"},
+		{"https://other.com/a/b/seg.ts", "https://other.com/a/b/seg.ts"},
+		{"http://example.com/a/b/seg.ts", "http://example.com/a/b/seg.ts"},
+		{"seg.ts", "seg.ts"},
+		{"{$path}/seg.ts", "{$path}/seg.ts"},
+	}
+	for _, c := range cases {
+		t.Run(c.target, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(relativeURI(base, c.target), c.expected) // wrong relative URI
+		})
+	}
+}
+
+func TestEncodeMediaPlaylistWithURIRewriter(t *testing.T) {
+	is := is.New(t)
+	src := `#EXTM3U
+#EXT-X-VERSION:6
+#EXT-X-TARGETDURATION:4
+#EXT-X-MEDIA-SEQUENCE:10
+#EXT-X-KEY:METHOD=AES-128,URI="key.bin"
+#EXT-X-MAP:URI="init.mp4"
+#EXTINF:4.000,
+seg10.m4s
+#EXT-X-DATERANGE:ID="ad1",CLASS="com.apple.hls.interstitial",START-DATE="2025-01-01T00:00:00Z",X-ASSET-URI="ad1.m3u8"
+#EXTINF:4.000,
+seg11.m4s
+#EXT-X-PART:DURATION=1.000,INDEPENDENT=YES,URI="seg12.0.m4s"
+#EXT-X-PRELOAD-HINT:TYPE=PART,URI="seg12.1.m4s"
+`
+	p, _, err := DecodeFrom(strings.NewReader(src), true)
+	is.NoErr(err) // decode playlist
+	pl := p.(*MediaPlaylist)
+	plain := pl.Encode().String()
+
+	kinds := make(map[URIKind]int)
+	out := pl.EncodeWithURIRewriter(func(kind URIKind, uri string) string {
+		kinds[kind]++
+		return uri + "?token=abc"
+	}).String()
+	for _, line := range []string{
+		`URI="key.bin?token=abc"`,
+		`URI="init.mp4?token=abc"`,
+		"\nseg10.m4s?token=abc\n",
+		"\nseg11.m4s?token=abc\n",
+		`X-ASSET-URI="ad1.m3u8?token=abc"`,
+		`URI="seg12.0.m4s?token=abc"`,
+		`URI="seg12.1.m4s?token=abc"`,
+	} {
+		is.True(strings.Contains(out, line)) // rewritten URI missing
+	}
+	is.Equal(kinds, map[URIKind]int{URIKey: 1, URIMap: 1, URISegment: 2, URIAsset: 1,
+		URIPartialSegment: 1, URIPreloadHint: 1}) // wrong URI kinds
+
+	is.Equal(pl.GetAllSegments()[0].URI, "seg10.m4s") // playlist must not be changed
+	is.Equal(pl.Encode().String(), plain)             // cached output must not be changed
+	is.Equal(pl.String(), plain)                      // output must not be changed
+}
+
+func TestEncodeMasterPlaylistWithURIRewriter(t *testing.T) {
+	is := is.New(t)
+	src := `#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-CONTENT-STEERING:SERVER-URI="steering.json"
+#EXT-X-SESSION-DATA:DATA-ID="com.example.title",URI="title.json"
+#EXT-X-SESSION-KEY:METHOD=AES-128,URI="key.bin"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="English",DEFAULT=YES,URI="audio/en.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=1000000,AUDIO="aac"
+video/720p.m3u8
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=100000,URI="video/iframes.m3u8"
+`
+	p, _, err := DecodeFrom(strings.NewReader(src), true)
+	is.NoErr(err) // decode playlist
+	m := p.(*MasterPlaylist)
+	plain := m.Encode().String()
+
+	kinds := make(map[URIKind]int)
+	out := m.EncodeWithURIRewriter(func(kind URIKind, uri string) string {
+		kinds[kind]++
+		return "https://cdn.example.com/" + uri
+	}).String()
+	for _, line := range []string{
+		`SERVER-URI="https://cdn.example.com/steering.json"`,
+		`URI="https://cdn.example.com/title.json"`,
+		`URI="https://cdn.example.com/key.bin"`,
+		`URI="https://cdn.example.com/audio/en.m3u8"`,
+		"\nhttps://cdn.example.com/video/720p.m3u8\n",
+		`URI="https://cdn.example.com/video/iframes.m3u8"`,
+	} {
+		is.True(strings.Contains(out, line)) // rewritten URI missing
+	}
+	is.Equal(kinds, map[URIKind]int{URIContentSteering: 1, URISessionData: 1, URISessionKey: 1,
+		URIRendition: 1, URIVariant: 1, URIIFrameVariant: 1}) // wrong URI kinds
+	is.Equal(m.Variants[0].URI, "video/720p.m3u8") // playlist must not be changed
+	is.Equal(m.Encode().String(), plain)           // cached output must not be changed
+}
+
+func TestEncodeWithURIRewriterAndArgs(t *testing.T) {
+	is := is.New(t)
+	sign := func(kind URIKind, uri string) string {
+		return uri + "?token=abc"
+	}
+	p, err := NewMediaPlaylist(0, 2)
+	is.NoErr(err)
+	is.NoErr(p.Append("seg0.ts", 4, ""))
+	is.NoErr(p.Append("seg1.ts?v=1", 4, ""))
+	p.Args = "k=v"
+	out := p.EncodeWithURIRewriter(sign).String()
+	is.True(strings.Contains(out, "\nseg0.ts?token=abc&k=v\n"))  // args appended to rewritten query
+	is.True(strings.Contains(p.String(), "\nseg0.ts?k=v\n"))     // args without rewriter
+	is.True(strings.Contains(p.String(), "\nseg1.ts?v=1&k=v\n")) // args appended to existing query
+
+	m := NewMasterPlaylist()
+	m.Append("low.m3u8", p, VariantParams{Bandwidth: 1000000})
+	m.Args = "k=v"
+	out = m.EncodeWithURIRewriter(sign).String()
+	is.True(strings.Contains(out, "\nlow.m3u8?token=abc&k=v\n")) // args appended to rewritten query
+}
+
+func TestResolveURI(t *testing.T) {
+	cases := []struct {
+		base     string
+		ref      string
+		expected string
+	}{
+		{"https://example.com/a/b/index.m3u8", "seg.ts", "https://example.com/a/b/seg.ts"},
+		{"https://example.com/a/b/index.m3u8", "../seg.ts", "https://example.com/a/seg.ts"},
+		{"https://example.com/a/b/index.m3u8", "/seg.ts", "https://example.com/seg.ts"},
+		{"https://example.com/a/b/index.m3u8", "http://other.com/seg.ts", "http://other.com/seg.ts"},
+		{"https://example.com/a/b/index.m3u8", "{$path}/seg.ts", "{$path}/seg.ts"},
+		{"a/b/index.m3u8", "seg.ts", "a/b/seg.ts"},
+		{"a/b/index.m3u8", "/seg.ts", "/seg.ts"},
+		{"/a/b/index.m3u8", "../seg.ts", "/a/seg.ts"},
+	}
+	for _, c := range cases {
+		t.Run(c.base+" "+c.ref, func(t *testing.T) {
+			is := is.New(t)
+			base, err := url.Parse(c.base)
+			is.NoErr(err)                                 // parse base
+			is.Equal(resolveURI(base, c.ref), c.expected) // wrong resolved URI
+		})
+	}
+}
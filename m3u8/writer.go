@@ -23,6 +23,7 @@ var ErrPlaylistFull = errors.New("playlist is full")
 var ErrPlaylistEmpty = errors.New("playlist is empty")
 var ErrWinSizeTooSmall = errors.New("window size must be >= capacity")
 var ErrAlreadySkipped = errors.New("can not change the existing skip tag in a playlist")
+var ErrWinDurationNegative = errors.New("window duration must be >= 0")
 var regexpNum = regexp.MustCompile(`(\d+)$`)
 
 var segmentSlices = sync.Pool{}
@@ -123,39 +124,53 @@ func (p *MasterPlaylist) Encode() *bytes.Buffer {
 	if p.buf.Len() > 0 {
 		return &p.buf
 	}
+	p.encodeTo(&p.buf, nil)
+	return &p.buf
+}
+
+// EncodeWithURIRewriter generates the output in M3U8 format with every URI replaced
+// by the return value of rewrite. The output is written to a new buffer, so neither
+// the playlist nor its cached output is changed.
+func (p *MasterPlaylist) EncodeWithURIRewriter(rewrite URIRewriter) *bytes.Buffer {
+	buf := new(bytes.Buffer)
+	p.encodeTo(buf, rewrite)
+	return buf
+}
 
-	p.buf.WriteString("#EXTM3U\n#EXT-X-VERSION:")
-	p.buf.WriteString(strVer(p.ver))
-	p.buf.WriteRune('\n')
+// encodeTo writes the playlist to buf applying rewrite to all URIs.
+func (p *MasterPlaylist) encodeTo(buf *bytes.Buffer, rewrite URIRewriter) {
+	buf.WriteString("#EXTM3U\n#EXT-X-VERSION:")
+	buf.WriteString(strVer(p.ver))
+	buf.WriteRune('\n')
 	if p.ContentSteering != nil {
-		writeContentSteering(&p.buf, p.ContentSteering)
+		writeContentSteering(buf, p.ContentSteering, rewrite)
 	}
 
 	if p.IndependentSegments() {
-		p.buf.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+		buf.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
 	}
 
 	if p.StartTime != 0.0 { // Both negative and positive values are allowed. Negative values are relative to the end.
-		writeExtXStart(&p.buf, p.StartTime, p.StartTimePrecise, p.WritePrecision())
+		writeExtXStart(buf, p.StartTime, p.StartTimePrecise, p.WritePrecision())
 	}
 
 	if len(p.Defines) > 0 {
-		writeDefines(&p.buf, p.Defines)
+		writeDefines(buf, p.Defines)
 	}
 
 	for _, sd := range p.SessionDatas {
-		writeSessionData(&p.buf, sd)
+		writeSessionData(buf, sd, rewrite)
 	}
 	for _, key := range p.SessionKeys {
-		writeKey("#EXT-X-SESSION-KEY:", &p.buf, key)
+		writeKey("#EXT-X-SESSION-KEY:", buf, key, URISessionKey, rewrite)
 	}
 
 	// Write any custom master tags
 	if p.Custom != nil {
 		for _, v := range p.Custom {
 			if customBuf := v.Encode(); customBuf != nil {
-				p.buf.WriteString(customBuf.String())
-				p.buf.WriteRune('\n')
+				buf.WriteString(customBuf.String())
+				buf.WriteRune('\n')
 			}
 		}
 	}
@@ -189,33 +204,31 @@ func (p *MasterPlaylist) Encode() *bytes.Buffer {
 	}
 	sort.Strings(keys)
 	for _, key := range keys {
-		writeExtXMedia(&p.buf, allAlts[key])
+		writeExtXMedia(buf, allAlts[key], rewrite)
 	}
 
+	groups := make(map[string]bool)
+	for _, g := range p.RenditionGroups() {
+		groups[g.Type+"/"+g.GroupId] = true
+	}
 	for _, vnt := range p.Variants {
+		if len(groups) > 0 {
+			vnt = withGroupRefs(vnt, groups)
+		}
 		if vnt.Iframe {
-			writeExtXIFrameStreamInf(&p.buf, vnt, p.WritePrecision())
+			writeExtXIFrameStreamInf(buf, vnt, p.WritePrecision(), rewrite)
 		} else {
-			writeExtXStreamInf(&p.buf, vnt, p.WritePrecision())
-			p.buf.WriteString(vnt.URI)
-			if p.Args != "" {
-				if strings.Contains(vnt.URI, "?") {
-					p.buf.WriteRune('&')
-				} else {
-					p.buf.WriteRune('?')
-				}
-				p.buf.WriteString(p.Args)
-			}
-			p.buf.WriteRune('\n')
+			writeExtXStreamInf(buf, vnt, p.WritePrecision())
+			writeURIWithArgs(buf, rewrite.apply(URIVariant, vnt.URI), p.Args)
+			buf.WriteRune('\n')
 		}
 	}
 
-	return &p.buf
 }
 
 // writeExtXMedia writes an EXT-X-MEDIA tag line including \n to the buffer.
 // No checks are done that the date is valid.
-func writeExtXMedia(buf *bytes.Buffer, alt *Alternative) {
+func writeExtXMedia(buf *bytes.Buffer, alt *Alternative, rewrite URIRewriter) {
 	buf.WriteString("#EXT-X-MEDIA:")
 	buf.WriteString("TYPE=")
 	buf.WriteString(alt.Type)                 // Mandatory enumerated string
@@ -254,7 +267,7 @@ func writeExtXMedia(buf *bytes.Buffer, alt *Alternative) {
 		writeChannels(buf, alt.Channels)
 	}
 	if alt.URI != "" {
-		writeQuoted(buf, "URI", alt.URI)
+		writeQuoted(buf, "URI", rewrite.apply(URIRendition, alt.URI))
 	}
 	buf.WriteRune('\n')
 }
@@ -325,7 +338,7 @@ func writeExtXStreamInf(buf *bytes.Buffer, vnt *Variant, writePrecision int) {
 	buf.WriteRune('\n')
 }
 
-func writeExtXIFrameStreamInf(buf *bytes.Buffer, vnt *Variant, writePrecision int) {
+func writeExtXIFrameStreamInf(buf *bytes.Buffer, vnt *Variant, writePrecision int, rewrite URIRewriter) {
 	buf.WriteString("#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=")
 	buf.WriteString(strconv.FormatUint(uint64(vnt.Bandwidth), 10))
 	if vnt.AverageBandwidth != 0 {
@@ -372,11 +385,11 @@ func writeExtXIFrameStreamInf(buf *bytes.Buffer, vnt *Variant, writePrecision in
 	if vnt.Name != "" {
 		writeQuoted(buf, "NAME", vnt.Name)
 	}
-	writeQuoted(buf, "URI", vnt.URI) // Mandatory
+	writeQuoted(buf, "URI", rewrite.apply(URIIFrameVariant, vnt.URI)) // Mandatory
 	buf.WriteRune('\n')
 }
 
-func writePartialSegment(buf *bytes.Buffer, ps *PartialSegment, writePrecision int) {
+func writePartialSegment(buf *bytes.Buffer, ps *PartialSegment, writePrecision int, rewrite URIRewriter) {
 	if !ps.ProgramDateTime.IsZero() {
 		buf.WriteString("#EXT-X-PROGRAM-DATE-TIME:")
 		buf.WriteString(ps.ProgramDateTime.Format(DATETIME))
@@ -396,17 +409,17 @@ func writePartialSegment(buf *bytes.Buffer, ps *PartialSegment, writePrecision i
 		writeRange(buf, ",BYTERANGE=", ps.Limit, ps.Offset)
 	}
 	buf.WriteString(",URI=\"")
-	buf.WriteString(ps.URI)
+	buf.WriteString(rewrite.apply(URIPartialSegment, ps.URI))
 	buf.WriteRune('"')
 	buf.WriteRune('\n')
 }
 
-func writePreloadHint(buf *bytes.Buffer, ph *PreloadHint) {
+func writePreloadHint(buf *bytes.Buffer, ph *PreloadHint, rewrite URIRewriter) {
 	buf.WriteString("#EXT-X-PRELOAD-HINT:")
 	buf.WriteString("TYPE=")
 	buf.WriteString(ph.Type)
 	buf.WriteString(",URI=\"")
-	buf.WriteString(ph.URI)
+	buf.WriteString(rewrite.apply(URIPreloadHint, ph.URI))
 	buf.WriteRune('"')
 	if ph.Limit > 0 {
 		buf.WriteString(",BYTERANGE-START=")
@@ -452,7 +465,7 @@ func writeServerControl(buf *bytes.Buffer, sc *ServerControl, writePrecision int
 }
 
 // writeDateRange writes an EXT-X-DATERANGE tag line including \n to the buffer.
-func writeDateRange(buf *bytes.Buffer, dr *DateRange, writePrecision int) {
+func writeDateRange(buf *bytes.Buffer, dr *DateRange, writePrecision int, rewrite URIRewriter) {
 	buf.WriteString(`#EXT-X-DATERANGE:ID="`)
 	buf.WriteString(dr.ID)
 	buf.WriteRune('"')
@@ -487,6 +500,10 @@ func writeDateRange(buf *bytes.Buffer, dr *DateRange, writePrecision int) {
 		buf.WriteString(",END-ON-NEXT=YES")
 	}
 	for _, xa := range dr.XAttrs {
+		if rewrite != nil && (xa.Key == "X-ASSET-URI" || xa.Key == "X-ASSET-LIST") {
+			writeQuoted(buf, xa.Key, rewrite(URIAsset, deQuote(xa.Val)))
+			continue
+		}
 		writeUnQuoted(buf, xa.Key, xa.Val)
 	}
 	buf.WriteRune('\n')
@@ -515,7 +532,7 @@ func writeDefines(buf *bytes.Buffer, defines []Define) {
 	}
 }
 
-func writeSessionData(buf *bytes.Buffer, sd *SessionData) {
+func writeSessionData(buf *bytes.Buffer, sd *SessionData, rewrite URIRewriter) {
 	buf.WriteString("#EXT-X-SESSION-DATA:DATA-ID=\"")
 	buf.WriteString(sd.DataId)
 	buf.WriteRune('"')
@@ -523,7 +540,7 @@ func writeSessionData(buf *bytes.Buffer, sd *SessionData) {
 		writeQuoted(buf, "VALUE", sd.Value)
 	}
 	if sd.URI != "" {
-		writeQuoted(buf, "URI", sd.URI)
+		writeQuoted(buf, "URI", rewrite.apply(URISessionData, sd.URI))
 	}
 	if sd.Format != "JSON" {
 		writeUnQuoted(buf, "FORMAT", sd.Format)
@@ -543,10 +560,10 @@ func writeExtXStart(buf *bytes.Buffer, startTime float64, precise bool, writePre
 	buf.WriteRune('\n')
 }
 
-func writeExtXMap(buf *bytes.Buffer, m *Map) {
+func writeExtXMap(buf *bytes.Buffer, m *Map, rewrite URIRewriter) {
 	buf.WriteString("#EXT-X-MAP:")
 	buf.WriteString("URI=\"")
-	buf.WriteString(m.URI)
+	buf.WriteString(rewrite.apply(URIMap, m.URI))
 	buf.WriteRune('"')
 	if m.Limit > 0 {
 		writeRange(buf, ",BYTERANGE=", m.Limit, m.Offset)
@@ -554,12 +571,12 @@ func writeExtXMap(buf *bytes.Buffer, m *Map) {
 	buf.WriteRune('\n')
 }
 
-func writeKey(tag string, buf *bytes.Buffer, key *Key) {
+func writeKey(tag string, buf *bytes.Buffer, key *Key, kind URIKind, rewrite URIRewriter) {
 	buf.WriteString(tag)
 	buf.WriteString("METHOD=")
 	buf.WriteString(key.Method)
 	if key.Method != "NONE" {
-		writeQuoted(buf, "URI", key.URI)
+		writeQuoted(buf, "URI", rewrite.apply(kind, key.URI))
 		if key.IV != "" {
 			writeUnQuoted(buf, "IV", key.IV)
 		}
@@ -573,9 +590,9 @@ func writeKey(tag string, buf *bytes.Buffer, key *Key) {
 	buf.WriteRune('\n')
 }
 
-func writeContentSteering(buf *bytes.Buffer, cs *ContentSteering) {
+func writeContentSteering(buf *bytes.Buffer, cs *ContentSteering, rewrite URIRewriter) {
 	buf.WriteString(`#EXT-X-CONTENT-STEERING:SERVER-URI="`)
-	buf.WriteString(cs.ServerURI)
+	buf.WriteString(rewrite.apply(URIContentSteering, cs.ServerURI))
 	buf.WriteRune('"')
 	if cs.PathwayId != "" {
 		writeQuoted(buf, "PATHWAY-ID", cs.PathwayId)
@@ -758,6 +775,59 @@ func (p *MediaPlaylist) Remove() (err error) {
 	return nil
 }
 
+// removeHead removes the first segment like Remove, but carries over the state
+// that the removed segment signalled for the following segments, so that the
+// remaining playlist stays equivalent: EXT-X-DISCONTINUITY-SEQUENCE is incremented,
+// EXT-X-KEY and EXT-X-MAP become playlist defaults, and EXT-X-PROGRAM-DATE-TIME
+// is moved to the next segment.
+func (p *MediaPlaylist) removeHead() error {
+	if p.count == 0 {
+		return ErrPlaylistEmpty
+	}
+	seg := p.Segments[p.head]
+	if err := p.Remove(); err != nil {
+		return err
+	}
+	if seg == nil {
+		return nil
+	}
+	st := p.initialState().advance(seg)
+	p.DiscontinuitySeq, p.Keys, p.Map = st.disc, st.keys, st.mp
+	if p.count == 0 {
+		return nil
+	}
+	if next := p.Segments[p.head]; next != nil && next.ProgramDateTime.IsZero() {
+		next.ProgramDateTime = st.advance(next).pdt
+	}
+	return nil
+}
+
+// removeKeepingKeys removes the first segment like Remove, but keeps its effective
+// EXT-X-KEY tags as playlist keys, so that the following segments stay encrypted.
+func (p *MediaPlaylist) removeKeepingKeys() error {
+	if p.count == 0 {
+		return ErrPlaylistEmpty
+	}
+	seg := p.Segments[p.head]
+	if err := p.Remove(); err != nil {
+		return err
+	}
+	if seg != nil {
+		p.Keys = p.initialState().advance(seg).keys
+	}
+	return nil
+}
+
+// trimWindow removes segments with removeHead until only the sliding window remains.
+func (p *MediaPlaylist) trimWindow() {
+	if p.winsize == 0 && p.winDuration == 0 {
+		return
+	}
+	for p.count > p.winCount() {
+		_ = p.removeHead()
+	}
+}
+
 // Append general chunk to the tail of chunk slice for a media playlist.
 // This operation resets playlist cache.
 func (p *MediaPlaylist) Append(uri string, duration float64, title string) error {
@@ -778,6 +848,9 @@ func (p *MediaPlaylist) AppendSegment(seg *MediaSegment) error {
 	if p.count > 0 {
 		seg.SeqId = p.Segments[(p.capacity+p.tail-1)%p.capacity].SeqId + 1
 	}
+	if err := p.rotateKey(seg); err != nil {
+		return err
+	}
 	p.Segments[p.tail] = seg
 	p.tail = (p.tail + 1) % p.capacity
 	p.count++
@@ -786,6 +859,9 @@ func (p *MediaPlaylist) AppendSegment(seg *MediaSegment) error {
 	if !p.targetDurLocked {
 		p.TargetDuration = calcNewTargetDuration(seg.Duration, p.ver, p.TargetDuration)
 	}
+	if p.winDuration > 0 && !p.Closed {
+		p.trimWindow()
+	}
 	if seg.SCTE != nil {
 		p.scte35Syntax = seg.SCTE.Syntax
 	}
@@ -874,9 +950,22 @@ func (p *MediaPlaylist) AppendDefine(d Define) {
 // the head of chunk slice and move pointer to next chunk. Secondly it
 // appends one chunk to the tail of chunk slice. Useful for sliding
 // playlists.  This operation resets the cache.
+//
+// If a window duration is set (see SetWinDuration), the segments that fall
+// outside the time window are removed after the new chunk has been appended.
+//
+// The EXT-X-KEY tags of a removed chunk are kept as playlist keys, so that the
+// remaining chunks are still encrypted with the same keys.
 func (p *MediaPlaylist) Slide(uri string, duration float64, title string) {
+	if p.winDuration > 0 {
+		if !p.Closed && p.count == p.capacity {
+			_ = p.removeKeepingKeys()
+		}
+		_ = p.Append(uri, duration, title)
+		return
+	}
 	if !p.Closed && p.count >= p.winsize {
-		_ = p.Remove()
+		_ = p.removeKeepingKeys()
 	}
 	_ = p.Append(uri, duration, title)
 }
@@ -896,88 +985,106 @@ func (p *MediaPlaylist) encode(segmentsToSkipInTotal uint64) *bytes.Buffer {
 	if p.buf.Len() > 0 {
 		return &p.buf
 	}
+	p.head, p.PartialSegments = p.encodeTo(&p.buf, segmentsToSkipInTotal, nil)
+	return &p.buf
+}
+
+// EncodeWithURIRewriter generates the output in M3U8 format with every URI replaced
+// by the return value of rewrite. The output is written to a new buffer, so neither
+// the playlist nor its cached output is changed.
+func (p *MediaPlaylist) EncodeWithURIRewriter(rewrite URIRewriter) *bytes.Buffer {
+	buf := new(bytes.Buffer)
+	_, _ = p.encodeTo(buf, p.SkippedSegments(), rewrite)
+	return buf
+}
 
+// encodeTo writes the playlist to buf applying rewrite to all URIs.
+// It returns the new head of the segment buffer and the partial segments that remain
+// after the completed ones have been written, without changing the playlist.
+func (p *MediaPlaylist) encodeTo(buf *bytes.Buffer, segmentsToSkipInTotal uint64,
+	rewrite URIRewriter) (newHead uint, partials []*PartialSegment) {
+	partials = p.PartialSegments
 	var lastMap *Map
 
-	p.buf.WriteString("#EXTM3U\n#EXT-X-VERSION:")
-	p.buf.WriteString(strVer(p.ver))
-	p.buf.WriteRune('\n')
+	buf.WriteString("#EXTM3U\n#EXT-X-VERSION:")
+	buf.WriteString(strVer(p.ver))
+	buf.WriteRune('\n')
 
 	if p.IndependentSegments() {
-		p.buf.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+		buf.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
 	}
 
 	// Write any custom master tags
 	if p.Custom != nil {
 		for _, v := range p.Custom {
 			if customBuf := v.Encode(); customBuf != nil {
-				p.buf.WriteString(customBuf.String())
-				p.buf.WriteRune('\n')
+				buf.WriteString(customBuf.String())
+				buf.WriteRune('\n')
 			}
 		}
 	}
 
 	if p.AllowCache != nil {
-		p.buf.WriteString("#EXT-X-ALLOW-CACHE:")
-		writeYESorNO(&p.buf, *p.AllowCache)
-		p.buf.WriteRune('\n')
+		buf.WriteString("#EXT-X-ALLOW-CACHE:")
+		writeYESorNO(buf, *p.AllowCache)
+		buf.WriteRune('\n')
 	}
 
 	if len(p.Defines) > 0 {
-		writeDefines(&p.buf, p.Defines)
+		writeDefines(buf, p.Defines)
 	}
 
 	// default key before any segment
 	if len(p.Keys) != 0 {
 		for _, key := range p.Keys {
-			writeKey("#EXT-X-KEY:", &p.buf, &key)
+			writeKey("#EXT-X-KEY:", buf, &key, URIKey, rewrite)
 		}
 	}
 
 	if p.MediaType > 0 {
-		p.buf.WriteString("#EXT-X-PLAYLIST-TYPE:")
+		buf.WriteString("#EXT-X-PLAYLIST-TYPE:")
 		switch p.MediaType {
 		case EVENT:
-			p.buf.WriteString("EVENT\n")
+			buf.WriteString("EVENT\n")
 		case VOD:
-			p.buf.WriteString("VOD\n")
+			buf.WriteString("VOD\n")
 		}
 	}
 
 	if p.ServerControl != nil {
-		writeServerControl(&p.buf, p.ServerControl, p.WritePrecision())
+		writeServerControl(buf, p.ServerControl, p.WritePrecision())
 	}
 
 	if p.PartTargetDuration > 0 {
-		p.buf.WriteString("#EXT-X-PART-INF:PART-TARGET=")
-		p.buf.WriteString(strconv.FormatFloat(p.PartTargetDuration, 'f', p.WritePrecision(), 64))
-		p.buf.WriteRune('\n')
-	}
-	p.buf.WriteString("#EXT-X-MEDIA-SEQUENCE:")
-	p.buf.WriteString(strconv.FormatUint(p.SeqNo, 10))
-	p.buf.WriteRune('\n')
-	p.buf.WriteString("#EXT-X-TARGETDURATION:")
-	p.buf.WriteString(strconv.FormatInt(int64(p.TargetDuration), 10))
-	p.buf.WriteRune('\n')
+		buf.WriteString("#EXT-X-PART-INF:PART-TARGET=")
+		buf.WriteString(strconv.FormatFloat(p.PartTargetDuration, 'f', p.WritePrecision(), 64))
+		buf.WriteRune('\n')
+	}
+	buf.WriteString("#EXT-X-MEDIA-SEQUENCE:")
+	buf.WriteString(strconv.FormatUint(p.SeqNo, 10))
+	buf.WriteRune('\n')
+	buf.WriteString("#EXT-X-TARGETDURATION:")
+	buf.WriteString(strconv.FormatInt(int64(p.TargetDuration), 10))
+	buf.WriteRune('\n')
 	if p.StartTime != 0.0 { // Both negative and positive values are allowed. Negative values are relative to the end.
-		writeExtXStart(&p.buf, p.StartTime, p.StartTimePrecise, p.WritePrecision())
+		writeExtXStart(buf, p.StartTime, p.StartTimePrecise, p.WritePrecision())
 	}
 	if p.DiscontinuitySeq != 0 {
-		p.buf.WriteString("#EXT-X-DISCONTINUITY-SEQUENCE:")
-		p.buf.WriteString(strconv.FormatUint(uint64(p.DiscontinuitySeq), 10))
-		p.buf.WriteRune('\n')
+		buf.WriteString("#EXT-X-DISCONTINUITY-SEQUENCE:")
+		buf.WriteString(strconv.FormatUint(uint64(p.DiscontinuitySeq), 10))
+		buf.WriteRune('\n')
 	}
 	if p.Iframe {
-		p.buf.WriteString("#EXT-X-I-FRAMES-ONLY\n")
+		buf.WriteString("#EXT-X-I-FRAMES-ONLY\n")
 	}
 
 	if segmentsToSkipInTotal > 0 {
-		writeSkip(&p.buf, segmentsToSkipInTotal)
+		writeSkip(buf, segmentsToSkipInTotal)
 	} else {
 		// Ignore the Media Initialization Section (EXT-X-MAP) tag
 		// in presence of skip (EXT-X-SKIP) tag
 		if p.Map != nil {
-			writeExtXMap(&p.buf, p.Map)
+			writeExtXMap(buf, p.Map, rewrite)
 		}
 		lastMap = p.Map
 	}
@@ -990,7 +1097,7 @@ func (p *MediaPlaylist) encode(segmentsToSkipInTotal uint64) *bytes.Buffer {
 	head := p.head
 	tail := p.tail
 	count := p.count
-	isVoDOrEvent := p.winsize == 0
+	isVoDOrEvent := p.winsize == 0 && p.winDuration == 0
 	segmentsSkipped := p.SkippedSegments()
 	var outputCount uint     // number of segments to output
 	var start uint           // start index of segments to output
@@ -1000,8 +1107,12 @@ func (p *MediaPlaylist) encode(segmentsToSkipInTotal uint64) *bytes.Buffer {
 		outputCount = count
 		start = head
 	} else {
-		// for Live playlists, output the last winsize segments
+		// for Live playlists, output the last winsize segments. Duration windows
+		// are trimmed when segments are appended.
 		outputCount = min(p.winsize, count)
+		if p.winDuration > 0 {
+			outputCount = count
+		}
 		start = head + count - outputCount
 		if tail > 0 {
 			lastSegId = p.Segments[tail-1].SeqId
@@ -1009,10 +1120,13 @@ func (p *MediaPlaylist) encode(segmentsToSkipInTotal uint64) *bytes.Buffer {
 	}
 
 	// shift head to start
-	p.head = start
+	newHead = p.head
+	if p.capacity > 0 {
+		newHead = start % p.capacity
+	}
 	// output segments
 	for i := start; i < start+outputCount; i++ {
-		seg = p.Segments[i]
+		seg = p.Segments[i%p.capacity]
 		if seg == nil { // protection from badly filled chunklists
 			continue
 		}
@@ -1021,80 +1135,80 @@ func (p *MediaPlaylist) encode(segmentsToSkipInTotal uint64) *bytes.Buffer {
 			continue
 		}
 		if seg.Discontinuity {
-			p.buf.WriteString("#EXT-X-DISCONTINUITY\n")
+			buf.WriteString("#EXT-X-DISCONTINUITY\n")
 		}
 		if seg.SCTE != nil {
 			switch seg.SCTE.Syntax {
 			case SCTE35_67_2014:
-				p.buf.WriteString("#EXT-SCTE35:")
-				p.buf.WriteString("CUE=\"")
-				p.buf.WriteString(seg.SCTE.Cue)
-				p.buf.WriteRune('"')
+				buf.WriteString("#EXT-SCTE35:")
+				buf.WriteString("CUE=\"")
+				buf.WriteString(seg.SCTE.Cue)
+				buf.WriteRune('"')
 				if seg.SCTE.ID != "" {
-					p.buf.WriteString(",ID=\"")
-					p.buf.WriteString(seg.SCTE.ID)
-					p.buf.WriteRune('"')
+					buf.WriteString(",ID=\"")
+					buf.WriteString(seg.SCTE.ID)
+					buf.WriteRune('"')
 				}
 				if seg.SCTE.Time != 0 {
-					p.buf.WriteString(",TIME=")
-					writeFloatValue(&p.buf, seg.SCTE.Time, p.WritePrecision())
+					buf.WriteString(",TIME=")
+					writeFloatValue(buf, seg.SCTE.Time, p.WritePrecision())
 				}
-				p.buf.WriteRune('\n')
+				buf.WriteRune('\n')
 			case SCTE35_OATCLS:
 				switch seg.SCTE.CueType {
 				case SCTE35Cue_Start:
 					if seg.SCTE.Cue != "" {
-						p.buf.WriteString("#EXT-OATCLS-SCTE35:")
-						p.buf.WriteString(seg.SCTE.Cue)
-						p.buf.WriteRune('\n')
+						buf.WriteString("#EXT-OATCLS-SCTE35:")
+						buf.WriteString(seg.SCTE.Cue)
+						buf.WriteRune('\n')
 					}
-					p.buf.WriteString("#EXT-X-CUE-OUT:")
-					writeFloatValue(&p.buf, seg.SCTE.Time, p.WritePrecision())
-					p.buf.WriteRune('\n')
+					buf.WriteString("#EXT-X-CUE-OUT:")
+					writeFloatValue(buf, seg.SCTE.Time, p.WritePrecision())
+					buf.WriteRune('\n')
 				case SCTE35Cue_Mid:
-					p.buf.WriteString("#EXT-X-CUE-OUT-CONT:ElapsedTime=")
-					writeFloatValue(&p.buf, seg.SCTE.Elapsed, p.WritePrecision())
-					p.buf.WriteString(",Duration=")
-					writeFloatValue(&p.buf, seg.SCTE.Time, p.WritePrecision())
-					p.buf.WriteString(",SCTE35=")
-					p.buf.WriteString(seg.SCTE.Cue)
-					p.buf.WriteRune('\n')
+					buf.WriteString("#EXT-X-CUE-OUT-CONT:ElapsedTime=")
+					writeFloatValue(buf, seg.SCTE.Elapsed, p.WritePrecision())
+					buf.WriteString(",Duration=")
+					writeFloatValue(buf, seg.SCTE.Time, p.WritePrecision())
+					buf.WriteString(",SCTE35=")
+					buf.WriteString(seg.SCTE.Cue)
+					buf.WriteRune('\n')
 				case SCTE35Cue_End:
-					p.buf.WriteString("#EXT-X-CUE-IN\n")
+					buf.WriteString("#EXT-X-CUE-IN\n")
 				}
 			}
 		}
 		for i := range seg.SCTE35DateRanges {
-			writeDateRange(&p.buf, seg.SCTE35DateRanges[i], p.WritePrecision())
+			writeDateRange(buf, seg.SCTE35DateRanges[i], p.WritePrecision(), rewrite)
 		}
 
 		// check for key change
 		if len(seg.Keys) != 0 && (p.Keys == nil || !slices.Equal(seg.Keys, p.Keys)) {
 			for _, key := range seg.Keys {
-				writeKey("#EXT-X-KEY:", &p.buf, &key)
+				writeKey("#EXT-X-KEY:", buf, &key, URIKey, rewrite)
 			}
 		}
 		if seg.Gap {
-			p.buf.WriteString("#EXT-X-GAP\n")
+			buf.WriteString("#EXT-X-GAP\n")
 		}
 		// ignore segment Map if already written
 		if seg.Map != nil && !seg.Map.Equal(lastMap) {
-			writeExtXMap(&p.buf, seg.Map)
+			writeExtXMap(buf, seg.Map, rewrite)
 			lastMap = seg.Map
 		}
 		if !seg.ProgramDateTime.IsZero() {
-			p.buf.WriteString("#EXT-X-PROGRAM-DATE-TIME:")
-			p.buf.WriteString(seg.ProgramDateTime.Format(DATETIME))
-			p.buf.WriteRune('\n')
+			buf.WriteString("#EXT-X-PROGRAM-DATE-TIME:")
+			buf.WriteString(seg.ProgramDateTime.Format(DATETIME))
+			buf.WriteRune('\n')
 		}
 		// handle completed partial segments
-		if p.HasPartialSegments() {
+		if len(partials) > 0 {
 			fullSegUri := seg.URI
 			var remainingPartialSegments []*PartialSegment
-			for _, ps := range p.PartialSegments {
+			for _, ps := range partials {
 				if isPartOf(ps.URI, fullSegUri) {
 					// This partial segment is part of the current full segment
-					writePartialSegment(&p.buf, ps, p.WritePrecision())
+					writePartialSegment(buf, ps, p.WritePrecision(), rewrite)
 				} else {
 					// This partial segment does not belong to current full segment
 					// Keep it to be written later
@@ -1102,40 +1216,36 @@ func (p *MediaPlaylist) encode(segmentsToSkipInTotal uint64) *bytes.Buffer {
 				}
 			}
 			// Update the PartialSegments list to exclude the completed ones
-			p.PartialSegments = remainingPartialSegments
+			partials = remainingPartialSegments
 		}
 
 		if seg.Limit > 0 {
-			writeRange(&p.buf, "#EXT-X-BYTERANGE:", seg.Limit, seg.Offset)
-			p.buf.WriteRune('\n')
+			writeRange(buf, "#EXT-X-BYTERANGE:", seg.Limit, seg.Offset)
+			buf.WriteRune('\n')
 		}
 
 		// Add Custom Segment Tags here
 		if seg.Custom != nil {
 			for _, v := range seg.Custom {
 				if customBuf := v.Encode(); customBuf != nil {
-					p.buf.WriteString(customBuf.String())
-					p.buf.WriteRune('\n')
+					buf.WriteString(customBuf.String())
+					buf.WriteRune('\n')
 				}
 			}
 		}
 
-		writeExtInfWithCache(&p.buf, seg.Duration, seg.Title, p.WritePrecision(), durationCache)
+		writeExtInfWithCache(buf, seg.Duration, seg.Title, p.WritePrecision(), durationCache)
 
-		p.buf.WriteString(seg.URI)
-		if p.Args != "" {
-			p.buf.WriteRune('?')
-			p.buf.WriteString(p.Args)
-		}
-		p.buf.WriteRune('\n')
+		writeURIWithArgs(buf, rewrite.apply(URISegment, seg.URI), p.Args)
+		buf.WriteRune('\n')
 	}
 
 	// handle remaining partial segments
-	if p.HasPartialSegments() {
-		for _, ps := range p.PartialSegments {
+	if len(partials) > 0 {
+		for _, ps := range partials {
 			if ps.SeqID >= lastSegId {
 				// This partial segment is part of the next segment
-				writePartialSegment(&p.buf, ps, p.WritePrecision())
+				writePartialSegment(buf, ps, p.WritePrecision(), rewrite)
 			} else {
 				// This partial segment does not belong to any segment
 				// and should be ignored
@@ -1145,16 +1255,16 @@ func (p *MediaPlaylist) encode(segmentsToSkipInTotal uint64) *bytes.Buffer {
 	}
 
 	if p.PreloadHints != nil {
-		writePreloadHint(&p.buf, p.PreloadHints)
+		writePreloadHint(buf, p.PreloadHints, rewrite)
 	}
 
 	if p.Closed {
-		p.buf.WriteString("#EXT-X-ENDLIST\n")
+		buf.WriteString("#EXT-X-ENDLIST\n")
 	}
 	for _, dr := range p.DateRanges {
-		writeDateRange(&p.buf, dr, p.WritePrecision())
+		writeDateRange(buf, dr, p.WritePrecision(), rewrite)
 	}
-	return &p.buf
+	return newHead, partials
 }
 
 // EncodeWithSkip sets the skip tag and encodes the playlist.
@@ -1228,22 +1338,10 @@ func (p *MediaPlaylist) CalculateTargetDuration(hlsVer uint8) uint {
 		return 0
 	}
 	var max float64
-	if p.tail >= p.head {
-		for i := p.head; i < p.tail; i++ {
-			if p.Segments[i].Duration > max {
-				max = p.Segments[i].Duration
-			}
-		}
-	} else {
-		for i := p.head; i < p.capacity; i++ {
-			if p.Segments[i].Duration > max {
-				max = p.Segments[i].Duration
-			}
-		}
-		for i := uint(0); i < p.tail; i++ {
-			if p.Segments[i].Duration > max {
-				max = p.Segments[i].Duration
-			}
+	for i := uint(0); i < p.count; i++ {
+		seg := p.Segments[(p.head+i)%p.capacity]
+		if seg != nil && seg.Duration > max {
+			max = seg.Duration
 		}
 	}
 	return calcNewTargetDuration(max, hlsVer, 0)
@@ -1426,6 +1524,53 @@ func (p *MediaPlaylist) SetWinSize(winsize uint) error {
 	return nil
 }
 
+// SetWinDuration sets a sliding window defined by duration instead of by number of segments.
+// The window consists of the latest segments whose total duration is at least winDuration
+// seconds and at least minTargetDurations times the target duration. Segments outside the
+// window are removed when segments are appended, and right away for the current segments.
+// EXT-X-DISCONTINUITY-SEQUENCE, the playlist keys and map, and the program date time of the
+// new first segment are updated for the removed segments. Closed playlists are not trimmed.
+// It overrides winsize for Encode and Slide. Set winDuration to 0 to go back to winsize.
+func (p *MediaPlaylist) SetWinDuration(winDuration float64, minTargetDurations uint) error {
+	if winDuration < 0 {
+		return fmt.Errorf("winDuration=%f: %w", winDuration, ErrWinDurationNegative)
+	}
+	p.winDuration = winDuration
+	p.winMinTargetDurs = minTargetDurations
+	if winDuration > 0 && !p.Closed {
+		p.trimWindow()
+	}
+	p.buf.Reset()
+	return nil
+}
+
+// WinDuration returns the duration in seconds of the sliding window and the
+// minimum number of target durations it spans. A zero duration means that winsize is used.
+func (p *MediaPlaylist) WinDuration() (winDuration float64, minTargetDurations uint) {
+	return p.winDuration, p.winMinTargetDurs
+}
+
+// winCount returns the number of latest segments in the sliding window.
+func (p *MediaPlaylist) winCount() uint {
+	if p.winDuration <= 0 {
+		return min(p.winsize, p.count)
+	}
+	minDur := max(p.winDuration, float64(p.winMinTargetDurs*p.TargetDuration))
+	var total float64
+	var n uint
+	for n < p.count {
+		seg := p.Segments[(p.head+p.count-1-n)%p.capacity]
+		n++
+		if seg != nil {
+			total += seg.Duration
+		}
+		if total >= minDur {
+			break
+		}
+	}
+	return n
+}
+
 func (p *MediaPlaylist) SetServerControl(control *ServerControl) error {
 	if control.CanSkipUntil > 0 {
 		skipUntil := control.CanSkipUntil
@@ -1443,24 +1588,15 @@ func (p *MediaPlaylist) SetServerControl(control *ServerControl) error {
 	return nil
 }
 
-// GetAllSegments could get all segments currently added to playlist.
+// GetAllSegments could get all segments currently added to playlist in playlist order.
 // Winsize is ignored.
 func (p *MediaPlaylist) GetAllSegments() []*MediaSegment {
 	if p.count == 0 {
 		return nil
 	}
 	buf := make([]*MediaSegment, 0, p.count)
-	if p.head < p.tail {
-		for i := p.head; i < p.tail; i++ {
-			buf = append(buf, p.Segments[i])
-		}
-		return buf
-	}
-	for i := uint(0); i < p.tail; i++ {
-		buf = append(buf, p.Segments[i])
-	}
-	for i := p.head; i < p.capacity; i++ {
-		buf = append(buf, p.Segments[i])
+	for i := uint(0); i < p.count; i++ {
+		buf = append(buf, p.Segments[(p.head+i)%p.capacity])
 	}
 	return buf
 }
@@ -1548,6 +1684,20 @@ func isPartOf(partialSegUri, segUri string) bool {
 	return parSegNumExist && segNumExist && parSegNum == segNum
 }
 
+// writeURIWithArgs writes uri with the query parameters args appended to its query.
+func writeURIWithArgs(buf *bytes.Buffer, uri, args string) {
+	buf.WriteString(uri)
+	if args == "" {
+		return
+	}
+	if strings.Contains(uri, "?") {
+		buf.WriteRune('&')
+	} else {
+		buf.WriteRune('?')
+	}
+	buf.WriteString(args)
+}
+
 func min(a, b uint) uint {
 	if a < b {
 		return a
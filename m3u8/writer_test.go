@@ -847,6 +847,63 @@ func TestMediaSetWinSize(t *testing.T) {
 	is.Equal(m.WinSize(), uint(5)) // WinSize did not stay 5
 }
 
+func TestMediaSetWinDuration(t *testing.T) {
+	is := is.New(t)
+	m, _ := NewMediaPlaylist(3, 20)
+	err := m.SetWinDuration(-1, 0)
+	is.True(err != nil) // negative window duration must fail
+	err = m.SetWinDuration(20, 3)
+	is.NoErr(err) // set window duration failed
+	winDur, minTDs := m.WinDuration()
+	is.Equal(winDur, 20.0)    // window duration does not match expected 20
+	is.Equal(minTDs, uint(3)) // minimum target durations does not match expected 3
+	for i := 0; i < 10; i++ {
+		m.Slide(fmt.Sprintf("t%02d.ts", i), 4, "")
+	}
+	// 5 segments of 4s cover 20s
+	is.Equal(m.Count(), uint(5)) // count of segments not 5
+	is.Equal(m.SeqNo, uint64(5)) // SeqNo of media playlist not 5
+	out := m.String()
+	is.True(!strings.Contains(out, "t04.ts")) // evicted segment in output
+	is.True(strings.Contains(out, "t05.ts"))  // first segment in window missing
+	is.True(strings.Contains(out, "t09.ts"))  // last segment in window missing
+
+	// Longer segments raise the minimum window to three target durations
+	for i := 10; i < 20; i++ {
+		m.Slide(fmt.Sprintf("t%02d.ts", i), 10, "")
+	}
+	is.Equal(m.TargetDuration, uint(10)) // target duration not 10
+	is.Equal(m.Count(), uint(3))         // count of segments not 3
+	is.Equal(m.SeqNo, uint64(17))        // SeqNo of media playlist not 17
+
+	// Switch back to winsize
+	err = m.SetWinDuration(0, 0)
+	is.NoErr(err) // reset window duration failed
+	m.Slide("t20.ts", 10, "")
+	is.Equal(m.Count(), uint(3)) // count of segments not 3 with winsize
+}
+
+func TestMediaWinDurationWithAppend(t *testing.T) {
+	is := is.New(t)
+	m, _ := NewMediaPlaylist(0, 20)
+	for i := 0; i < 4; i++ {
+		is.NoErr(m.Append(fmt.Sprintf("t%02d.ts", i), 4, ""))
+	}
+	is.NoErr(m.SetWinDuration(8, 0))
+	is.Equal(m.Count(), uint(2)) // trimmed when the window is set
+	is.Equal(m.SeqNo, uint64(2))
+	for i := 4; i < 10; i++ {
+		is.NoErr(m.Append(fmt.Sprintf("t%02d.ts", i), 4, ""))
+	}
+	is.Equal(m.Count(), uint(2)) // trimmed on append
+	is.Equal(m.SeqNo, uint64(8)) // SeqNo follows the removed segments
+	out := m.String()
+	is.True(strings.Contains(out, "#EXT-X-MEDIA-SEQUENCE:8\n"))
+	is.True(!strings.Contains(out, "t07.ts")) // evicted segment in output
+	is.True(strings.Contains(out, "t08.ts"))  // first segment in window missing
+	is.Equal(m.GetAllSegments()[0].URI, "t08.ts")
+}
+
 func TestIndependentSegments(t *testing.T) {
 	is := is.New(t)
 	m := NewMasterPlaylist()
@@ -867,6 +924,31 @@ func TestStartTimeOffset(t *testing.T) {
 	is.True(strings.Contains(p.String(), expected)) // start time offset is not included in the playlist
 }
 
+func TestMediaPlaylistSlideKeepsKeys(t *testing.T) {
+	is := is.New(t)
+	m, err := NewMediaPlaylist(2, 3)
+	is.NoErr(err)
+	pdt := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	m.Slide("t00.ts", 10, "")
+	is.NoErr(m.SetProgramDateTime(pdt))
+	is.NoErr(m.SetKey("AES-128", "key1.bin", "", "", ""))
+	is.NoErr(m.SetMap("init1.mp4", 0, 0))
+	m.Slide("t01.ts", 10, "")
+	is.NoErr(m.SetDiscontinuity())
+	m.Slide("t02.ts", 10, "")
+	m.Slide("t03.ts", 10, "")
+
+	is.Equal(m.SeqNo, uint64(2))            // two segments removed
+	is.Equal(m.Keys[0].URI, "key1.bin")     // key of removed segment kept
+	is.Equal(m.DiscontinuitySeq, uint64(0)) // discontinuity sequence unchanged
+	is.Equal(m.Map, (*Map)(nil))            // map of removed segment not carried over
+	out := m.String()
+	is.True(strings.Contains(out, `#EXT-X-KEY:METHOD=AES-128,URI="key1.bin"`))
+	is.True(!strings.Contains(out, "#EXT-X-DISCONTINUITY-SEQUENCE")) // no discontinuity sequence written
+	is.True(!strings.Contains(out, "#EXT-X-PROGRAM-DATE-TIME"))      // program date time not moved on
+	is.True(!strings.Contains(out, "#EXT-X-MAP"))                    // map not carried over
+}
+
 func TestMediaPlaylist_Slide(t *testing.T) {
 	is := is.New(t)
 	m, e := NewMediaPlaylist(3, 4)